@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ========== 機場資料集 ==========
+//
+// datasets/airports.csv 隨程式一起編譯，欄位沿用 OurAirports 的命名：
+//
+//	iata_code      機場或都市代碼
+//	type           large_airport / medium_airport / city (多機場都市，例如 TYO、LON)
+//	municipality   所在都市
+//	city_code      所屬的都市代碼，例如 HND、NRT 都是 TYO
+//	aliases        以 | 分隔的繁中、簡中、日文與英文別名
+//
+// 查詢時先把輸入正規化 (全形轉半形、小寫、去掉空白標點與「機場」「空港」「airport」等字尾)，
+// 再和名稱、都市、別名比對：完全相同 > 包含 > 編輯距離 (容許打錯字)，
+// 回傳依信心分數排序的候選。
+
+//go:embed datasets/airports.csv
+var airportsCSV []byte
+
+// Airport 一個機場或多機場都市
+type Airport struct {
+	Code     string   `json:"code"`
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	City     string   `json:"city"`
+	CityCode string   `json:"city_code"`
+	Country  string   `json:"country"`
+	Lat      float64  `json:"lat"`
+	Lng      float64  `json:"lng"`
+	Aliases  []string `json:"-"`
+}
+
+// AirportMatch 查詢結果，Confidence 介於 0 ~ 1
+type AirportMatch struct {
+	Airport
+	Confidence float64 `json:"confidence"`
+	MatchedOn  string  `json:"matched_on"` // 比對到的名稱或別名
+}
+
+// 各種比對方式的分數
+const (
+	airportScoreCode     = 1.0  // 輸入就是代碼
+	airportScoreCityCode = 0.8  // 輸入是所屬的都市代碼 (例如 TYO 之於 HND)
+	airportScoreExact    = 0.95 // 名稱或別名完全相同
+	airportScoreCity     = 0.9  // 所在都市相同
+	airportMinConfidence = 0.5  // 低於此分數不列為候選
+)
+
+// 正規化時去掉的字尾 (正規化後的形式)，會重複去到沒有為止
+var airportSuffixes = []string{
+	"國際機場", "国际机场", "国際空港", "機場", "机场", "空港",
+	"international", "airport", "intl", "市",
+}
+
+type airportKey struct {
+	raw   string
+	norm  []rune
+	score float64 // 完全相同時的分數
+}
+
+type airportEntry struct {
+	Airport
+	keys []airportKey
+}
+
+// airportIndex 載入後的資料集
+type airportIndex struct {
+	entries []airportEntry
+	byCode  map[string]int
+}
+
+var airports = mustLoadAirports(airportsCSV)
+
+func mustLoadAirports(data []byte) *airportIndex {
+	idx, err := loadAirports(data)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+func loadAirports(data []byte) (*airportIndex, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("airports.csv: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, name := range []string{"iata_code", "type", "name", "municipality", "city_code", "iso_country", "latitude_deg", "longitude_deg", "aliases"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("airports.csv: 缺少欄位 %s", name)
+		}
+	}
+
+	idx := &airportIndex{byCode: map[string]int{}}
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("airports.csv: %w", err)
+		}
+		a := Airport{
+			Code:     strings.ToUpper(rec[col["iata_code"]]),
+			Type:     rec[col["type"]],
+			Name:     rec[col["name"]],
+			City:     rec[col["municipality"]],
+			CityCode: strings.ToUpper(rec[col["city_code"]]),
+			Country:  rec[col["iso_country"]],
+		}
+		if !isIATACode(a.Code) {
+			return nil, fmt.Errorf("airports.csv:%d: 代碼 %q 不合法", line, a.Code)
+		}
+		if _, dup := idx.byCode[a.Code]; dup {
+			return nil, fmt.Errorf("airports.csv:%d: 代碼 %s 重複", line, a.Code)
+		}
+		if a.Lat, err = strconv.ParseFloat(rec[col["latitude_deg"]], 64); err != nil {
+			return nil, fmt.Errorf("airports.csv:%d: %w", line, err)
+		}
+		if a.Lng, err = strconv.ParseFloat(rec[col["longitude_deg"]], 64); err != nil {
+			return nil, fmt.Errorf("airports.csv:%d: %w", line, err)
+		}
+		if s := rec[col["aliases"]]; s != "" {
+			a.Aliases = strings.Split(s, "|")
+		}
+
+		e := airportEntry{Airport: a}
+		add := func(raw string, score float64) {
+			if n := normalizeAirportQuery(raw); n != "" {
+				e.keys = append(e.keys, airportKey{raw: raw, norm: []rune(n), score: score})
+			}
+		}
+		add(a.Name, airportScoreExact)
+		add(a.City, airportScoreCity)
+		for _, alias := range a.Aliases {
+			add(alias, airportScoreExact)
+		}
+
+		idx.byCode[a.Code] = len(idx.entries)
+		idx.entries = append(idx.entries, e)
+	}
+	return idx, nil
+}
+
+// isIATACode 是否為 3 個大寫英文字母
+func isIATACode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAirportQuery 全形轉半形、轉小寫、去掉空白與標點，再去掉「機場」「airport」等字尾
+func normalizeAirportQuery(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '　':
+			continue
+		case r >= '！' && r <= '～':
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	out := b.String()
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, suf := range airportSuffixes {
+			// 至少留下 2 個字，避免「市」這種輸入被清空
+			if strings.HasSuffix(out, suf) && utf8.RuneCountInString(out)-utf8.RuneCountInString(suf) >= 2 {
+				out = strings.TrimSuffix(out, suf)
+				trimmed = true
+			}
+		}
+	}
+	return out
+}
+
+// ByCode 依代碼查詢 (不分大小寫)
+func (idx *airportIndex) ByCode(code string) (Airport, bool) {
+	i, ok := idx.byCode[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return Airport{}, false
+	}
+	return idx.entries[i].Airport, true
+}
+
+// Lookup 模糊查詢，回傳依信心分數排序的最多 limit 筆候選
+func (idx *airportIndex) Lookup(query string, limit int) []AirportMatch {
+	q := []rune(normalizeAirportQuery(query))
+	code := strings.ToUpper(string(q)) // 全形的代碼也接受
+	if len(q) == 0 {
+		return []AirportMatch{}
+	}
+
+	matches := []AirportMatch{}
+	for _, e := range idx.entries {
+		best, on := 0.0, ""
+		switch {
+		case e.Code == code:
+			best, on = airportScoreCode, e.Code
+		case e.CityCode == code:
+			best, on = airportScoreCityCode, e.CityCode
+		}
+		for _, k := range e.keys {
+			if s := matchAirportKey(q, k); s > best {
+				best, on = s, k.raw
+			}
+		}
+		if best < airportMinConfidence {
+			continue
+		}
+		// 同分時多機場都市優先，其次是大型機場
+		switch e.Type {
+		case "city":
+			best += 0.02
+		case "large_airport":
+			best += 0.01
+		}
+		matches = append(matches, AirportMatch{Airport: e.Airport, Confidence: roundConfidence(min(best, 1)), MatchedOn: on})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Confidence != matches[j].Confidence {
+			return matches[i].Confidence > matches[j].Confidence
+		}
+		return matches[i].Code < matches[j].Code
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// matchAirportKey 正規化後的輸入與一個名稱的分數
+func matchAirportKey(q []rune, k airportKey) float64 {
+	lq, lk := len(q), len(k.norm)
+	qs, ks := string(q), string(k.norm)
+	if qs == ks {
+		return k.score
+	}
+
+	score := 0.0
+	// 互相包含：「台北」之於「台北松山」、「東京成田」之於「成田」
+	if lq >= 2 && strings.Contains(ks, qs) {
+		score = 0.6 + 0.3*float64(lq)/float64(lk)
+	} else if lk >= 2 && strings.Contains(qs, ks) {
+		score = 0.6 + 0.3*float64(lk)/float64(lq)
+	}
+	// 打錯字：以編輯距離換算相似度
+	if longest := max(lq, lk); longest >= 3 {
+		sim := 1 - float64(editDistance(q, k.norm))/float64(longest)
+		if sim >= 0.6 {
+			score = max(score, 0.9*sim)
+		}
+	}
+	return score
+}
+
+// AirportDistance 與指定座標的距離
+type AirportDistance struct {
+	Airport
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// Nearest 依距離列出最近的 limit 個機場 (不含都市代碼)
+func (idx *airportIndex) Nearest(lat, lng float64, limit int) []AirportDistance {
+	out := []AirportDistance{}
+	for _, e := range idx.entries {
+		if e.Type == "city" {
+			continue
+		}
+		out = append(out, AirportDistance{Airport: e.Airport, DistanceKm: haversineKm(lat, lng, e.Lat, e.Lng)})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].DistanceKm < out[j].DistanceKm })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	for i := range out {
+		out[i].DistanceKm = math.Round(out[i].DistanceKm*10) / 10
+	}
+	return out
+}
+
+// haversineKm 兩點間的大圓距離 (公里)
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat, dLng := rad(lat2-lat1), rad(lng2-lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// editDistance 字元層級的編輯距離，相鄰兩字對調算一次
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func roundConfidence(f float64) float64 {
+	return float64(int(f*100+0.5)) / 100
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAirportLookup(t *testing.T) {
+	for _, tt := range []struct {
+		query, want string
+		minConf     float64
+	}{
+		{"TPE", "TPE", 1},
+		{"ｔｙｏ", "TYO", 1},
+		{"東京", "TYO", 0.95},
+		{"東京都", "TYO", 0.95},
+		{"台北市", "TPE", 0.95},
+		{"桃園國際機場", "TPE", 0.95},
+		{"關西", "KIX", 0.95},
+		{"関西空港", "KIX", 0.95},
+		{"成田国際空港", "NRT", 0.95},
+		{"Narita International Airport", "NRT", 0.95},
+		{"ソウル", "SEL", 0.95},
+		{"北海道", "SPK", 0.95},
+		{"京都", "OSA", 0.95},
+		{"松山", "TSA", 0.95},
+		{"浦东机场", "PVG", 0.95},
+		{"Londn", "LON", iataDatasetMinConfidence},
+		{"tokoy", "TYO", iataDatasetMinConfidence},
+		{"Reykjavík", "KEF", iataDatasetMinConfidence},
+	} {
+		got := airports.Lookup(tt.query, 3)
+		if len(got) == 0 || got[0].Code != tt.want || got[0].Confidence < tt.minConf {
+			t.Errorf("Lookup(%q) = %+v, want %s >= %.2f", tt.query, got, tt.want, tt.minConf)
+		}
+	}
+
+	// 多機場都市：都市代碼排第一，所屬機場跟在後面
+	got := airports.Lookup("nyc", 5)
+	var codes []string
+	for _, m := range got {
+		codes = append(codes, m.Code)
+	}
+	if strings.Join(codes, ",") != "NYC,EWR,JFK,LGA" {
+		t.Errorf("nyc = %v", codes)
+	}
+
+	for _, q := range []string{"亞特蘭大", "xyz", "  "} {
+		if got := airports.Lookup(q, 3); len(got) != 0 {
+			t.Errorf("Lookup(%q) = %+v, want none", q, got)
+		}
+	}
+}
+
+func TestLoadAirports(t *testing.T) {
+	if _, ok := airports.ByCode("hnd"); !ok {
+		t.Error("HND not found")
+	}
+	for _, e := range airports.entries {
+		if _, ok := airports.ByCode(e.CityCode); !ok && e.CityCode != "JKT" && e.CityCode != "REK" && e.CityCode != "SAO" {
+			t.Errorf("%s: city_code %s 不在資料集", e.Code, e.CityCode)
+		}
+		if e.Lat < -90 || e.Lat > 90 || e.Lng < -180 || e.Lng > 180 {
+			t.Errorf("%s: 座標 %v,%v", e.Code, e.Lat, e.Lng)
+		}
+	}
+
+	header := "iata_code,type,name,municipality,city_code,iso_country,latitude_deg,longitude_deg,aliases\n"
+	for name, data := range map[string]string{
+		"missing column": "iata_code,name\nTPE,Taoyuan\n",
+		"bad code":       header + "TP,large_airport,x,x,TP,TW,25,121,\n",
+		"duplicate":      header + "TPE,large_airport,x,x,TPE,TW,25,121,\nTPE,large_airport,y,y,TPE,TW,25,121,\n",
+		"bad latitude":   header + "TPE,large_airport,x,x,TPE,TW,north,121,\n",
+	} {
+		if _, err := loadAirports([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestNormalizeAirportQuery(t *testing.T) {
+	for in, want := range map[string]string{
+		"  Tokyo Haneda Airport ": "tokyohaneda",
+		"ＮＲＴ":                     "nrt",
+		"台北市":                     "台北",
+		"市":                       "市",
+		"Xi'an":                   "xian",
+		"シャルル・ド・ゴール":              "シャルルドゴール",
+	} {
+		if got := normalizeAirportQuery(in); got != want {
+			t.Errorf("normalizeAirportQuery(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if d := editDistance([]rune("tokoy"), []rune("tokyo")); d != 1 {
+		t.Errorf("transposition distance = %d", d)
+	}
+}
+
+func TestResolveLLMIATA(t *testing.T) {
+	candidates := airports.Lookup("大板", 3) // 打錯字，資料集信心不足
+
+	res := resolveLLMIATA(candidates, "KIX\n")
+	if res.Code != "KIX" || res.Source != "llm" || !res.Verified || res.Candidates[0].Code != "KIX" {
+		t.Errorf("known code = %+v", res)
+	}
+
+	res = resolveLLMIATA(candidates, "The code is REP.")
+	if res.Code != "REP" || res.Verified || res.Confidence != iataLLMUnverifiedConfidence {
+		t.Errorf("unknown code = %+v", res)
+	}
+
+	res = resolveLLMIATA(candidates, "UNK")
+	if res.Source != "dataset" || res.LLMCode != "UNK" {
+		t.Errorf("UNK = %+v", res)
+	}
+	if len(candidates) > 0 && res.Code != candidates[0].Code {
+		t.Errorf("UNK should fall back to %s, got %s", candidates[0].Code, res.Code)
+	}
+
+	if res := resolveLLMIATA(nil, "not sure"); res.Code != "UNK" || res.Verified {
+		t.Errorf("no candidates = %+v", res)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ========== LRU 快取 ==========
+//
+// ttlCache 是有容量上限的 LRU，每筆資料有各自的過期時間：
+//
+//   - 查無結果也會快取 (negative cache)，但保留時間較短
+//   - 同一個 key 同時只會有一個查詢在進行，其他請求等待同一個結果 (coalesced)
+//   - 可選擇寫入 cacheStore (檔案或 Mongo)，重新啟動後以 Restore 載回
+//
+// 所有快取都會登記在 caches，統計數字由 GET /api/admin/cache 提供。
+
+// cacheStats 快取的統計數字
+type cacheStats struct {
+	Name         string `json:"name"`
+	Store        string `json:"store"`
+	Size         int    `json:"size"`
+	Capacity     int    `json:"capacity"`
+	Hits         int64  `json:"hits"`
+	NegativeHits int64  `json:"negative_hits"` // 命中「查無結果」的快取
+	Misses       int64  `json:"misses"`
+	Coalesced    int64  `json:"coalesced"` // 等待其他請求進行中的查詢
+	LoadErrors   int64  `json:"load_errors"`
+	Evictions    int64  `json:"evictions"`
+	Expirations  int64  `json:"expirations"`
+	Restored     int64  `json:"restored"`
+}
+
+// cacheRecord 寫入 cacheStore 的一筆資料，Value 為 JSON
+type cacheRecord struct {
+	Key       string    `json:"key" bson:"key"`
+	Value     string    `json:"value,omitempty" bson:"value,omitempty"`
+	Miss      bool      `json:"miss,omitempty" bson:"miss,omitempty"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// cacheStore 快取的持久化
+type cacheStore interface {
+	Name() string
+	Load(ctx context.Context) ([]cacheRecord, error)
+	Put(ctx context.Context, rec cacheRecord) error
+	Delete(ctx context.Context, key string) error
+}
+
+type cacheEntry[V any] struct {
+	key       string
+	value     V
+	miss      bool
+	expiresAt time.Time
+}
+
+// cacheCall 進行中的查詢
+type cacheCall[V any] struct {
+	done  chan struct{}
+	value V
+	found bool
+	err   error
+}
+
+type ttlCache[V any] struct {
+	name     string
+	capacity int
+	ttl      time.Duration
+	missTTL  time.Duration
+	store    cacheStore
+	now      func() time.Time
+
+	mu       sync.Mutex
+	ll       *list.List // 最近使用的在前面
+	items    map[string]*list.Element
+	inflight map[string]*cacheCall[V]
+	stats    cacheStats
+}
+
+// caches 已建立的快取，依名稱登記
+var (
+	cachesMu sync.Mutex
+	caches   = map[string]interface{ Stats() cacheStats }{}
+)
+
+// newTTLCache 建立快取並登記；同名的快取會取代舊的。store 可為 nil
+func newTTLCache[V any](name string, capacity int, ttl, missTTL time.Duration, store cacheStore) *ttlCache[V] {
+	c := &ttlCache[V]{
+		name:     name,
+		capacity: max(capacity, 1),
+		ttl:      ttl,
+		missTTL:  missTTL,
+		store:    store,
+		now:      time.Now,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		inflight: map[string]*cacheCall[V]{},
+	}
+	cachesMu.Lock()
+	caches[name] = c
+	cachesMu.Unlock()
+	return c
+}
+
+// allCacheStats 依名稱排序的所有快取統計
+func allCacheStats() []cacheStats {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	out := []cacheStats{}
+	for _, c := range caches {
+		out = append(out, c.Stats())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (c *ttlCache[V]) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Name, s.Size, s.Capacity, s.Store = c.name, c.ll.Len(), c.capacity, "memory"
+	if c.store != nil {
+		s.Store = c.store.Name()
+	}
+	return s
+}
+
+// lookup 在 c.mu 鎖住時呼叫，過期的資料會順便刪除
+func (c *ttlCache[V]) lookup(key string) (*cacheEntry[V], bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry[V])
+	if !c.now().Before(e.expiresAt) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		c.stats.Expirations++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e, true
+}
+
+// set 在 c.mu 鎖住時呼叫，回傳被擠出的 key
+func (c *ttlCache[V]) set(e *cacheEntry[V]) []string {
+	if el, ok := c.items[e.key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return nil
+	}
+	c.items[e.key] = c.ll.PushFront(e)
+	var evicted []string
+	for c.ll.Len() > c.capacity {
+		last := c.ll.Back()
+		old := last.Value.(*cacheEntry[V])
+		c.ll.Remove(last)
+		delete(c.items, old.key)
+		c.stats.Evictions++
+		evicted = append(evicted, old.key)
+	}
+	return evicted
+}
+
+// Get 只查快取；found 為 false 代表快取的是「查無結果」
+func (c *ttlCache[V]) Get(key string) (value V, found, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return value, false, false
+	}
+	return e.value, !e.miss, true
+}
+
+// newEntry 依 found 選擇 ttl 或 missTTL
+func (c *ttlCache[V]) newEntry(key string, value V, found bool) *cacheEntry[V] {
+	ttl := c.ttl
+	if !found {
+		ttl = c.missTTL
+	}
+	return &cacheEntry[V]{key: key, value: value, miss: !found, expiresAt: c.now().Add(ttl)}
+}
+
+// Set 寫入快取；found 為 false 時以 missTTL 快取「查無結果」
+func (c *ttlCache[V]) Set(ctx context.Context, key string, value V, found bool) {
+	e := c.newEntry(key, value, found)
+	c.mu.Lock()
+	evicted := c.set(e)
+	c.mu.Unlock()
+	c.persist(ctx, e, evicted)
+}
+
+// persist 寫入 store；失敗只影響重新啟動後的命中率，不回傳錯誤
+func (c *ttlCache[V]) persist(ctx context.Context, e *cacheEntry[V], evicted []string) {
+	if c.store == nil {
+		return
+	}
+	rec := cacheRecord{Key: e.key, Miss: e.miss, ExpiresAt: e.expiresAt}
+	if !e.miss {
+		data, err := json.Marshal(e.value)
+		if err != nil {
+			return
+		}
+		rec.Value = string(data)
+	}
+	if err := c.store.Put(ctx, rec); err != nil {
+		logCacheStoreError(c.name, err)
+	}
+	for _, key := range evicted {
+		if err := c.store.Delete(ctx, key); err != nil {
+			logCacheStoreError(c.name, err)
+		}
+	}
+}
+
+// GetOrLoad 查快取，沒有時呼叫 load；同一個 key 同時只會有一個 load 在執行。
+// load 回傳 found=false 代表查無結果 (會被快取)，回傳錯誤則不快取
+func (c *ttlCache[V]) GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) (V, bool, error)) (V, bool, error) {
+	c.mu.Lock()
+	if e, ok := c.lookup(key); ok {
+		if e.miss {
+			c.stats.NegativeHits++
+		} else {
+			c.stats.Hits++
+		}
+		c.mu.Unlock()
+		return e.value, !e.miss, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.stats.Coalesced++
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.found, call.err
+		case <-ctx.Done():
+			var zero V
+			return zero, false, ctx.Err()
+		}
+	}
+	call := &cacheCall[V]{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// 其他請求也在等這個結果，不跟著第一個請求一起取消
+	call.value, call.found, call.err = load(context.WithoutCancel(ctx))
+
+	// 先寫入快取再移除 inflight，之後來的請求一定會命中其中一個
+	var e *cacheEntry[V]
+	var evicted []string
+	c.mu.Lock()
+	if call.err != nil {
+		c.stats.LoadErrors++
+	} else {
+		e = c.newEntry(key, call.value, call.found)
+		evicted = c.set(e)
+	}
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(call.done)
+
+	if e != nil {
+		c.persist(context.WithoutCancel(ctx), e, evicted)
+	}
+	return call.value, call.found, call.err
+}
+
+// Restore 由 store 載回未過期的資料
+func (c *ttlCache[V]) Restore(ctx context.Context) (int, error) {
+	if c.store == nil {
+		return 0, nil
+	}
+	recs, err := c.store.Load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// 依過期時間由早到晚加入，容量不足時留下較新的
+	sort.Slice(recs, func(i, j int) bool { return recs[i].ExpiresAt.Before(recs[j].ExpiresAt) })
+	now := c.now()
+	n := 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rec := range recs {
+		if !now.Before(rec.ExpiresAt) {
+			continue
+		}
+		e := &cacheEntry[V]{key: rec.Key, miss: rec.Miss, expiresAt: rec.ExpiresAt}
+		if !rec.Miss {
+			if err := json.Unmarshal([]byte(rec.Value), &e.value); err != nil {
+				continue // 格式改過的舊資料直接略過
+			}
+		}
+		c.set(e)
+		n++
+	}
+	c.stats.Restored += int64(n)
+	return n, nil
+}
+
+func logCacheStoreError(name string, err error) {
+	if !errors.Is(err, context.Canceled) {
+		log.Printf("cache %s: store: %v", name, err)
+	}
+}
+
+// ========== 快取持久化 ==========
+
+// cacheStoreFromEnv 依 <prefix>_CACHE_STORE 選擇持久化方式：memory (回傳 nil) / file / mongo。
+// file 存在 <prefix>_CACHE_FILE (預設 ../data/cache/<name>.json)，mongo 存在 <name>_cache collection；
+// 需在 initMongo 之後呼叫
+func cacheStoreFromEnv(ctx context.Context, prefix, name, defaultStore string) cacheStore {
+	switch store := envOr(prefix+"_CACHE_STORE", defaultStore); store {
+	case "file":
+		return newFileCacheStore(envOr(prefix+"_CACHE_FILE", "../data/cache/"+name+".json"))
+	case "mongo":
+		s, err := newMongoCacheStore(ctx, mongoClient.Database("go_travel").Collection(name+"_cache"))
+		if err != nil {
+			log.Printf("%s_cache 索引建立失敗: %v", name, err)
+		}
+		return s
+	case "memory":
+	default:
+		log.Printf("未知的 %s_CACHE_STORE=%s，只使用記憶體快取", prefix, store)
+	}
+	return nil
+}
+
+// fileCacheFlushDelay 檔案快取合併寫入的間隔
+const fileCacheFlushDelay = 2 * time.Second
+
+// fileCacheStore 把所有資料存成一個 JSON 檔 (先寫暫存檔再 rename)。
+// Put / Delete 只更新記憶體，最多 delay 後在背景合併成一次寫入，不佔用請求的時間；
+// 程式在這段時間內結束時，最後幾筆資料不會寫入檔案，只影響重新啟動後的命中率
+type fileCacheStore struct {
+	path  string
+	delay time.Duration // 0 表示每次都直接寫入
+
+	mu      sync.Mutex
+	records map[string]cacheRecord
+	loaded  bool
+	dirty   bool
+	timer   *time.Timer
+}
+
+func newFileCacheStore(path string) *fileCacheStore {
+	return &fileCacheStore{path: path, delay: fileCacheFlushDelay, records: map[string]cacheRecord{}}
+}
+
+func (s *fileCacheStore) Name() string { return "file" }
+
+func (s *fileCacheStore) loadLocked() error {
+	if s.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.loaded = true
+	if len(data) == 0 {
+		return nil
+	}
+	var recs []cacheRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		s.records[rec.Key] = rec
+	}
+	return nil
+}
+
+func (s *fileCacheStore) Load(ctx context.Context) ([]cacheRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadLocked(); err != nil {
+		return nil, err
+	}
+	out := make([]cacheRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func (s *fileCacheStore) Put(ctx context.Context, rec cacheRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadLocked(); err != nil {
+		return err
+	}
+	s.records[rec.Key] = rec
+	return s.scheduleLocked()
+}
+
+func (s *fileCacheStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadLocked(); err != nil {
+		return err
+	}
+	delete(s.records, key)
+	return s.scheduleLocked()
+}
+
+// scheduleLocked 標記有變更並排定寫入
+func (s *fileCacheStore) scheduleLocked() error {
+	s.dirty = true
+	if s.delay <= 0 {
+		return s.writeLocked()
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.timer = nil
+			if err := s.writeLocked(); err != nil {
+				logCacheStoreError(s.path, err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush 立即寫入尚未寫入的變更
+func (s *fileCacheStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return s.writeLocked()
+}
+
+func (s *fileCacheStore) writeLocked() error {
+	if !s.dirty {
+		return nil
+	}
+	now := time.Now()
+	recs := make([]cacheRecord, 0, len(s.records))
+	for key, rec := range s.records {
+		if !now.Before(rec.ExpiresAt) {
+			delete(s.records, key)
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Key < recs[j].Key })
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// mongoCacheStore 每個快取一個 collection，expires_at 上的 TTL 索引會刪除過期資料
+type mongoCacheStore struct {
+	coll *mongo.Collection
+}
+
+func newMongoCacheStore(ctx context.Context, coll *mongo.Collection) (*mongoCacheStore, error) {
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return &mongoCacheStore{coll: coll}, err
+}
+
+func (s *mongoCacheStore) Name() string { return "mongo" }
+
+func (s *mongoCacheStore) Load(ctx context.Context) ([]cacheRecord, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+	var recs []cacheRecord
+	err = cursor.All(ctx, &recs)
+	return recs, err
+}
+
+func (s *mongoCacheStore) Put(ctx context.Context, rec cacheRecord) error {
+	_, err := s.coll.ReplaceOne(ctx, bson.M{"key": rec.Key}, rec, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoCacheStore) Delete(ctx context.Context, key string) error {
+	_, err := s.coll.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
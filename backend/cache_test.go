@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheLRUAndExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := newTTLCache[string]("test-lru", 2, time.Hour, time.Minute, nil)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", "A", true)
+	c.Set(ctx, "b", "B", true)
+	c.Get("a") // a 變成最近使用
+	c.Set(ctx, "c", "C", true)
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("b should be evicted")
+	}
+	if v, found, ok := c.Get("a"); !ok || !found || v != "A" {
+		t.Errorf("a = %q, %v, %v", v, found, ok)
+	}
+
+	// 查無結果只保留 missTTL
+	c.Set(ctx, "none", "", false)
+	now = now.Add(2 * time.Minute)
+	if _, _, ok := c.Get("none"); ok {
+		t.Error("negative entry should expire")
+	}
+	if _, _, ok := c.Get("a"); !ok {
+		t.Error("a should still be cached")
+	}
+
+	s := c.Stats()
+	if s.Evictions != 2 || s.Expirations != 1 || s.Size != 1 || s.Capacity != 2 || s.Store != "memory" {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestTTLCacheGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	c := newTTLCache[string]("test-load", 10, time.Hour, time.Minute, nil)
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (string, bool, error) {
+		loads.Add(1)
+		<-release
+		return "tokyo.jpg", true, nil
+	}
+
+	// 同時查同一個 key 只會呼叫一次 load
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, _ = c.GetOrLoad(ctx, "東京", load)
+		}(i)
+	}
+	for c.Stats().Coalesced < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Errorf("loads = %d", loads.Load())
+	}
+	for _, r := range results {
+		if r != "tokyo.jpg" {
+			t.Errorf("results = %q", results)
+		}
+	}
+	if v, _, _ := c.GetOrLoad(ctx, "東京", load); v != "tokyo.jpg" || loads.Load() != 1 {
+		t.Errorf("cached value = %q, loads = %d", v, loads.Load())
+	}
+
+	// 查無結果會快取，錯誤不會
+	miss := func(ctx context.Context) (string, bool, error) { loads.Add(1); return "", false, nil }
+	c.GetOrLoad(ctx, "nowhere", miss)
+	c.GetOrLoad(ctx, "nowhere", miss)
+	fail := func(ctx context.Context) (string, bool, error) {
+		loads.Add(1)
+		return "", false, errors.New("rate limited")
+	}
+	if _, _, err := c.GetOrLoad(ctx, "later", fail); err == nil {
+		t.Error("expected error")
+	}
+	c.GetOrLoad(ctx, "later", fail)
+	if loads.Load() != 4 {
+		t.Errorf("loads = %d, want 4", loads.Load())
+	}
+
+	s := c.Stats()
+	if s.Hits != 1 || s.NegativeHits != 1 || s.Misses != 4 || s.Coalesced != 4 || s.LoadErrors != 2 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+// 快取寫入 store 的期間，同一個 key 的請求要命中快取，不能再 load 一次
+func TestTTLCacheGetOrLoadNoGapWhilePersisting(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{put: make(chan struct{})}
+	c := newTTLCache[string]("test-gap", 10, time.Hour, time.Minute, store)
+
+	var loads atomic.Int32
+	load := func(ctx context.Context) (string, bool, error) {
+		loads.Add(1)
+		return "kyoto.jpg", true, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.GetOrLoad(ctx, "京都", load)
+	}()
+	<-store.put // 第一個請求正在寫入 store
+	if v, _, err := c.GetOrLoad(ctx, "京都", load); err != nil || v != "kyoto.jpg" || loads.Load() != 1 {
+		t.Errorf("second = %q, %v, loads = %d", v, err, loads.Load())
+	}
+	store.put <- struct{}{}
+	<-done
+}
+
+// blockingStore Put 時通知測試並等待放行
+type blockingStore struct{ put chan struct{} }
+
+func (s *blockingStore) Name() string                                    { return "blocking" }
+func (s *blockingStore) Load(ctx context.Context) ([]cacheRecord, error) { return nil, nil }
+func (s *blockingStore) Delete(ctx context.Context, key string) error    { return nil }
+func (s *blockingStore) Put(ctx context.Context, rec cacheRecord) error {
+	s.put <- struct{}{}
+	<-s.put
+	return nil
+}
+
+func TestFileCacheStoreRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache", "unsplash.json")
+
+	store := newFileCacheStore(path)
+	c := newTTLCache[string]("test-file", 2, time.Hour, time.Minute, store)
+	c.Set(ctx, "a", "A", true)
+	c.Set(ctx, "none", "", false)
+	c.Set(ctx, "b", "B", true) // 擠掉 a，store 裡也要刪除
+
+	// 寫入會合併，等到 Flush (或 delay 到期) 才寫檔
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file written before flush: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTTLCache[string]("test-file", 10, time.Hour, time.Minute, newFileCacheStore(path))
+	n, err := restored.Restore(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("restored %d, %v", n, err)
+	}
+	if v, found, ok := restored.Get("b"); !ok || !found || v != "B" {
+		t.Errorf("b = %q, %v, %v", v, found, ok)
+	}
+	if _, found, ok := restored.Get("none"); !ok || found {
+		t.Errorf("negative entry: found=%v ok=%v", found, ok)
+	}
+	if _, _, ok := restored.Get("a"); ok {
+		t.Error("evicted entry restored")
+	}
+
+	var names []string
+	for _, s := range allCacheStats() {
+		names = append(names, s.Name)
+		if s.Name == "test-file" && (s.Store != "file" || s.Restored != 2) {
+			t.Errorf("stats = %+v", s)
+		}
+	}
+	if len(names) < 3 {
+		t.Errorf("registered caches = %v", names)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 對話視窗管理 ==========
+//
+// 每次對話前估計 system + 歷史 + 訊息的 token 數，超過 CHAT_CONTEXT_TOKENS 時
+// 把較舊的訊息交給模型整理成摘要 (prompts/chat_summary)，只保留最近
+// CHAT_KEEP_MESSAGES 則原文；摘要失敗或仍然太長時才直接捨棄最舊的訊息。
+// session 的摘要會存回 chat_sessions，下一輪直接沿用。
+// 行程目前的 JSON (prompts/trip_context) 固定放在 system instruction，不會被摘要或捨棄。
+//
+//	CHAT_CONTEXT_TOKENS=16000   超過此估計值就開始摘要
+//	CHAT_KEEP_MESSAGES=6        摘要時保留原文的最近訊息數
+
+// 摘要在歷史中以一組 user / model 訊息表示
+const (
+	chatSummaryPrefix = "（先前對話摘要）\n"
+	chatSummaryAck    = "好的，我會依照摘要與目前的行程資料繼續。"
+)
+
+// ChatWindowInfo 回應中的 context 欄位，說明這次送出的上下文做了哪些處理
+type ChatWindowInfo struct {
+	Budget          int         `json:"budget"`           // token 上限 (估計值)
+	EstimatedTokens int         `json:"estimated_tokens"` // 處理前的估計
+	FinalTokens     int         `json:"final_tokens"`     // 實際送出的估計
+	PinnedTrip      bool        `json:"pinned_trip"`      // 是否附上行程 JSON
+	SummaryReused   bool        `json:"summary_reused"`   // 沿用 session 既有的摘要
+	Summarized      int         `json:"summarized_messages"`
+	Truncated       int         `json:"truncated_messages"`
+	SummaryError    string      `json:"summary_error,omitempty"`
+	Prompts         []PromptRef `json:"prompts,omitempty"`
+}
+
+// estimateTokens 粗估 token 數：中日韓文字約一字一 token，其他約四個字元一 token
+func estimateTokens(s string) int {
+	cjk, other := 0, 0
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// estimateHistoryTokens 每則訊息另加少量的格式開銷
+func estimateHistoryTokens(history []ChatPart) int {
+	n := 0
+	for _, h := range history {
+		n += estimateTokens(h.Text) + 4
+	}
+	return n
+}
+
+// summaryTurns 將摘要包成一組 user / model 訊息
+func summaryTurns(summary string) []ChatPart {
+	return []ChatPart{
+		{Role: "user", Text: chatSummaryPrefix + summary},
+		{Role: "model", Text: chatSummaryAck},
+	}
+}
+
+// chatTranscript 將歷史轉成摘要用的純文字
+func chatTranscript(history []ChatPart) string {
+	var b strings.Builder
+	for _, h := range history {
+		who := "使用者"
+		if h.Role == "model" {
+			who = "導遊"
+		}
+		fmt.Fprintf(&b, "%s：%s\n\n", who, strings.TrimSpace(h.Text))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// windowSplit 保留最近 keep 則訊息，並讓保留的部分從使用者訊息開始
+func windowSplit(history []ChatPart, keep int) int {
+	cut := max(len(history)-keep, 0)
+	for cut < len(history) && history[cut].Role != "user" {
+		cut++
+	}
+	return cut
+}
+
+// chatWindow 一次對話的上下文
+type chatWindow struct {
+	llm     LLMProvider
+	locale  string
+	budget  int
+	keep    int
+	system  string
+	message string
+	history []ChatPart
+	// 歷史開頭不屬於 session.Messages 的訊息數 (system_prompt 或既有摘要)，摘要時不得小於此數
+	prefix int
+}
+
+func newChatWindow(llm LLMProvider, locale, system, message string, history []ChatPart) *chatWindow {
+	return &chatWindow{
+		llm:     llm,
+		locale:  locale,
+		budget:  envInt("CHAT_CONTEXT_TOKENS", 16000),
+		keep:    envInt("CHAT_KEEP_MESSAGES", 6),
+		system:  system,
+		message: message,
+		history: history,
+	}
+}
+
+func (w *chatWindow) tokens() int {
+	return estimateTokens(w.system) + estimateTokens(w.message) + estimateHistoryTokens(w.history)
+}
+
+// fit 必要時摘要並截斷歷史；回傳新摘要與其涵蓋的歷史則數 (沒有摘要時 cut 為 0)
+func (w *chatWindow) fit(ctx context.Context, info *ChatWindowInfo) (summary string, cut int) {
+	info.Budget = w.budget
+	info.EstimatedTokens = w.tokens()
+	defer func() { info.FinalTokens = w.tokens() }()
+
+	if w.budget <= 0 || info.EstimatedTokens <= w.budget {
+		return "", 0
+	}
+
+	if cut = windowSplit(w.history, w.keep); cut > w.prefix && cut > 0 {
+		text, ref, err := prompts.Render("chat_summary", w.locale, ChatSummaryPromptVars{Transcript: chatTranscript(w.history[:cut])})
+		if err == nil {
+			var res *LLMResponse
+			res, err = w.llm.Generate(ctx, LLMRequest{Prompt: text, Temperature: float32Ptr(0.2), MaxTokens: 2048})
+			if err == nil && strings.TrimSpace(res.Text) != "" {
+				summary = strings.TrimSpace(res.Text)
+			}
+		}
+		if err != nil {
+			info.SummaryError = err.Error()
+		} else if summary != "" {
+			info.Summarized = cut
+			info.Prompts = append(info.Prompts, ref)
+			w.history = append(summaryTurns(summary), w.history[cut:]...)
+		}
+	}
+	if summary == "" {
+		cut = 0
+	}
+
+	// 摘要失敗或仍然超過上限：從最舊的訊息開始捨棄，保留開頭的摘要
+	start := 0
+	if summary != "" {
+		start = 2
+	}
+	for w.tokens() > w.budget && len(w.history) > start {
+		next := start + 1
+		for next < len(w.history) && w.history[next].Role != "user" {
+			next++
+		}
+		info.Truncated += next - start
+		w.history = append(w.history[:start:start], w.history[next:]...)
+	}
+	return summary, cut
+}
+
+// pinTripContext 將行程目前的 JSON 附加到 system instruction
+func pinTripContext(ctx context.Context, system, locale string, tripID int, info *ChatWindowInfo) string {
+	if tripID == 0 {
+		return system
+	}
+	trip, err := findTripByID(ctx, tripID)
+	if err != nil {
+		return system
+	}
+	text, ref, err := prompts.Render("trip_context", locale, TripPromptVars{Trip: tripWithoutPhotos(trip)})
+	if err != nil {
+		return system
+	}
+	info.PinnedTrip = true
+	info.Prompts = append(info.Prompts, ref)
+	return system + "\n\n" + text
+}
+
+// prepareChatContext 釘選行程、摘要或截斷歷史；session 有新摘要時一併存回
+func prepareChatContext(c *gin.Context, llm LLMProvider, locale, system, message string, history []ChatPart, session *ChatSession, tripID int) (string, []ChatPart, ChatWindowInfo) {
+	ctx := c.Request.Context()
+	info := ChatWindowInfo{}
+
+	if session != nil {
+		tripID = session.TripID
+		info.SummaryReused = session.Summary != ""
+	}
+	system = pinTripContext(ctx, system, locale, tripID, &info)
+
+	w := newChatWindow(llm, locale, system, message, history)
+	if session != nil {
+		w.prefix = session.historyPrefix()
+	}
+	summary, cut := w.fit(ctx, &info)
+
+	if session != nil && summary != "" {
+		upto := session.SummaryUpTo + cut - w.prefix
+		if err := saveChatSummary(ctx, session, summary, upto); err != nil {
+			fmt.Println("❌ 對話摘要寫入失敗:", err)
+		}
+	}
+	return w.system, w.history, info
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func longHistory(n int) []ChatPart {
+	var history []ChatPart
+	for i := 0; i < n; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "model"
+		}
+		history = append(history, ChatPart{Role: role, Text: strings.Repeat("京都", 50)})
+	}
+	return history
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if n := estimateTokens("京都三日遊"); n != 5 {
+		t.Errorf("cjk = %d, want 5", n)
+	}
+	if n := estimateTokens("Kyoto trip"); n != 3 {
+		t.Errorf("ascii = %d, want 3", n)
+	}
+}
+
+func TestChatWindowSummarizes(t *testing.T) {
+	llm := newFakeProvider().On("對話紀錄", "- 目的地：京都")
+
+	w := newChatWindow(llm, "zh-TW", "你是導遊", "第三天呢？", longHistory(10))
+	w.budget, w.keep = 800, 4
+	w.prefix = 1
+
+	var info ChatWindowInfo
+	summary, cut := w.fit(context.Background(), &info)
+	if summary != "- 目的地：京都" || cut != 6 || info.Summarized != 6 || info.Truncated != 0 {
+		t.Fatalf("summary = %q, cut = %d, info = %+v", summary, cut, info)
+	}
+	if len(w.history) != 6 || !strings.HasPrefix(w.history[0].Text, chatSummaryPrefix) || w.history[2].Role != "user" {
+		t.Errorf("history = %+v", w.history)
+	}
+	if info.FinalTokens >= info.EstimatedTokens || len(info.Prompts) != 1 || info.Prompts[0].Name != "chat_summary" {
+		t.Errorf("info = %+v", info)
+	}
+
+	// 摘要的 prompt 應包含被摘要的對話
+	if calls := llm.Calls(); len(calls) != 1 || !strings.Contains(calls[0].Prompt, "導遊：京都") {
+		t.Errorf("calls = %+v", calls)
+	}
+}
+
+func TestChatWindowTruncatesWhenSummaryFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fake provider 在 context 取消時回傳錯誤
+
+	w := newChatWindow(newFakeProvider(), "zh-TW", "", "hi", longHistory(10))
+	w.budget, w.keep = 250, 4
+
+	var info ChatWindowInfo
+	if summary, _ := w.fit(ctx, &info); summary != "" {
+		t.Fatalf("summary = %q", summary)
+	}
+	if info.SummaryError == "" || info.Truncated == 0 || info.FinalTokens > w.budget {
+		t.Errorf("info = %+v", info)
+	}
+	if w.history[0].Role != "user" {
+		t.Errorf("history should start with a user turn: %+v", w.history[0])
+	}
+}
+
+func TestChatWindowUnderBudget(t *testing.T) {
+	llm := newFakeProvider()
+	w := newChatWindow(llm, "zh-TW", "", "hi", longHistory(2))
+
+	var info ChatWindowInfo
+	if summary, cut := w.fit(context.Background(), &info); summary != "" || cut != 0 || len(w.history) != 2 {
+		t.Errorf("summary = %q, cut = %d, history = %d", summary, cut, len(w.history))
+	}
+	if len(llm.Calls()) != 0 {
+		t.Error("should not call the model under budget")
+	}
+}
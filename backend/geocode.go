@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========== 地理編碼 ==========
+//
+// 將地名轉成座標，預設使用 Nominatim 相容的 /search API：
+//
+//	GEOCODER=nominatim                       nominatim / fake
+//	GEOCODER_BASE_URL=https://nominatim.openstreetmap.org
+//	GEOCODER_USER_AGENT=trip-planner/1.0     Nominatim 要求可辨識的 User-Agent
+//	GEOCODER_MIN_INTERVAL_MS=1000            兩次查詢的最短間隔 (Nominatim 使用政策：每秒最多 1 次)
+//
+// Nominatim 的結果放在快取，預設存成檔案，重新啟動後不用重查：
+//
+//	GEOCODER_CACHE_SIZE=5000
+//	GEOCODER_CACHE_TTL_HOURS=720          有結果的保留時間
+//	GEOCODER_CACHE_MISS_TTL_HOURS=24      查無結果的保留時間
+//	GEOCODER_CACHE_STORE=file             memory / file / mongo
+//	GEOCODER_CACHE_FILE=../data/cache/geocode.json
+//
+// fake 依地名產生固定的座標，不需要網路，適合本地開發與測試。
+
+// GeoResult 一筆地理編碼結果
+type GeoResult struct {
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	DisplayName string  `json:"display_name"`
+	Class       string  `json:"class,omitempty"` // amenity / tourism / place ...
+	Type        string  `json:"type,omitempty"`
+	Importance  float64 `json:"importance,omitempty"`
+}
+
+// Geocoder 地名查詢
+type Geocoder interface {
+	Name() string
+	// Search 依相關度排序回傳最多 limit 筆結果，查無結果時回傳空陣列
+	Search(ctx context.Context, query string, limit int) ([]GeoResult, error)
+}
+
+// geocoder 全域的 Geocoder，由 initGeocoder 依環境變數設定；測試時可替換
+var geocoder Geocoder = fakeGeocoder{}
+
+// initGeocoder 需在 initMongo 之後呼叫 (快取可能存在 Mongo)
+func initGeocoder() {
+	switch name := envOr("GEOCODER", "nominatim"); name {
+	case "fake":
+		geocoder = fakeGeocoder{}
+	default:
+		g := newNominatimGeocoder(
+			envOr("GEOCODER_BASE_URL", "https://nominatim.openstreetmap.org"),
+			envOr("GEOCODER_USER_AGENT", "trip-planner/1.0"),
+		)
+		g.limiter.interval = time.Duration(envInt("GEOCODER_MIN_INTERVAL_MS", 1000)) * time.Millisecond
+		geocoder = newCachedGeocoder(g, initGeocodeCache())
+	}
+	fmt.Println("🗺️ Geocoder:", geocoder.Name())
+}
+
+func initGeocodeCache() *ttlCache[[]GeoResult] {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := cacheStoreFromEnv(ctx, "GEOCODER", "geocode", "file")
+	cache := newTTLCache[[]GeoResult]("geocode",
+		envInt("GEOCODER_CACHE_SIZE", 5000),
+		time.Duration(envInt("GEOCODER_CACHE_TTL_HOURS", 720))*time.Hour,
+		time.Duration(envInt("GEOCODER_CACHE_MISS_TTL_HOURS", 24))*time.Hour,
+		store,
+	)
+	if store != nil {
+		n, err := cache.Restore(ctx)
+		if err != nil {
+			log.Printf("地理編碼快取載入失敗: %v", err)
+		} else {
+			log.Printf("地理編碼快取 (%s) 載入 %d 筆", store.Name(), n)
+		}
+	}
+	return cache
+}
+
+// ========== 速率限制與快取 ==========
+
+// rateLimiter 讓呼叫之間至少間隔 interval；同時有多個請求時依序排隊
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Wait 等到輪到這次呼叫；ctx 結束時回傳錯誤 (已排定的時段不會收回)
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	wait := at.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedGeocoder 有速率限制的 Geocoder：Wait 排隊，fetch 直接查詢不再等待
+type rateLimitedGeocoder interface {
+	Wait(ctx context.Context) error
+	fetch(ctx context.Context, query string, limit int) ([]GeoResult, error)
+}
+
+// cachedGeocoder 以 ttlCache 包裝另一個 Geocoder；同樣的查詢同時只會打一次 API，查無結果也會快取。
+// 速率限制的排隊放在 GetOrLoad 的 load 裡，只有真正要查詢的請求會佔用時段；
+// load 本身不會隨請求取消 (其他請求可能在等同一個結果)，排隊則以發起查詢的請求的 ctx 進行
+type cachedGeocoder struct {
+	Geocoder
+	cache *ttlCache[[]GeoResult]
+}
+
+// errGeocodeWaitCanceled 發起查詢的請求在排隊時中斷；等待同一個結果的其他請求會自己重新查詢
+var errGeocodeWaitCanceled = errors.New("geocode: request canceled while rate limited")
+
+func newCachedGeocoder(g Geocoder, cache *ttlCache[[]GeoResult]) *cachedGeocoder {
+	return &cachedGeocoder{Geocoder: g, cache: cache}
+}
+
+func (g *cachedGeocoder) Search(ctx context.Context, query string, limit int) ([]GeoResult, error) {
+	key := strings.ToLower(strings.TrimSpace(query)) + "|" + strconv.Itoa(limit)
+	load := func(lctx context.Context) ([]GeoResult, bool, error) {
+		results, err := g.Geocoder.Search(lctx, query, limit)
+		return results, len(results) > 0, err
+	}
+	if rl, ok := g.Geocoder.(rateLimitedGeocoder); ok {
+		load = func(lctx context.Context) ([]GeoResult, bool, error) {
+			if err := rl.Wait(ctx); err != nil {
+				return nil, false, errGeocodeWaitCanceled
+			}
+			results, err := rl.fetch(lctx, query, limit)
+			return results, len(results) > 0, err
+		}
+	}
+
+	for {
+		results, _, err := g.cache.GetOrLoad(ctx, key, load)
+		if errors.Is(err, errGeocodeWaitCanceled) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// 等的是別人發起的查詢，改由自己排隊
+			continue
+		}
+		if results == nil && err == nil {
+			results = []GeoResult{}
+		}
+		return results, err
+	}
+}
+
+// ========== Nominatim ==========
+
+type nominatimGeocoder struct {
+	baseURL    string
+	userAgent  string
+	httpClient *http.Client
+	limiter    *rateLimiter
+}
+
+func newNominatimGeocoder(baseURL, userAgent string) *nominatimGeocoder {
+	return &nominatimGeocoder{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		userAgent:  userAgent,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limiter:    &rateLimiter{interval: time.Second},
+	}
+}
+
+func (g *nominatimGeocoder) Name() string { return "nominatim" }
+
+func (g *nominatimGeocoder) Search(ctx context.Context, query string, limit int) ([]GeoResult, error) {
+	if err := g.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return g.fetch(ctx, query, limit)
+}
+
+// Wait 依 Nominatim 的使用政策排隊 (預設每秒最多 1 次)
+func (g *nominatimGeocoder) Wait(ctx context.Context) error {
+	return g.limiter.Wait(ctx)
+}
+
+// fetch 呼叫 /search，呼叫前須先 Wait
+func (g *nominatimGeocoder) fetch(ctx context.Context, query string, limit int) ([]GeoResult, error) {
+	q := url.Values{}
+	q.Set("q", query)
+	q.Set("format", "jsonv2")
+	q.Set("limit", strconv.Itoa(max(limit, 1)))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", g.baseURL+"/search?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", g.userAgent)
+	if lang := os.Getenv("GEOCODER_LANGUAGE"); lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("nominatim: HTTP %d", resp.StatusCode)
+	}
+
+	// Nominatim 的 lat / lon 是字串
+	var raw []struct {
+		Lat         string  `json:"lat"`
+		Lon         string  `json:"lon"`
+		DisplayName string  `json:"display_name"`
+		Category    string  `json:"category"`
+		Class       string  `json:"class"`
+		Type        string  `json:"type"`
+		Importance  float64 `json:"importance"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("nominatim: %w", err)
+	}
+
+	results := []GeoResult{}
+	for _, r := range raw {
+		lat, err1 := strconv.ParseFloat(r.Lat, 64)
+		lng, err2 := strconv.ParseFloat(r.Lon, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		class := r.Category
+		if class == "" {
+			class = r.Class
+		}
+		results = append(results, GeoResult{Lat: lat, Lng: lng, DisplayName: r.DisplayName, Class: class, Type: r.Type, Importance: r.Importance})
+	}
+	return results, nil
+}
+
+// ========== fake ==========
+
+// fakeGeocoder 依地名的雜湊產生固定座標 (落在台灣附近)，空字串查無結果
+type fakeGeocoder struct{}
+
+func (fakeGeocoder) Name() string { return "fake" }
+
+func (fakeGeocoder) Search(ctx context.Context, query string, limit int) ([]GeoResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []GeoResult{}, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(query)))
+	n := h.Sum32()
+	return []GeoResult{{
+		Lat:         22 + float64(n%300000)/100000,
+		Lng:         120 + float64(n/300000%200000)/100000,
+		DisplayName: query,
+		Importance:  0.5,
+	}}, nil
+}
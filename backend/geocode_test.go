@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nominatimStubServer 模擬 /search；q 含 "none" 時查無結果，沒有帶 User-Agent 時回傳 403
+func nominatimStubServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	hits := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/search" || r.Header.Get("User-Agent") != "trip-planner-test" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if strings.Contains(r.URL.Query().Get("q"), "none") {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"lat":"34.9949","lon":"135.7850","display_name":"清水寺, 京都市","category":"tourism","type":"attraction","importance":0.62}]`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hits
+}
+
+func TestNominatimRateLimit(t *testing.T) {
+	srv, hits := nominatimStubServer(t)
+	g := newNominatimGeocoder(srv.URL, "trip-planner-test")
+	g.limiter.interval = 50 * time.Millisecond
+	ctx := context.Background()
+
+	start := time.Now()
+	for _, q := range []string{"清水寺", "金閣寺", "銀閣寺"} {
+		rs, err := g.Search(ctx, q, 1)
+		if err != nil || len(rs) != 1 || rs[0].Lat != 34.9949 || rs[0].Class != "tourism" {
+			t.Fatalf("%s = %+v, %v", q, rs, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests took %v, want >= 100ms", elapsed)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("hits = %d", hits.Load())
+	}
+
+	// 等待中的請求可以被取消
+	g.limiter.interval = time.Hour
+	g.Search(ctx, "伏見稻荷", 1)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.Search(ctx, "二条城", 1); err == nil {
+		t.Error("expected context error while rate limited")
+	}
+}
+
+func TestCachedGeocoder(t *testing.T) {
+	srv, hits := nominatimStubServer(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "geocode.json")
+	store := newFileCacheStore(path)
+	inner := newNominatimGeocoder(srv.URL, "trip-planner-test")
+	inner.limiter.interval = 0
+	g := newCachedGeocoder(inner, newTTLCache[[]GeoResult]("geocode-test", 10, time.Hour, time.Minute, store))
+
+	for i := 0; i < 2; i++ {
+		if rs, err := g.Search(ctx, "清水寺", 5); err != nil || len(rs) != 1 {
+			t.Fatalf("search = %+v, %v", rs, err)
+		}
+		if rs, err := g.Search(ctx, "nowhere none", 5); err != nil || rs == nil || len(rs) != 0 {
+			t.Fatalf("miss = %#v, %v", rs, err)
+		}
+	}
+	if hits.Load() != 2 || g.Name() != "nominatim" {
+		t.Errorf("hits = %d, name = %s", hits.Load(), g.Name())
+	}
+
+	// 限速排隊時請求中斷就不再等待；快取命中不用排隊
+	inner.limiter.interval = time.Hour
+	g.Search(ctx, "伏見稻荷", 5)
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.Search(cctx, "二条城", 5); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("canceled search: %v after %v", err, time.Since(start))
+	}
+	if rs, err := g.Search(cctx, "清水寺", 5); err != nil || len(rs) != 1 || hits.Load() != 3 {
+		t.Errorf("cached search while rate limited = %+v, %v, hits = %d", rs, err, hits.Load())
+	}
+
+	// 重新啟動後由檔案載回，不用再打 API
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored := newCachedGeocoder(inner, newTTLCache[[]GeoResult]("geocode-test", 10, time.Hour, time.Minute, newFileCacheStore(path)))
+	if n, err := restored.cache.Restore(ctx); err != nil || n != 3 {
+		t.Fatalf("restored %d, %v", n, err)
+	}
+	if rs, _ := restored.Search(ctx, " 清水寺 ", 5); len(rs) != 1 || hits.Load() != 3 {
+		t.Errorf("restored search = %+v, hits = %d", rs, hits.Load())
+	}
+}
+
+func TestCachedGeocoderRateLimitSlots(t *testing.T) {
+	srv, hits := nominatimStubServer(t)
+	inner := newNominatimGeocoder(srv.URL, "trip-planner-test")
+	inner.limiter.interval = 100 * time.Millisecond
+	g := newCachedGeocoder(inner, newTTLCache[[]GeoResult]("geocode-test", 10, time.Hour, time.Minute, nil))
+	ctx := context.Background()
+
+	// 同時查詢相同地名只佔用一個時段
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rs, err := g.Search(ctx, "清水寺", 5); err != nil || len(rs) != 1 {
+				t.Errorf("search = %+v, %v", rs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	inner.limiter.mu.Lock()
+	reserved := time.Until(inner.limiter.next)
+	inner.limiter.mu.Unlock()
+	if hits.Load() != 1 || reserved > 100*time.Millisecond {
+		t.Errorf("hits = %d, next slot in %v", hits.Load(), reserved)
+	}
+
+	// 發起查詢的請求排隊時中斷，一起等待的請求改由自己排隊，仍然要等到時段才查詢
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.Search(cctx, "金閣寺", 5)
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	start := time.Now()
+	if rs, err := g.Search(ctx, "金閣寺", 5); err != nil || len(rs) != 1 {
+		t.Errorf("follower search = %+v, %v", rs, err)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("canceled search: %v", err)
+	}
+	if elapsed := time.Since(start); hits.Load() != 2 || elapsed < 50*time.Millisecond {
+		t.Errorf("hits = %d after %v", hits.Load(), elapsed)
+	}
+}
@@ -1,79 +1,151 @@
 package main
 
 import (
-	"fmt"
-	"os"
 	"regexp"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 )
 
-// getIATACode 使用 Gemini 查詢地點的 IATA 代碼
+// ========== IATA 代碼查詢 ==========
+//
+// 先查內嵌的機場資料集 (airports.go)，最佳候選的信心分數夠高就直接回傳；
+// 找不到才問 LLM，並用資料集檢查模型的答案：
+//
+//	source=dataset  資料集比對到
+//	source=llm      模型回答，verified 表示代碼存在於資料集
+//
+// 回應的 code 欄位與以前相同，查不到時為 UNK。
+
+// 資料集最佳候選達到此分數就不問 LLM
+const iataDatasetMinConfidence = 0.7
+
+// 模型答案的信心分數：代碼在資料集中 / 格式正確但資料集沒有
+const (
+	iataLLMVerifiedConfidence   = 0.8
+	iataLLMUnverifiedConfidence = 0.3
+)
+
+const iataCandidateLimit = 5
+
+// iataResult POST /api/iata 的回應
+type iataResult struct {
+	Code       string         `json:"code"`
+	Source     string         `json:"source"` // dataset / llm
+	Confidence float64        `json:"confidence"`
+	Verified   bool           `json:"verified"`
+	LLMCode    string         `json:"llm_code,omitempty"` // 模型原本的答案
+	Candidates []AirportMatch `json:"candidates"`
+	Prompt     *PromptRef     `json:"prompt,omitempty"`
+}
+
+// datasetIATAResult 以資料集的最佳候選作答，沒有候選時回傳 UNK
+func datasetIATAResult(candidates []AirportMatch) iataResult {
+	if len(candidates) == 0 {
+		return iataResult{Code: "UNK", Source: "dataset", Candidates: candidates}
+	}
+	return iataResult{
+		Code:       candidates[0].Code,
+		Source:     "dataset",
+		Confidence: candidates[0].Confidence,
+		Verified:   true,
+		Candidates: candidates,
+	}
+}
+
+// resolveLLMIATA 用資料集檢查模型的答案
+func resolveLLMIATA(candidates []AirportMatch, text string) iataResult {
+	code := parseIATACode(text)
+	if a, ok := airports.ByCode(code); ok {
+		res := iataResult{Code: a.Code, Source: "llm", Confidence: iataLLMVerifiedConfidence, Verified: true, LLMCode: code}
+		// 模型的答案放在候選的第一個
+		res.Candidates = []AirportMatch{{Airport: a, Confidence: iataLLMVerifiedConfidence, MatchedOn: code}}
+		for _, m := range candidates {
+			if m.Code != a.Code {
+				res.Candidates = append(res.Candidates, m)
+			}
+		}
+		return res
+	}
+	if !isIATACode(code) || code == "UNK" {
+		// 模型也不知道：退回資料集信心較低的候選
+		res := datasetIATAResult(candidates)
+		res.LLMCode = code
+		return res
+	}
+	// 格式正確但資料集沒有：照樣回傳，標示未驗證
+	return iataResult{
+		Code:       code,
+		Source:     "llm",
+		Confidence: iataLLMUnverifiedConfidence,
+		LLMCode:    code,
+		Candidates: candidates,
+	}
+}
+
+// getIATACode 查詢地點的 IATA 代碼：先查資料集，找不到才問 LLM
 func getIATACode(c *gin.Context) {
 	var req struct {
 		Location string `json:"location"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Location) == "" {
 		c.JSON(400, gin.H{"error": "Missing location"})
 		return
 	}
 
-	ctx := c.Request.Context()
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	candidates := airports.Lookup(req.Location, iataCandidateLimit)
+	if len(candidates) > 0 && candidates[0].Confidence >= iataDatasetMinConfidence {
+		c.JSON(200, datasetIATAResult(candidates))
+		return
+	}
+
+	llm, err := llmFor(taskIATA)
 	if err != nil {
+		if len(candidates) > 0 {
+			c.JSON(200, datasetIATAResult(candidates))
+			return
+		}
 		c.JSON(500, gin.H{"error": "Client error"})
 		return
 	}
-	defer client.Close()
-
-	// 使用輕量模型速度較快
-	model := client.GenerativeModel("gemini-2.5-flash-lite")
-	model.SetTemperature(0.0) // 溫度設為 0，追求準確與一致性
-
-	//  關鍵 Prompt：要求只回傳代碼
-	prompt := fmt.Sprintf(`
-    你是一個 IATA 機場代碼查詢 API。
-    使用者會輸入一個城市或地點名稱 (可能是中文、英文或有錯字)。
-    請回傳該地點最主要的「機場代碼」或「城市代碼」(3個大寫英文字母)。
-    
-    規則：
-    1. 只回傳 3 個大寫字母 (例如: TPE, KIX, NRT, LON)。
-    2. 不要包含任何解釋、標點符號或 Markdown 格式。
-    3. 如果地點模糊 (例如 "關西")，優先回傳最常用的國際機場 (如 KIX)。
-    4. 如果是城市 (如 "東京")，回傳城市代碼 (TYO) 優於特定機場 (NRT)，除非使用者指定機場。
-    5. 如果完全無法辨識，回傳 "UNK"。
-
-    使用者輸入: "%s"
-    `, req.Location)
-
-	res, err := model.GenerateContent(ctx, genai.Text(prompt))
+
+	// 關鍵 Prompt：要求只回傳代碼 (prompts/iata)
+	prompt, ref, err := prompts.Render("iata", requestLocale(c), IATAPromptVars{Location: req.Location})
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Gemini error: " + err.Error()})
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 解析回傳結果
-	code := "UNK"
-	if len(res.Candidates) > 0 {
-		for _, part := range res.Candidates[0].Content.Parts {
-			if txt, ok := part.(genai.Text); ok {
-				// 去除空白與換行
-				code = strings.TrimSpace(string(txt))
-				// 再次確保只留前3碼 (防止 AI 多話)
-				if len(code) > 3 {
-					re := regexp.MustCompile(`[A-Z]{3}`)
-					found := re.FindString(code)
-					if found != "" {
-						code = found
-					}
-				}
-			}
+	res, err := llm.Generate(c.Request.Context(), LLMRequest{
+		Prompt:      prompt,
+		Temperature: float32Ptr(0.0), // 溫度設為 0，追求準確與一致性
+	})
+	if err != nil {
+		if len(candidates) > 0 {
+			c.JSON(200, datasetIATAResult(candidates))
+			return
 		}
+		respondLLMError(c, llm.Name(), err, nil)
+		return
 	}
 
-	c.JSON(200, gin.H{"code": code})
+	out := resolveLLMIATA(candidates, res.Text)
+	out.Prompt = &ref
+	c.JSON(200, out)
+}
+
+var reIATACode = regexp.MustCompile(`[A-Z]{3}`)
+
+// parseIATACode 解析模型回傳的代碼：去除空白與換行，模型多話時只取第一組 3 個大寫字母
+func parseIATACode(text string) string {
+	code := strings.TrimSpace(text)
+	if code == "" {
+		return "UNK"
+	}
+	if len(code) > 3 {
+		if found := reIATACode.FindString(code); found != "" {
+			code = found
+		}
+	}
+	return code
 }
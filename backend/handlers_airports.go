@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 機場查詢 ==========
+//
+// 以名稱模糊查詢、以座標找最近的機場，以及依行程目的地找機場，
+// 讓前端的航班搜尋不必再請使用者輸入代碼。
+
+const (
+	defaultAirportLimit = 5
+	maxAirportLimit     = 20
+)
+
+// airportLimit 解析 limit 參數，未帶時為 defaultAirportLimit
+func airportLimit(c *gin.Context) (int, bool) {
+	s := c.Query("limit")
+	if s == "" {
+		return defaultAirportLimit, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > maxAirportLimit {
+		c.JSON(400, gin.H{"error": "limit 必須是 1 ~ " + strconv.Itoa(maxAirportLimit)})
+		return 0, false
+	}
+	return n, true
+}
+
+// searchAirports 模糊查詢機場資料集：GET /api/airports?q=東京&limit=5
+func searchAirports(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(400, gin.H{"error": "Missing q"})
+		return
+	}
+	limit, ok := airportLimit(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{"query": q, "candidates": airports.Lookup(q, limit)})
+}
+
+// nearestAirportsResponse 最近機場的回應；city_code 為最近機場所屬的都市代碼 (例如 HND → TYO)，可直接用於航班搜尋
+type nearestAirportsResponse struct {
+	Origin   airportOrigin     `json:"origin"`
+	Airports []AirportDistance `json:"airports"`
+	CityCode string            `json:"city_code"`
+}
+
+// airportOrigin 計算距離的起點
+type airportOrigin struct {
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+	Source string  `json:"source"`          // query / region / item / geocode
+	Label  string  `json:"label,omitempty"` // 地區名稱、item 標題或地理編碼結果
+}
+
+func newNearestAirports(origin airportOrigin, limit int) nearestAirportsResponse {
+	res := nearestAirportsResponse{Origin: origin, Airports: airports.Nearest(origin.Lat, origin.Lng, limit)}
+	if len(res.Airports) > 0 {
+		res.CityCode = res.Airports[0].CityCode
+	}
+	return res
+}
+
+// nearestAirports 依座標找最近的機場：GET /api/airports/nearest?lat=&lng=&limit=
+func nearestAirports(c *gin.Context) {
+	lat, errLat := strconv.ParseFloat(c.Query("lat"), 64)
+	lng, errLng := strconv.ParseFloat(c.Query("lng"), 64)
+	if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		c.JSON(400, gin.H{"error": "lat / lng 不合法"})
+		return
+	}
+	limit, ok := airportLimit(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, newNearestAirports(airportOrigin{Lat: lat, Lng: lng, Source: "query"}, limit))
+}
+
+// tripAirportOrigin 決定行程目的地的座標：
+//  1. Region 在機場資料集裡 (信心足夠) 就用該都市 / 機場的座標
+//  2. 否則用行程中第一個有座標的 item
+//  3. 都沒有時以地理編碼查 Region
+//
+// 回傳的 cityCode 只在第 1 種情況有值 (例如「京都」直接對應到 OSA)
+func tripAirportOrigin(ctx context.Context, trip Trip) (origin airportOrigin, cityCode string, ok bool, err error) {
+	region := strings.TrimSpace(trip.Region)
+	if region != "" {
+		if m := airports.Lookup(region, 1); len(m) > 0 && m[0].Confidence >= iataDatasetMinConfidence {
+			return airportOrigin{Lat: m[0].Lat, Lng: m[0].Lng, Source: "region", Label: region}, m[0].CityCode, true, nil
+		}
+	}
+	for _, day := range trip.Plan {
+		for _, it := range day.Items {
+			if it.Lat != 0 || it.Lng != 0 {
+				return airportOrigin{Lat: it.Lat, Lng: it.Lng, Source: "item", Label: it.Title}, "", true, nil
+			}
+		}
+	}
+	if region == "" {
+		return airportOrigin{}, "", false, nil
+	}
+	results, err := geocoder.Search(ctx, region, 1)
+	if err != nil || len(results) == 0 {
+		return airportOrigin{}, "", false, err
+	}
+	r := results[0]
+	return airportOrigin{Lat: r.Lat, Lng: r.Lng, Source: "geocode", Label: r.DisplayName}, "", true, nil
+}
+
+// tripAirports 依行程目的地找最近的機場：GET /api/trips/:id/airports?limit=
+func tripAirports(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	limit, ok := airportLimit(c)
+	if !ok {
+		return
+	}
+
+	trip, err := findTripByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	origin, cityCode, ok, err := tripAirportOrigin(c.Request.Context(), trip)
+	if err != nil {
+		c.JSON(502, gin.H{"error": "geocode: " + err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(422, gin.H{"error": "行程沒有可用的地區或座標"})
+		return
+	}
+
+	res := newNearestAirports(origin, limit)
+	if cityCode != "" {
+		res.CityCode = cityCode
+	}
+	c.JSON(200, res)
+}
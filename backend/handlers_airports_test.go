@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNearestAirports(t *testing.T) {
+	// 東京車站附近
+	got := airports.Nearest(35.6812, 139.7671, 3)
+	if len(got) != 3 || got[0].Code != "HND" || got[0].CityCode != "TYO" {
+		t.Fatalf("nearest = %+v", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].DistanceKm < got[i-1].DistanceKm {
+			t.Errorf("not sorted: %+v", got)
+		}
+	}
+	for _, a := range airports.Nearest(35.6812, 139.7671, 0) {
+		if a.Type == "city" {
+			t.Errorf("city %s in nearest airports", a.Code)
+		}
+	}
+
+	// 桃園 — 羽田約 2,100 公里
+	if d := haversineKm(25.0777, 121.2328, 35.5523, 139.7798); math.Abs(d-2100) > 50 {
+		t.Errorf("TPE-HND = %.0f km", d)
+	}
+}
+
+func TestNearestAirportsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/airports/nearest", nearestAirports)
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"lat=51.5074&lng=-0.1278", 200},
+		{"lat=51.5074&lng=-0.1278&limit=2", 200},
+		{"lat=91&lng=0", 400},
+		{"lat=x&lng=0", 400},
+		{"lat=0&lng=0&limit=100", 400},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/airports/nearest?"+tc.query, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: code = %d, want %d", tc.query, w.Code, tc.code)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		var res nearestAirportsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.CityCode != "LON" || res.Origin.Source != "query" || len(res.Airports) == 0 || len(res.Airports) > defaultAirportLimit {
+			t.Errorf("%s: response = %+v", tc.query, res)
+		}
+	}
+}
+
+func TestTripAirportOrigin(t *testing.T) {
+	ctx := context.Background()
+
+	// Region 在資料集裡：京都沒有機場，直接用 OSA
+	origin, city, ok, err := tripAirportOrigin(ctx, Trip{Region: "京都"})
+	if err != nil || !ok || origin.Source != "region" || city != "OSA" {
+		t.Errorf("region origin = %+v, %s, %v, %v", origin, city, ok, err)
+	}
+
+	// Region 查不到時用第一個有座標的 item
+	trip := Trip{Region: "某個小鎮", Plan: []Day{
+		{Items: []Item{{Title: "出發"}}},
+		{Items: []Item{{Title: "倫敦眼", Lat: 51.5033, Lng: -0.1196}}},
+	}}
+	origin, city, ok, _ = tripAirportOrigin(ctx, trip)
+	if !ok || origin.Source != "item" || origin.Label != "倫敦眼" || city != "" {
+		t.Errorf("item origin = %+v, %s", origin, city)
+	}
+	if res := newNearestAirports(origin, 1); res.CityCode != "LON" {
+		t.Errorf("nearest = %+v", res)
+	}
+
+	// 都沒有時以地理編碼查 Region
+	old := geocoder
+	geocoder = fakeGeocoder{}
+	defer func() { geocoder = old }()
+	origin, _, ok, _ = tripAirportOrigin(ctx, Trip{Region: "某個小鎮"})
+	if !ok || origin.Source != "geocode" {
+		t.Errorf("geocode origin = %+v", origin)
+	}
+
+	if _, _, ok, _ := tripAirportOrigin(ctx, Trip{}); ok {
+		t.Error("empty trip should have no origin")
+	}
+}
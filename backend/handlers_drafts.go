@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ========== AI 草稿 ==========
+//
+// 取代原本寫入 ../data/response.json 的 /gemini/save：每則回覆存成 ai_drafts 的一筆文件，
+// 依 trip_id、session_id 與 prompt 版本查詢。保留規則：
+//
+//	AI_DRAFTS_MAX_PER_TRIP=50   每個行程最多保留的未釘選草稿 (超過時刪除最舊的)
+//	AI_DRAFTS_MAX_PINNED=20     每個行程最多可釘選的草稿
+//	AI_DRAFTS_TTL_DAYS=30       未釘選的草稿超過天數後由 TTL 索引刪除 (0 表示不過期)
+//	AI_DRAFTS_LEGACY_DIR=../data  啟動時匯入此目錄下舊的 /gemini/save 檔案 (空字串不匯入)
+//
+// 由舊檔案匯入的草稿 (source=import) 以釘選狀態寫入，不受保留期限與釘選上限影響。
+
+const (
+	defaultDraftsMaxPerTrip = 50
+	defaultDraftsMaxPinned  = 20
+	defaultDraftsTTLDays    = 30
+	draftsListLimit         = 100
+)
+
+// 草稿格式
+var draftFormats = map[string]bool{"text": true, "markdown": true, "json": true}
+
+// ensureAIDraftIndexes 建立查詢用的索引與未釘選草稿的 TTL 索引
+func ensureAIDraftIndexes(ctx context.Context) error {
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "trip_id", Value: 1}, {Key: "session_id", Value: 1}, {Key: "prompts.name", Value: 1}, {Key: "prompts.version", Value: 1}}},
+		{Keys: bson.D{{Key: "trip_id", Value: 1}, {Key: "pinned", Value: -1}, {Key: "created_at", Value: -1}}},
+	}
+	if days := envInt("AI_DRAFTS_TTL_DAYS", defaultDraftsTTLDays); days > 0 {
+		models = append(models, mongo.IndexModel{
+			Keys: bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().
+				SetExpireAfterSeconds(int32(days * 24 * 60 * 60)).
+				SetPartialFilterExpression(bson.M{"pinned": false}),
+		})
+	}
+	_, err := aiDraftsCollection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// pruneAIDrafts 每個行程只保留最新的 keep 筆未釘選草稿 (匯入的草稿不算)
+func pruneAIDrafts(ctx context.Context, tripID, keep int) error {
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip(int64(keep)).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := aiDraftsCollection.Find(ctx, bson.M{"trip_id": tripID, "pinned": false, "source": bson.M{"$ne": "import"}}, opts)
+	if err != nil {
+		return err
+	}
+	var old []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &old); err != nil {
+		return err
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(old))
+	for i, d := range old {
+		ids[i] = d.ID
+	}
+	_, err = aiDraftsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// draftListFilter 依查詢參數組出列表的條件：session_id、prompt (模板名稱)、version、pinned
+func draftListFilter(tripID int, userID string, q func(string) string) (bson.M, error) {
+	filter := bson.M{"trip_id": tripID, "user_id": userID}
+	if sid := q("session_id"); sid != "" {
+		filter["session_id"] = sid
+	}
+
+	// prompt 與 version 要符合同一個 PromptRef
+	ref := bson.M{}
+	if name := q("prompt"); name != "" {
+		ref["name"] = name
+	}
+	if v := q("version"); v != "" {
+		ref["version"] = v
+	}
+	if len(ref) > 0 {
+		filter["prompts"] = bson.M{"$elemMatch": ref}
+	}
+
+	if p := q("pinned"); p != "" {
+		pinned, err := strconv.ParseBool(p)
+		if err != nil {
+			return nil, fmt.Errorf("pinned %q 不是 true / false", p)
+		}
+		filter["pinned"] = pinned
+	}
+	return filter, nil
+}
+
+// lastModelPrompts session 最後一則模型回覆的模板版本
+func lastModelPrompts(s *ChatSession) []PromptRef {
+	for i := len(s.Messages) - 1; i >= 0; i-- {
+		if m := s.Messages[i]; m.Role == "model" {
+			return m.Prompts
+		}
+	}
+	return nil
+}
+
+// saveAIDraft 保存一則 AI 回覆：POST /api/trips/:id/drafts
+// 帶 session_id 且沒有指定 prompts 時，沿用該 session 最後一則回覆的模板版本
+func saveAIDraft(c *gin.Context) {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		SessionID string      `json:"session_id"`
+		Name      string      `json:"name"`
+		Text      string      `json:"text"`
+		Format    string      `json:"format"`
+		Prompts   []PromptRef `json:"prompts"`
+		Source    string      `json:"source"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		c.JSON(400, gin.H{"error": "text 不可為空"})
+		return
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = "markdown"
+	}
+	if !draftFormats[format] {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("format %q 不支援 (text / markdown / json)", req.Format)})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	trip, err := findTripByID(ctx, tripID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	prompts := req.Prompts
+	if req.SessionID != "" {
+		session, err := loadChatSession(c, req.SessionID)
+		if err != nil || session.TripID != tripID {
+			c.JSON(400, gin.H{"error": "session_id 不屬於此行程"})
+			return
+		}
+		if len(prompts) == 0 {
+			prompts = lastModelPrompts(session)
+		}
+	}
+	if prompts == nil {
+		prompts = []PromptRef{}
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = trip.Name
+	}
+	source := req.Source
+	if source == "" {
+		source = "chat"
+	}
+	if source == "import" {
+		c.JSON(400, gin.H{"error": "source 不可為 import"})
+		return
+	}
+
+	draft := AIDraft{
+		ID:        primitive.NewObjectID(),
+		TripID:    tripID,
+		SessionID: req.SessionID,
+		UserID:    requestUserID(c),
+		Name:      name,
+		Format:    format,
+		Text:      req.Text,
+		Prompts:   prompts,
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	if _, err := aiDraftsCollection.InsertOne(ctx, draft); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := pruneAIDrafts(ctx, tripID, envInt("AI_DRAFTS_MAX_PER_TRIP", defaultDraftsMaxPerTrip)); err != nil {
+		log.Printf("ai_drafts 清理失敗 (trip %d): %v", tripID, err)
+	}
+
+	c.JSON(201, draft)
+}
+
+// listAIDrafts 列出行程的草稿，釘選的在前、新的在前：GET /api/trips/:id/drafts
+// 可用 ?session_id=、?prompt=、?version=、?pinned=、?limit= 篩選
+func listAIDrafts(c *gin.Context) {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	filter, err := draftListFilter(tripID, requestUserID(c), c.Query)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := draftsListLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			c.JSON(400, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, draftsListLimit)
+	}
+
+	ctx := c.Request.Context()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pinned", Value: -1}, {Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := aiDraftsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	drafts := []AIDraft{}
+	if err := cursor.All(ctx, &drafts); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, drafts)
+}
+
+// draftFilter 依 :did 組出目前使用者的草稿條件
+func draftFilter(c *gin.Context) (bson.M, error) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("did"))
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"_id": oid, "user_id": requestUserID(c)}, nil
+}
+
+// getAIDraft 讀取單一草稿：GET /api/drafts/:did
+func getAIDraft(c *gin.Context) {
+	filter, err := draftFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid draft ID"})
+		return
+	}
+
+	var draft AIDraft
+	if err := aiDraftsCollection.FindOne(c.Request.Context(), filter).Decode(&draft); err != nil {
+		c.JSON(404, gin.H{"error": "Draft not found"})
+		return
+	}
+	c.JSON(200, draft)
+}
+
+// pinAIDraft 釘選草稿 (不會被自動刪除)：POST /api/drafts/:did/pin
+func pinAIDraft(c *gin.Context) {
+	setDraftPinned(c, true)
+}
+
+// unpinAIDraft 取消釘選：DELETE /api/drafts/:did/pin
+func unpinAIDraft(c *gin.Context) {
+	setDraftPinned(c, false)
+}
+
+func setDraftPinned(c *gin.Context, pinned bool) {
+	filter, err := draftFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid draft ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var draft AIDraft
+	if err := aiDraftsCollection.FindOne(ctx, filter).Decode(&draft); err != nil {
+		c.JSON(404, gin.H{"error": "Draft not found"})
+		return
+	}
+
+	if pinned && !draft.Pinned {
+		n, err := aiDraftsCollection.CountDocuments(ctx, bson.M{"trip_id": draft.TripID, "user_id": draft.UserID, "pinned": true, "source": bson.M{"$ne": "import"}})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if limit := envInt("AI_DRAFTS_MAX_PINNED", defaultDraftsMaxPinned); int(n) >= limit {
+			c.JSON(409, gin.H{"error": fmt.Sprintf("每個行程最多釘選 %d 則草稿", limit)})
+			return
+		}
+	}
+
+	if _, err := aiDraftsCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"pinned": pinned}}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	draft.Pinned = pinned
+
+	// 取消釘選後可能超過保留數量
+	if !pinned {
+		if err := pruneAIDrafts(ctx, draft.TripID, envInt("AI_DRAFTS_MAX_PER_TRIP", defaultDraftsMaxPerTrip)); err != nil {
+			log.Printf("ai_drafts 清理失敗 (trip %d): %v", draft.TripID, err)
+		}
+	}
+
+	c.JSON(200, draft)
+}
+
+// deleteAIDraft 刪除草稿：DELETE /api/drafts/:did
+func deleteAIDraft(c *gin.Context) {
+	filter, err := draftFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid draft ID"})
+		return
+	}
+
+	result, err := aiDraftsCollection.DeleteOne(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(404, gin.H{"error": "Draft not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Draft deleted"})
+}
+
+// ========== 匯入舊的 data/ 檔案 ==========
+//
+// /gemini/save 以前寫入 ../data：format=json 時 append 到 response.json 的 response 陣列
+// ({"name": ..., "response": [...]})，其他則寫成 .txt。這些檔案不記錄行程，
+// 匯入時依檔案中的 name 找同名行程，且回覆內容有提到該行程的地區才歸到該行程，否則 trip_id 為 0。
+// 匯入完成的檔案記錄在 ai_draft_imports (以檔名為 _id)，之後啟動會略過，原始檔案不會被修改。
+// 匯入的草稿以匯入時間為 created_at 並且釘選：TTL 索引的 partial filter 不支援 $ne，
+// 只能以 pinned 排除。取消釘選後就和其他草稿一樣受保留期限限制。
+
+// draftImport ai_draft_imports 的一筆紀錄，表示該檔案已匯入
+type draftImport struct {
+	File       string    `bson:"_id"`
+	Drafts     int       `bson:"drafts"`
+	ImportedAt time.Time `bson:"imported_at"`
+}
+
+// legacyDraftFile /gemini/save 以 JSON 格式寫入的檔案
+type legacyDraftFile struct {
+	Name     string   `json:"name"`
+	Response []string `json:"response"`
+}
+
+// parseLegacyDrafts 將舊檔案轉成草稿 (尚未指定 trip_id)；空檔案或無法辨識的格式回傳 nil
+func parseLegacyDrafts(filename string, data []byte, now time.Time) []AIDraft {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil
+	}
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	draft := func(name, format, text string) AIDraft {
+		return AIDraft{
+			UserID:     "anonymous",
+			Name:       name,
+			Format:     format,
+			Text:       text,
+			Prompts:    []PromptRef{},
+			Source:     "import",
+			SourceFile: filename,
+			Pinned:     true,
+			CreatedAt:  now,
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		var f legacyDraftFile
+		if err := json.Unmarshal(data, &f); err != nil || f.Response == nil {
+			return nil
+		}
+		name := f.Name
+		if name == "" {
+			name = base
+		}
+		var drafts []AIDraft
+		for i, r := range f.Response {
+			if r = strings.TrimSpace(r); r != "" {
+				d := draft(name, "markdown", r)
+				// 保留原本的順序
+				d.CreatedAt = now.Add(time.Duration(i-len(f.Response)) * time.Millisecond)
+				drafts = append(drafts, d)
+			}
+		}
+		return drafts
+	case ".txt":
+		return []AIDraft{draft(base, "text", text)}
+	}
+	return nil
+}
+
+// matchLegacyDraftTrip 依名稱與內容中的地區找出草稿所屬的行程，找不到時回傳 0
+func matchLegacyDraftTrip(d AIDraft, trips []Trip) int {
+	for _, t := range trips {
+		if t.Name == d.Name && t.Region != "" && strings.Contains(d.Text, t.Region) {
+			return t.ID
+		}
+	}
+	return 0
+}
+
+// migrateLegacyDrafts 將 dir 下舊的 /gemini/save 檔案匯入 ai_drafts，回傳匯入的筆數
+func migrateLegacyDrafts(ctx context.Context, dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cursor, err := tripsCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1, "name": 1, "region": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var trips []Trip
+	if err := cursor.All(ctx, &trips); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		done, err := legacyDraftsImported(ctx, e.Name())
+		if err != nil {
+			return total, err
+		}
+		if done {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return total, err
+		}
+		drafts := parseLegacyDrafts(e.Name(), data, time.Now())
+		if len(drafts) == 0 {
+			// 無法辨識的檔案也記錄下來，之後不必再讀
+			if err := markDraftsImported(ctx, e.Name(), 0); err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		docs := make([]any, len(drafts))
+		for i, d := range drafts {
+			d.ID = primitive.NewObjectID()
+			d.TripID = matchLegacyDraftTrip(d, trips)
+			docs[i] = d
+		}
+		if _, err := aiDraftsCollection.InsertMany(ctx, docs); err != nil {
+			return total, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		total += len(docs)
+		if err := markDraftsImported(ctx, e.Name(), len(docs)); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// legacyDraftsImported 檔案是否已匯入過。
+// 舊版只以草稿判斷、沒有紀錄：還有該檔案的草稿時補上紀錄並將其釘選，之後不再受保留期限影響
+func legacyDraftsImported(ctx context.Context, file string) (bool, error) {
+	err := draftImportsCollection.FindOne(ctx, bson.M{"_id": file}).Err()
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return false, err
+	}
+
+	filter := bson.M{"source": "import", "source_file": file}
+	n, err := aiDraftsCollection.CountDocuments(ctx, filter)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := aiDraftsCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"pinned": true}}); err != nil {
+		return false, err
+	}
+	return true, markDraftsImported(ctx, file, int(n))
+}
+
+// markDraftsImported 記錄檔案已匯入
+func markDraftsImported(ctx context.Context, file string, drafts int) error {
+	rec := draftImport{File: file, Drafts: drafts, ImportedAt: time.Now()}
+	_, err := draftImportsCollection.ReplaceOne(ctx, bson.M{"_id": file}, rec, options.Replace().SetUpsert(true))
+	return err
+}
+
+// initLegacyDrafts 啟動時匯入舊檔案，失敗只記錄不中斷
+func initLegacyDrafts() {
+	dir, ok := os.LookupEnv("AI_DRAFTS_LEGACY_DIR")
+	if !ok {
+		dir = "../data"
+	}
+	if dir == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n, err := migrateLegacyDrafts(ctx, dir)
+	switch {
+	case err != nil && !errors.Is(err, context.DeadlineExceeded):
+		log.Printf("匯入 %s 的舊草稿失敗: %v", dir, err)
+	case err != nil:
+		log.Printf("匯入 %s 的舊草稿逾時，已匯入 %d 則", dir, n)
+	case n > 0:
+		log.Printf("已將 %s 的 %d 則舊草稿匯入 ai_drafts", dir, n)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDraftListFilter(t *testing.T) {
+	q := url.Values{"session_id": {"abc"}, "prompt": {"trip_planning"}, "version": {"v2"}, "pinned": {"true"}}
+	filter, err := draftListFilter(7, "u1", q.Get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"trip_id":    7,
+		"user_id":    "u1",
+		"session_id": "abc",
+		"prompts":    bson.M{"$elemMatch": bson.M{"name": "trip_planning", "version": "v2"}},
+		"pinned":     true,
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("filter = %v", filter)
+	}
+
+	filter, _ = draftListFilter(7, "u1", url.Values{}.Get)
+	if len(filter) != 2 {
+		t.Errorf("empty query filter = %v", filter)
+	}
+	if _, err := draftListFilter(7, "u1", url.Values{"pinned": {"yes please"}}.Get); err == nil {
+		t.Error("expected error for invalid pinned")
+	}
+}
+
+func TestParseLegacyDrafts(t *testing.T) {
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	drafts := parseLegacyDrafts("response.json", []byte(`{"name":"畢業旅行","response":["東京第一版"," ","台北第二版"]}`), now)
+	if len(drafts) != 2 {
+		t.Fatalf("drafts = %d, want 2", len(drafts))
+	}
+	if d := drafts[0]; d.Name != "畢業旅行" || d.Format != "markdown" || d.Source != "import" || d.SourceFile != "response.json" || !d.Pinned {
+		t.Errorf("draft = %+v", d)
+	}
+	if !drafts[0].CreatedAt.Before(drafts[1].CreatedAt) || drafts[1].CreatedAt.After(now) {
+		t.Errorf("order not kept: %v, %v", drafts[0].CreatedAt, drafts[1].CreatedAt)
+	}
+
+	if d := parseLegacyDrafts("gemini_1.txt", []byte("純文字"), now); len(d) != 1 || d[0].Name != "gemini_1" || d[0].Format != "text" {
+		t.Errorf("txt drafts = %+v", d)
+	}
+	for name, data := range map[string]string{"trips_data.json": "", "other.json": `[1,2]`, "image.png": "x"} {
+		if d := parseLegacyDrafts(name, []byte(data), now); d != nil {
+			t.Errorf("%s: drafts = %+v", name, d)
+		}
+	}
+
+	trips := []Trip{{ID: 1, Name: "畢業旅行", Region: "東京"}, {ID: 2, Name: "畢業旅行", Region: "台北"}}
+	var got []int
+	for _, d := range drafts {
+		got = append(got, matchLegacyDraftTrip(d, trips))
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("trips = %v", got)
+	}
+	if id := matchLegacyDraftTrip(AIDraft{Name: "別的行程", Text: "東京"}, trips); id != 0 {
+		t.Errorf("unmatched trip = %d", id)
+	}
+}
+
+// 實際的 data/response.json 必須能完整匯入
+func TestParseLegacyDraftsRepoData(t *testing.T) {
+	path := filepath.Join("..", "data", "response.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Skip(err)
+	}
+	drafts := parseLegacyDrafts("response.json", data, time.Now())
+	if len(drafts) == 0 {
+		t.Fatal("no drafts parsed")
+	}
+	for _, d := range drafts {
+		if strings.TrimSpace(d.Text) == "" {
+			t.Fatal("empty draft")
+		}
+	}
+}
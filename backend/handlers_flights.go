@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 航班搜尋連結 ==========
+//
+// 依行程產生各家比價網站的搜尋連結 (取代前端 chat.html 自己組 Skyscanner 網址)：
+//
+//	one_way     出發日單程
+//	return      出發日去、最後一天回 (預設)
+//	multi_city  依 legs 的每一段，日期可用 date 或行程的第幾天 (day)
+//
+// 出發日取自 StartDate，回程為 StartDate + Days - 1；人數預設為行程的 People。
+// 目的地沒有指定時，依行程目的地找最近機場的都市代碼 (見 handlers_airports.go)。
+// Google Flights 的多點航程需要內部編碼的參數，無法組出連結，會列在 unsupported。
+
+const (
+	flightOneWay    = "one_way"
+	flightReturn    = "return"
+	flightMultiCity = "multi_city"
+)
+
+var (
+	flightTripTypes = []string{flightOneWay, flightReturn, flightMultiCity}
+	flightCabins    = []string{"economy", "premium_economy", "business", "first"}
+)
+
+const (
+	maxFlightAdults = 9 // 比價網站一次最多可查的人數
+	maxFlightLegs   = 6
+)
+
+// flightLinksRequest POST /api/trips/:id/flights/links 的 body，全部欄位皆可省略
+type flightLinksRequest struct {
+	Origin      string      `json:"origin"`      // 預設 FLIGHT_DEFAULT_ORIGIN (TPE)
+	Destination string      `json:"destination"` // 預設依行程目的地推算
+	TripType    string      `json:"trip_type"`   // one_way / return / multi_city
+	Cabin       string      `json:"cabin"`       // economy / premium_economy / business / first
+	Adults      int         `json:"adults"`      // 預設為行程人數
+	Legs        []flightLeg `json:"legs"`        // multi_city 的每一段
+}
+
+// flightLeg 一段航程；Date 沒有給時以行程的第 Day 天 (從 1 開始) 推算
+type flightLeg struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Date string `json:"date,omitempty"`
+	Day  int    `json:"day,omitempty"`
+}
+
+// flightSearch 檢查過的搜尋條件
+type flightSearch struct {
+	TripType string      `json:"trip_type"`
+	Cabin    string      `json:"cabin"`
+	Adults   int         `json:"adults"`
+	Legs     []flightLeg `json:"legs"`
+}
+
+// FlightLink 一個比價網站的搜尋連結
+type FlightLink struct {
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+}
+
+// flightProvider 依搜尋條件組出連結，不支援時回傳錯誤說明原因
+type flightProvider struct {
+	name  string
+	build func(s flightSearch) (string, error)
+}
+
+var flightProviders = []flightProvider{
+	{"skyscanner", skyscannerLink},
+	{"google_flights", googleFlightsLink},
+	{"kayak", kayakLink},
+}
+
+// normalizeFlightCode 代碼轉大寫並檢查格式；不在機場資料集時只給警告
+func normalizeFlightCode(field, code string, warnings *[]string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !isIATACode(code) {
+		return "", fmt.Errorf("%s %q 不是 IATA 代碼", field, code)
+	}
+	if _, ok := airports.ByCode(code); !ok {
+		*warnings = append(*warnings, fmt.Sprintf("%s %s 不在機場資料集，請確認代碼", field, code))
+	}
+	return code, nil
+}
+
+// buildFlightSearch 以行程補上預設值並檢查搜尋條件
+func buildFlightSearch(req flightLinksRequest, trip Trip, today time.Time) (flightSearch, []string, error) {
+	var warnings []string
+	s := flightSearch{TripType: req.TripType, Cabin: req.Cabin, Adults: req.Adults}
+
+	if s.TripType == "" {
+		s.TripType = flightReturn
+	}
+	if !slices.Contains(flightTripTypes, s.TripType) {
+		return s, nil, fmt.Errorf("trip_type 必須是 %s", strings.Join(flightTripTypes, " / "))
+	}
+	if s.Cabin == "" {
+		s.Cabin = "economy"
+	}
+	if !slices.Contains(flightCabins, s.Cabin) {
+		return s, nil, fmt.Errorf("cabin 必須是 %s", strings.Join(flightCabins, " / "))
+	}
+	if s.Adults == 0 {
+		s.Adults = max(trip.People, 1)
+	}
+	if s.Adults < 1 || s.Adults > maxFlightAdults {
+		return s, nil, fmt.Errorf("adults 必須是 1 ~ %d", maxFlightAdults)
+	}
+
+	// 行程的第 day 天 (從 1 開始)
+	var start time.Time
+	if trip.StartDate != "" {
+		var err error
+		if start, err = time.Parse("2006-01-02", trip.StartDate); err != nil {
+			return s, nil, fmt.Errorf("行程的 start_date %q 不合法", trip.StartDate)
+		}
+	}
+	dayDate := func(day int) (string, error) {
+		if start.IsZero() {
+			return "", fmt.Errorf("行程沒有 start_date，請在 legs 指定 date")
+		}
+		if day < 1 || (trip.Days > 0 && day > trip.Days) {
+			return "", fmt.Errorf("day %d 不在行程的 1 ~ %d 天內", day, trip.Days)
+		}
+		return start.AddDate(0, 0, day-1).Format("2006-01-02"), nil
+	}
+
+	if s.TripType == flightMultiCity {
+		if len(req.Legs) < 2 || len(req.Legs) > maxFlightLegs {
+			return s, nil, fmt.Errorf("multi_city 需要 2 ~ %d 段 legs", maxFlightLegs)
+		}
+		for i, leg := range req.Legs {
+			field := fmt.Sprintf("legs[%d]", i)
+			var err error
+			if leg.From, err = normalizeFlightCode(field+".from", leg.From, &warnings); err != nil {
+				return s, nil, err
+			}
+			if leg.To, err = normalizeFlightCode(field+".to", leg.To, &warnings); err != nil {
+				return s, nil, err
+			}
+			if leg.From == leg.To {
+				return s, nil, fmt.Errorf("%s 的出發地與目的地相同", field)
+			}
+			switch {
+			case leg.Date != "":
+				if _, err := time.Parse("2006-01-02", leg.Date); err != nil {
+					return s, nil, fmt.Errorf("%s.date %q 不是 YYYY-MM-DD", field, leg.Date)
+				}
+			case leg.Day > 0:
+				if leg.Date, err = dayDate(leg.Day); err != nil {
+					return s, nil, fmt.Errorf("%s: %w", field, err)
+				}
+			default:
+				return s, nil, fmt.Errorf("%s 需要 date 或 day", field)
+			}
+			leg.Day = 0
+			if i > 0 && leg.Date < s.Legs[i-1].Date {
+				return s, nil, fmt.Errorf("%s 的日期早於前一段", field)
+			}
+			s.Legs = append(s.Legs, leg)
+		}
+	} else {
+		origin, err := normalizeFlightCode("origin", req.Origin, &warnings)
+		if err != nil {
+			return s, nil, err
+		}
+		dest, err := normalizeFlightCode("destination", req.Destination, &warnings)
+		if err != nil {
+			return s, nil, err
+		}
+		if origin == dest {
+			return s, nil, fmt.Errorf("出發地與目的地相同")
+		}
+		depart, err := dayDate(1)
+		if err != nil {
+			return s, nil, err
+		}
+		s.Legs = []flightLeg{{From: origin, To: dest, Date: depart}}
+		if s.TripType == flightReturn {
+			back, err := dayDate(max(trip.Days, 1))
+			if err != nil {
+				return s, nil, err
+			}
+			s.Legs = append(s.Legs, flightLeg{From: dest, To: origin, Date: back})
+		}
+	}
+
+	if s.Legs[0].Date < today.Format("2006-01-02") {
+		warnings = append(warnings, fmt.Sprintf("出發日 %s 已經過了", s.Legs[0].Date))
+	}
+	return s, warnings, nil
+}
+
+// ---------- 各網站的網址格式 ----------
+
+// skyscannerLink 單程 / 來回：/transport/flights/tpe/nrt/260422/260430/；多點：/transport/d/tpe/2026-04-22/nrt/...
+func skyscannerLink(s flightSearch) (string, error) {
+	cabin := strings.ReplaceAll(s.Cabin, "_", "") // premiumeconomy
+	q := url.Values{}
+	q.Set("adultsv2", strconv.Itoa(s.Adults))
+	q.Set("cabinclass", cabin)
+
+	yymmdd := func(date string) string { return strings.ReplaceAll(date, "-", "")[2:] }
+	var path []string
+	switch s.TripType {
+	case flightMultiCity:
+		path = []string{"transport", "d"}
+		for _, leg := range s.Legs {
+			path = append(path, strings.ToLower(leg.From), leg.Date, strings.ToLower(leg.To))
+		}
+	default:
+		leg := s.Legs[0]
+		path = []string{"transport", "flights", strings.ToLower(leg.From), strings.ToLower(leg.To), yymmdd(leg.Date)}
+		if s.TripType == flightReturn {
+			path = append(path, yymmdd(s.Legs[1].Date))
+			q.Set("rtn", "1")
+		} else {
+			q.Set("rtn", "0")
+		}
+	}
+	return "https://www.skyscanner.com.tw/" + strings.Join(path, "/") + "/?" + q.Encode(), nil
+}
+
+// googleFlightsLink 以自然語言的 q 參數查詢，例如 "Flights from TPE to NRT on 2026-04-22 through 2026-04-30"
+func googleFlightsLink(s flightSearch) (string, error) {
+	if s.TripType == flightMultiCity {
+		return "", fmt.Errorf("Google Flights 的多點航程無法以網址參數指定")
+	}
+	leg := s.Legs[0]
+	query := fmt.Sprintf("Flights from %s to %s on %s", leg.From, leg.To, leg.Date)
+	if s.TripType == flightReturn {
+		query += " through " + s.Legs[1].Date
+	} else {
+		query += " one way"
+	}
+	if s.Cabin != "economy" {
+		query += " " + strings.ReplaceAll(s.Cabin, "_", " ") + " class"
+	}
+	query += fmt.Sprintf(" for %d adults", s.Adults)
+
+	q := url.Values{}
+	q.Set("q", query)
+	q.Set("hl", "zh-TW")
+	return "https://www.google.com/travel/flights?" + q.Encode(), nil
+}
+
+// kayakLink /flights/TPE-NRT/2026-04-22/2026-04-30/business/2adults；多點時每段各一組「代碼-代碼/日期」
+func kayakLink(s flightSearch) (string, error) {
+	path := []string{"flights"}
+	switch s.TripType {
+	case flightMultiCity:
+		for _, leg := range s.Legs {
+			path = append(path, leg.From+"-"+leg.To, leg.Date)
+		}
+	default:
+		path = append(path, s.Legs[0].From+"-"+s.Legs[0].To, s.Legs[0].Date)
+		if s.TripType == flightReturn {
+			path = append(path, s.Legs[1].Date)
+		}
+	}
+	// 經濟艙與 1 位成人是預設值，不需要放進網址
+	if s.Cabin != "economy" {
+		path = append(path, strings.TrimSuffix(s.Cabin, "_economy")) // premium / business / first
+	}
+	if s.Adults > 1 {
+		path = append(path, strconv.Itoa(s.Adults)+"adults")
+	}
+	return "https://www.kayak.com/" + strings.Join(path, "/"), nil
+}
+
+// buildFlightLinks 產生各網站的連結；不支援的網站放進 unsupported
+func buildFlightLinks(s flightSearch) (links []FlightLink, unsupported []gin.H) {
+	links = []FlightLink{}
+	for _, p := range flightProviders {
+		link, err := p.build(s)
+		if err != nil {
+			unsupported = append(unsupported, gin.H{"provider": p.name, "reason": err.Error()})
+			continue
+		}
+		links = append(links, FlightLink{Provider: p.name, URL: link})
+	}
+	return links, unsupported
+}
+
+// tripFlightLinks 產生行程的航班搜尋連結：POST /api/trips/:id/flights/links
+func tripFlightLinks(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req flightLinksRequest
+	// body 可省略，全部使用預設值
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	if req.TripType != flightMultiCity {
+		if req.Origin == "" {
+			req.Origin = envOr("FLIGHT_DEFAULT_ORIGIN", "TPE")
+		}
+		if req.Destination == "" {
+			origin, cityCode, ok, err := tripAirportOrigin(ctx, trip)
+			if err != nil || !ok {
+				c.JSON(422, gin.H{"error": "無法由行程推算目的地機場，請指定 destination"})
+				return
+			}
+			if cityCode == "" {
+				cityCode = newNearestAirports(origin, 1).CityCode
+			}
+			req.Destination = cityCode
+		}
+	}
+
+	search, warnings, err := buildFlightSearch(req, trip, time.Now())
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	links, unsupported := buildFlightLinks(search)
+
+	c.JSON(200, gin.H{
+		"trip_id":     trip.ID,
+		"search":      search,
+		"links":       links,
+		"unsupported": unsupported,
+		"warnings":    warnings,
+	})
+}
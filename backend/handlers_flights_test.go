@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFlightSearch(t *testing.T) {
+	today := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	trip := Trip{StartDate: "2026-04-22", Days: 9, People: 2}
+
+	s, warnings, err := buildFlightSearch(flightLinksRequest{Origin: "tpe", Destination: "TYO"}, trip, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TripType != flightReturn || s.Cabin != "economy" || s.Adults != 2 || len(warnings) != 0 {
+		t.Errorf("search = %+v, warnings = %q", s, warnings)
+	}
+	if len(s.Legs) != 2 || s.Legs[0] != (flightLeg{From: "TPE", To: "TYO", Date: "2026-04-22"}) || s.Legs[1] != (flightLeg{From: "TYO", To: "TPE", Date: "2026-04-30"}) {
+		t.Errorf("legs = %+v", s.Legs)
+	}
+
+	s, _, err = buildFlightSearch(flightLinksRequest{TripType: flightMultiCity, Legs: []flightLeg{
+		{From: "TPE", To: "NRT", Day: 1},
+		{From: "KIX", To: "TPE", Date: "2026-04-30"},
+	}}, trip, today)
+	if err != nil || s.Legs[0].Date != "2026-04-22" || s.Legs[0].Day != 0 {
+		t.Errorf("multi city = %+v, %v", s, err)
+	}
+
+	// 已經過的出發日與資料集沒有的代碼只警告
+	_, warnings, err = buildFlightSearch(flightLinksRequest{Origin: "TPE", Destination: "REP", TripType: flightOneWay}, trip, time.Date(2026, 5, 1, 0, 0, 0, 0, time.Local))
+	if err != nil || len(warnings) != 2 {
+		t.Errorf("warnings = %q, %v", warnings, err)
+	}
+
+	for name, req := range map[string]flightLinksRequest{
+		"bad type":     {Origin: "TPE", Destination: "NRT", TripType: "round"},
+		"bad cabin":    {Origin: "TPE", Destination: "NRT", Cabin: "luxury"},
+		"bad code":     {Origin: "Taipei", Destination: "NRT"},
+		"same airport": {Origin: "TPE", Destination: "tpe"},
+		"too many":     {Origin: "TPE", Destination: "NRT", Adults: 10},
+		"one leg":      {TripType: flightMultiCity, Legs: []flightLeg{{From: "TPE", To: "NRT", Day: 1}}},
+		"day range":    {TripType: flightMultiCity, Legs: []flightLeg{{From: "TPE", To: "NRT", Day: 1}, {From: "NRT", To: "TPE", Day: 10}}},
+		"out of order": {TripType: flightMultiCity, Legs: []flightLeg{{From: "TPE", To: "NRT", Day: 5}, {From: "NRT", To: "TPE", Day: 2}}},
+	} {
+		if _, _, err := buildFlightSearch(req, trip, today); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, _, err := buildFlightSearch(flightLinksRequest{Origin: "TPE", Destination: "NRT"}, Trip{Days: 3}, today); err == nil {
+		t.Error("trip without start_date: expected error")
+	}
+}
+
+func TestBuildFlightLinks(t *testing.T) {
+	roundTrip := flightSearch{TripType: flightReturn, Cabin: "business", Adults: 2, Legs: []flightLeg{
+		{From: "TPE", To: "TYO", Date: "2026-04-22"},
+		{From: "TYO", To: "TPE", Date: "2026-04-30"},
+	}}
+	links, unsupported := buildFlightLinks(roundTrip)
+	want := map[string]string{
+		"skyscanner":     "https://www.skyscanner.com.tw/transport/flights/tpe/tyo/260422/260430/?adultsv2=2&cabinclass=business&rtn=1",
+		"google_flights": "https://www.google.com/travel/flights?hl=zh-TW&q=Flights+from+TPE+to+TYO+on+2026-04-22+through+2026-04-30+business+class+for+2+adults",
+		"kayak":          "https://www.kayak.com/flights/TPE-TYO/2026-04-22/2026-04-30/business/2adults",
+	}
+	if len(links) != 3 || len(unsupported) != 0 {
+		t.Fatalf("links = %+v, unsupported = %v", links, unsupported)
+	}
+	for _, l := range links {
+		if l.URL != want[l.Provider] {
+			t.Errorf("%s = %s", l.Provider, l.URL)
+		}
+	}
+
+	oneWay := flightSearch{TripType: flightOneWay, Cabin: "premium_economy", Adults: 1, Legs: roundTrip.Legs[:1]}
+	links, _ = buildFlightLinks(oneWay)
+	if !strings.HasSuffix(links[0].URL, "/260422/?adultsv2=1&cabinclass=premiumeconomy&rtn=0") || links[2].URL != "https://www.kayak.com/flights/TPE-TYO/2026-04-22/premium" {
+		t.Errorf("one way = %+v", links)
+	}
+
+	multi := flightSearch{TripType: flightMultiCity, Cabin: "economy", Adults: 1, Legs: []flightLeg{
+		{From: "TPE", To: "NRT", Date: "2026-04-22"},
+		{From: "KIX", To: "TPE", Date: "2026-04-30"},
+	}}
+	links, unsupported = buildFlightLinks(multi)
+	if len(links) != 2 || len(unsupported) != 1 || unsupported[0]["provider"] != "google_flights" {
+		t.Fatalf("multi city = %+v, %v", links, unsupported)
+	}
+	if links[0].URL != "https://www.skyscanner.com.tw/transport/d/tpe/2026-04-22/nrt/kix/2026-04-30/tpe/?adultsv2=1&cabinclass=economy" ||
+		links[1].URL != "https://www.kayak.com/flights/TPE-NRT/2026-04-22/KIX-TPE/2026-04-30" {
+		t.Errorf("multi city = %+v", links)
+	}
+}
@@ -1,20 +1,53 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"regexp"
-	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 )
 
+// 導遊角色的 system instruction；實際內容來自 prompts/tour_guide_system，這裡只在模板失效時使用
+const tourGuideSystemPrompt = "你是一個專業導遊。"
+
+// 可以使用行程工具時附加的說明
+const tripToolsSystemPrompt = "使用者要求修改行程時，請先用 get_trip 查看目前的 plan，再用工具直接修改，最後用一兩句話說明改了什麼。"
+
+// chatLocale 對話使用的語系：req.Locale 優先，其次依請求
+func chatLocale(c *gin.Context, req ChatRequest) string {
+	if req.Locale != "" {
+		return normalizeLocale(req.Locale)
+	}
+	return requestLocale(c)
+}
+
+// chatPrompt 決定這次對話的 system instruction 與訊息；
+// req.Template 不為空時以 session 的行程渲染該模板，當作 SYSTEM_prompt 規劃指令送出
+func chatPrompt(c *gin.Context, req ChatRequest, session *ChatSession) (string, string, []PromptRef, error) {
+	locale := chatLocale(c, req)
+	system, ref := tourGuideSystem(locale)
+	refs := []PromptRef{ref}
+
+	if req.Template == "" {
+		return system, req.Message, refs, nil
+	}
+	if session == nil {
+		return "", "", nil, errors.New("template 需要搭配 session_id")
+	}
+	trip, err := findTripByID(c.Request.Context(), session.TripID)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("讀取行程失敗: %w", err)
+	}
+	text, tref, err := prompts.Render(req.Template, locale, TripPromptVars{Trip: tripWithoutPhotos(trip)})
+	if err != nil {
+		return "", "", nil, err
+	}
+	return system, systemPromptPrefix + " " + text, append(refs, tref), nil
+}
+
 // chatWithGemini 處理帶有上下文的對話 (Debug 版)
+// 帶 session_id 時從 chat_sessions 載入歷史，並在回覆後寫回這一輪的訊息；
+// 此時模型也可以呼叫 tripTools 修改該行程，回應會附上套用的 mutations
 func chatWithGemini(c *gin.Context) {
 	fmt.Println("🚀 收到對話請求...") // Debug Log
 
@@ -25,235 +58,197 @@ func chatWithGemini(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	// 你的 API Key (確認已填入)
-	apiKey := os.Getenv("GEMINI_API_KEY")
+	llm, err := llmFor(taskChat)
+	if err != nil {
+		fmt.Println("❌ 無法取得 LLM provider:", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 
-	fmt.Println("🔑 使用 API Key:", apiKey[:10]+"...") // 只印前10碼確認有讀到
+	// 有 session_id 時歷史由伺服器載入，忽略前端傳來的 history
+	history := req.History
+	var session *ChatSession
+	if req.SessionID != "" {
+		session, err = loadChatSession(c, req.SessionID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Session not found"})
+			return
+		}
+		history = session.modelHistory()
+		setUsageTrip(c.Request.Context(), session.TripID)
+	}
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	system, message, refs, err := chatPrompt(c, req, session)
 	if err != nil {
-		fmt.Println("❌ 無法建立 Client:", err)
-		c.JSON(500, gin.H{"error": "無法建立 Gemini Client: " + err.Error()})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	defer client.Close()
-
-	model := client.GenerativeModel("gemini-2.5-flash-lite")
-	model.SystemInstruction = genai.NewUserContent(genai.Text("你是一個專業導遊。"))
-	model.SetMaxOutputTokens(8192)
-	model.SetTemperature(0.7)
-
-	cs := model.StartChat()
-
-	// 處理歷史紀錄
-	if len(req.History) > 0 {
-		fmt.Printf("📚 載入歷史紀錄: %d 則\n", len(req.History))
-		var chatHistory []*genai.Content
-		for _, h := range req.History {
-			role := "user"
-			if h.Role == "model" || h.Role == "assistant" {
-				role = "model"
-			}
-			chatHistory = append(chatHistory, &genai.Content{
-				Role:  role,
-				Parts: []genai.Part{genai.Text(h.Text)},
-			})
-		}
-		cs.History = chatHistory
+	if session == nil && req.TripID != 0 {
+		setUsageTrip(c.Request.Context(), req.TripID)
 	}
 
-	fmt.Println("📤 正在發送訊息給 Google...")
+	// 釘選行程 JSON，歷史太長時摘要或截斷
+	system, history, window := prepareChatContext(c, llm, chatLocale(c, req), system, message, history, session, req.TripID)
+
+	fmt.Printf("📚 載入歷史紀錄: %d 則\n", len(history))
+	fmt.Printf("📤 正在發送訊息給 %s...\n", llm.Name())
+
+	llmReq := LLMRequest{
+		System:      system,
+		History:     history,
+		Prompt:      message,
+		Temperature: float32Ptr(0.7),
+		MaxTokens:   8192,
+	}
 
-	// 發送請求
-	res, err := cs.SendMessage(ctx, genai.Text(req.Message))
+	// 綁定 session 的對話可以用工具直接修改該行程
+	var res *LLMResponse
+	mutations := []PlanMutation{}
+	if session != nil {
+		tb := newTripToolbox(session.TripID)
+		llmReq.System += "\n" + tripToolsSystemPrompt
+		res, err = llm.ChatWithTools(c.Request.Context(), llmReq, tripTools(), tb.call)
+		mutations = append(mutations, tb.mutations...)
+	} else {
+		res, err = llm.Chat(c.Request.Context(), llmReq)
+	}
 	if err != nil {
-		fmt.Println("❌ Gemini API 錯誤:", err)
-		c.JSON(500, gin.H{"error": "Gemini API 錯誤: " + err.Error()})
+		fmt.Printf("❌ %s API 錯誤: %v\n", llm.Name(), err)
+		// 工具可能在出錯前就已經改過行程，一併回報
+		respondLLMError(c, llm.Name(), err, gin.H{"mutations": mutations})
 		return
 	}
 
-	fmt.Println("✅ 收到 Gemini 回應！")
+	fmt.Println("✅ 收到模型回應！")
 
-	var responseText string
-	if len(res.Candidates) > 0 {
-		for _, part := range res.Candidates[0].Content.Parts {
-			if txt, ok := part.(genai.Text); ok {
-				responseText += string(txt)
-			}
+	if session != nil {
+		if err := recordChatTurn(c, session, message, res.Text, refs); err != nil {
+			fmt.Println("❌ 對話紀錄寫入失敗:", err)
 		}
 	}
 
-	c.JSON(200, gin.H{"reply": responseText})
+	c.JSON(200, gin.H{"reply": res.Text, "mutations": mutations, "prompts": refs, "context": window})
 }
 
-// ====== Gemini 呼叫 (單次) ======
-func callGemini(c *gin.Context) {
-	var req struct {
-		Model  string `json:"model"`
-		Prompt string `json:"prompt"`
-	}
+// chatWithGeminiStream 與 chatWithGemini 相同，但以 Server-Sent Events 逐段回傳
+//
+//	event: chunk  data: {"text": "..."}
+//	event: done   data: {"reply": "完整內容", "finish_reason": "STOP", "prompts": [...], "context": {...}}
+//	event: error  data: {"error": "..."}
+//
+// 前端中斷連線時 request context 會被取消，上游的生成也會跟著停止。
+func chatWithGeminiStream(c *gin.Context) {
+	var req ChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		c.JSON(400, gin.H{"error": "JSON 格式錯誤: " + err.Error()})
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	// 1. 建立 Client (同樣建議改用環境變數)
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	llm, err := llmFor(taskChat)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer client.Close()
 
-	// 2. 設定模型
-	modelName := req.Model
-	if modelName == "" {
-		modelName = "gemini-2.5-flash-lite" // 建議使用目前穩定的版本
+	history := req.History
+	var session *ChatSession
+	if req.SessionID != "" {
+		session, err = loadChatSession(c, req.SessionID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Session not found"})
+			return
+		}
+		history = session.modelHistory()
+		setUsageTrip(c.Request.Context(), session.TripID)
 	}
-	model := client.GenerativeModel(modelName)
 
-	// 3. 發送請求 (新版 SDK 語法)
-	res, err := model.GenerateContent(ctx, genai.Text(req.Prompt))
+	system, message, refs, err := chatPrompt(c, req, session)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Generate error: " + err.Error()})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 4. 解析回應
-	var responseText string
-	if len(res.Candidates) > 0 {
-		for _, part := range res.Candidates[0].Content.Parts {
-			if txt, ok := part.(genai.Text); ok {
-				responseText += string(txt)
-			}
-		}
+	ctx := c.Request.Context()
+	if session == nil && req.TripID != 0 {
+		setUsageTrip(ctx, req.TripID)
 	}
 
-	c.JSON(200, gin.H{"text": responseText})
-}
-
-// saveGeminiToFile 將收到的文字儲存為 data 目錄下的檔案
-func saveGeminiToFile(c *gin.Context) {
-	var req struct {
-		Filename string `json:"filename"`
-		Name     string `json:"name"`
-		Text     string `json:"text"`
-		Format   string `json:"format"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	// 開始串流後就不能再回傳 429，先檢查配額
+	if err := checkQuota(ctx, usageScopeFrom(ctx)); err != nil {
+		respondLLMError(c, llm.Name(), err, nil)
 		return
 	}
 
-	name := req.Filename
-	if name == "" {
-		name = fmt.Sprintf("gemini_%d", time.Now().Unix())
-	}
-
-	// 只保留安全字元
-	re := regexp.MustCompile(`[^A-Za-z0-9._-]`)
-	name = re.ReplaceAllString(name, "_")
+	system, history, window := prepareChatContext(c, llm, chatLocale(c, req), system, message, history, session, req.TripID)
 
-	ext := ".txt"
-	if strings.ToLower(req.Format) == "json" {
-		ext = ".json"
-	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
 
-	// 若為 JSON 格式，固定檔名為 response.json（寫入 data/response.json）
-	if strings.ToLower(req.Format) == "json" {
-		name = "response" + ext
-	} else {
-		if !strings.HasSuffix(name, ext) {
-			name = name + ext
+	res, err := llm.Stream(ctx, LLMRequest{
+		System:      system,
+		History:     history,
+		Prompt:      message,
+		Temperature: float32Ptr(0.7),
+		MaxTokens:   8192,
+	}, func(chunk string) {
+		c.SSEvent("chunk", gin.H{"text": chunk})
+		c.Writer.Flush()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("⚠️ 前端已中斷串流:", ctx.Err())
+			return
 		}
+		_, body := llmErrorBody(llm.Name(), err)
+		c.SSEvent("error", body)
+		c.Writer.Flush()
+		return
 	}
 
-	dest := filepath.Join("../data", name)
-
-	// 若請求要求 JSON 格式，將回應切段並 append 到目標檔案的 response 陣列
-	if strings.ToLower(req.Format) == "json" {
-		// 不切段：將整段回覆視為 single element，trim 後 append（若為空則不 append）
-		var parts []string
-		if t := strings.TrimSpace(req.Text); t != "" {
-			parts = []string{t}
-		} else {
-			parts = []string{}
-		}
-
-		type OutFile struct {
-			Name     string   `json:"name"`
-			Response []string `json:"response"`
-		}
-
-		var out OutFile
-		// 若檔案已存在，讀取並合併
-		if b, err := os.ReadFile(dest); err == nil {
-			if err := json.Unmarshal(b, &out); err != nil {
-				// 若既有檔案不是期望格式，覆寫為新格式
-				out = OutFile{Name: req.Name, Response: []string{}}
-			}
-		} else {
-			// 檔案不存在，建立新結構
-			out = OutFile{Name: req.Name, Response: []string{}}
-		}
-
-		// 若 out.Name 為空，填入 req.Name
-		if out.Name == "" {
-			out.Name = req.Name
-		}
-
-		// Append parts
-		out.Response = append(out.Response, parts...)
-
-		data, err := json.MarshalIndent(out, "", "  ")
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+	// 中斷的串流不寫入 session，只有完整的回覆才算一輪
+	if session != nil {
+		if err := recordChatTurn(c, session, message, res.Text, refs); err != nil {
+			fmt.Println("❌ 對話紀錄寫入失敗:", err)
 		}
+	}
 
-		// 寫回檔案（覆蓋）
-		if err := os.WriteFile(dest, data, 0644); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
+	c.SSEvent("done", gin.H{
+		"reply":         res.Text,
+		"finish_reason": res.FinishReason,
+		"prompts":       refs,
+		"context":       window,
+	})
+	c.Writer.Flush()
+}
 
-		c.JSON(200, gin.H{"path": dest})
-		return
+// ====== Gemini 呼叫 (單次) ======
+func callGemini(c *gin.Context) {
+	var req struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
 	}
-
-	// 否則當作純文字寫入
-	if err := os.WriteFile(dest, []byte(req.Text), 0644); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, gin.H{"path": dest})
-}
-
-// getGeminiResponse 讀取 data/response.json 並回傳 JSON 結構
-func getGeminiResponse(c *gin.Context) {
-	path := filepath.Join("../data", "response.json")
-	b, err := os.ReadFile(path)
+	llm, err := llmFor(taskChat)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// 回傳空的結構
-			c.JSON(200, gin.H{"name": "", "response": []string{}})
-			return
-		}
-		c.JSON(500, gin.H{"error": err.Error()})
+		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
 		return
 	}
 
-	var out interface{}
-	if err := json.Unmarshal(b, &out); err != nil {
-		// 若檔案不是合法 JSON，回傳原始文字
-		c.JSON(200, gin.H{"raw": string(b)})
+	// 沒有指定模型時使用任務 / provider 的預設模型
+	res, err := llm.Generate(c.Request.Context(), LLMRequest{
+		Model:  req.Model,
+		Prompt: req.Prompt,
+	})
+	if err != nil {
+		respondLLMError(c, llm.Name(), err, nil)
 		return
 	}
-	c.JSON(200, out)
+
+	c.JSON(200, gin.H{"text": res.Text})
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 結構化輸出最多重試次數 (含第一次)
+const generateMaxAttempts = 3
+
+var reClock = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+// generatedPlan 模型依 planResponseSchema 回傳的 JSON
+type generatedPlan struct {
+	Days []Day `json:"days"`
+}
+
+// planItemSchema 對應 Item 的 JSON schema
+func planItemSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"time":         {Type: "string", Description: "開始時間，24 小時制 HH:MM"},
+			"duration_min": {Type: "integer", Description: "停留分鐘數"},
+			"title":        {Type: "string", Description: "景點或餐廳名稱"},
+			"address":      {Type: "string", Description: "地址"},
+			"lat":          {Type: "number", Description: "緯度"},
+			"lng":          {Type: "number", Description: "經度"},
+			"link":         {Type: "string", Description: "官方網站或地圖連結，沒有就留空"},
+			"note":         {Type: "string", Description: "介紹、交通方式與注意事項"},
+		},
+		Required: []string{"time", "duration_min", "title", "address", "note"},
+	}
+}
+
+// planResponseSchema 對應 Day / Item 的 JSON schema
+func planResponseSchema() *JSONSchema {
+	day := &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"day_index": {Type: "integer", Description: "第幾天，從 1 開始"},
+			"note":      {Type: "string", Description: "當天主題"},
+			"items":     {Type: "array", Items: planItemSchema()},
+		},
+		Required: []string{"day_index", "items"},
+	}
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"days": {Type: "array", Items: day},
+		},
+		Required: []string{"days"},
+	}
+}
+
+// validatePlan 檢查模型輸出是否符合行程需求，回傳所有違規項目
+func validatePlan(days []Day, trip Trip) []string {
+	var problems []string
+
+	if len(days) != trip.Days {
+		problems = append(problems, fmt.Sprintf("days 應有 %d 筆，實際為 %d 筆", trip.Days, len(days)))
+	}
+
+	seen := map[int]bool{}
+	for _, d := range days {
+		if d.DayIndex < 1 || d.DayIndex > trip.Days {
+			problems = append(problems, fmt.Sprintf("day_index %d 超出範圍 1-%d", d.DayIndex, trip.Days))
+		}
+		if seen[d.DayIndex] {
+			problems = append(problems, fmt.Sprintf("day_index %d 重複", d.DayIndex))
+		}
+		seen[d.DayIndex] = true
+
+		if len(d.Items) == 0 {
+			problems = append(problems, fmt.Sprintf("第 %d 天沒有任何 item", d.DayIndex))
+		}
+		for j, it := range d.Items {
+			if strings.TrimSpace(it.Title) == "" {
+				problems = append(problems, fmt.Sprintf("第 %d 天第 %d 個 item 缺少 title", d.DayIndex, j+1))
+			}
+			if !reClock.MatchString(it.Time) {
+				problems = append(problems, fmt.Sprintf("第 %d 天第 %d 個 item 的 time %q 不是 HH:MM", d.DayIndex, j+1, it.Time))
+			}
+			if it.DurationMin < 0 {
+				problems = append(problems, fmt.Sprintf("第 %d 天第 %d 個 item 的 duration_min 為負數", d.DayIndex, j+1))
+			}
+		}
+	}
+	return problems
+}
+
+// generateTripPlan 以結構化輸出產生行程：POST /api/trips/:id/generate
+// draft=true 時只回傳結果，不寫入資料庫
+func generateTripPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		Draft bool   `json:"draft"`
+	}
+	// body 可省略
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	if trip.Days <= 0 {
+		c.JSON(400, gin.H{"error": "行程天數必須大於 0"})
+		return
+	}
+
+	llm, err := llmFor(taskGenerate)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
+		return
+	}
+
+	locale := requestLocale(c)
+	system, sref := tourGuideSystem(locale)
+	var ref PromptRef
+
+	var plan generatedPlan
+	var problems []string
+	attempts := 0
+	for attempts < generateMaxAttempts {
+		attempts++
+
+		// 重試時把上一次的違規項目一起放進 prompt
+		text, pref, err := prompts.Render("plan_generate", locale, PlanGeneratePromptVars{Trip: tripWithoutPhotos(trip), Problems: problems})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		ref = pref
+
+		res, err := llm.Structured(ctx, LLMRequest{
+			Model:       req.Model,
+			System:      system,
+			Prompt:      text,
+			Temperature: float32Ptr(0.7),
+			MaxTokens:   8192,
+		}, planResponseSchema())
+		if err != nil {
+			respondLLMError(c, llm.Name(), err, nil)
+			return
+		}
+
+		plan = generatedPlan{}
+		if err := json.Unmarshal([]byte(res.Text), &plan); err != nil {
+			problems = []string{"輸出不是合法的 JSON: " + err.Error()}
+			continue
+		}
+		problems = validatePlan(plan.Days, trip)
+		if len(problems) == 0 {
+			break
+		}
+	}
+
+	if len(problems) > 0 {
+		c.JSON(502, gin.H{
+			"error":    "模型輸出不符合行程格式",
+			"problems": problems,
+			"attempts": attempts,
+		})
+		return
+	}
+
+	days := finalizeDays(plan.Days, trip.StartDate)
+
+	if !req.Draft {
+		if err := saveTripPlan(ctx, id, days); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"plan":     days,
+		"draft":    req.Draft,
+		"attempts": attempts,
+		"prompts":  []PromptRef{sref, ref},
+	})
+}
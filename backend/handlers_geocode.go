@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 補上 item 座標 ==========
+//
+// POST /api/trips/:id/geocode 為沒有座標的 item 查詢地理編碼 (geocode.go，Nominatim 有速率限制與快取)。
+// 有地址時先查地址，查不到再以「標題 + 地區」查詢；每筆結果附上信心分數 (confidence)，
+// 同時找到幾個相距較遠的地點時列在 ambiguous，讓使用者確認。
+// 信心分數低於 min_confidence 的結果只回報、不寫入。
+
+const (
+	geocodeCandidateLimit       = 5   // 每次查詢取回幾筆，用來判斷是否有歧義
+	geocodeRegionRadiusKm       = 150 // 與地區中心距離超過這個範圍視為找錯城市
+	geocodeSamePlaceKm          = 1.0 // 相距這個範圍內的結果視為同一個地點
+	maxGeocodeAmbiguous         = 3
+	defaultGeocodeMinConfidence = 0.3
+)
+
+// geocodeRequest POST /api/trips/:id/geocode 的 body (可省略)
+type geocodeRequest struct {
+	MinConfidence *float64 `json:"min_confidence"` // 預設 0.3
+}
+
+// geocodeMatch 其他可能的地點
+type geocodeMatch struct {
+	DisplayName string  `json:"display_name"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	DistanceKm  float64 `json:"distance_km"` // 與採用的結果相距
+}
+
+// geocodeItemResult 每個 item 的查詢結果
+type geocodeItemResult struct {
+	ItemID      string         `json:"item_id"`
+	DayIndex    int            `json:"day_index"`
+	Title       string         `json:"title"`
+	Query       string         `json:"query"`
+	Status      string         `json:"status"` // filled / low_confidence / not_found / error
+	Lat         float64        `json:"lat,omitempty"`
+	Lng         float64        `json:"lng,omitempty"`
+	DisplayName string         `json:"display_name,omitempty"`
+	Confidence  float64        `json:"confidence"`
+	Ambiguous   []geocodeMatch `json:"ambiguous,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// itemGeocodeQueries 依序嘗試的查詢字詞：地址、標題 + 地區
+func itemGeocodeQueries(it Item, region string) []string {
+	var queries []string
+	for _, q := range []string{strings.TrimSpace(it.Address), placeQuery(it.Title, region)} {
+		if q != "" && (len(queries) == 0 || queries[0] != q) {
+			queries = append(queries, q)
+		}
+	}
+	return queries
+}
+
+// scoreGeocode 由查詢結果挑出採用的一筆並計算信心分數：
+//   - 以地址查到的比以標題查到的可靠，再依 Nominatim 的 importance 加分
+//   - 有地區中心 (anchor) 時，優先採用地區範圍內的結果；範圍外的大幅降低分數
+//   - 還有其他重要性相近、但距離超過 1 公里的結果時視為有歧義
+func scoreGeocode(results []GeoResult, anchor *GeoResult, byAddress bool) (best GeoResult, confidence float64, ambiguous []geocodeMatch) {
+	inRegion := func(r GeoResult) bool {
+		return anchor == nil || haversineKm(anchor.Lat, anchor.Lng, r.Lat, r.Lng) <= geocodeRegionRadiusKm
+	}
+	bestIdx := 0
+	for i, r := range results {
+		if inRegion(r) {
+			bestIdx = i
+			break
+		}
+	}
+	best = results[bestIdx]
+
+	confidence = 0.45
+	if byAddress {
+		confidence = 0.6
+	}
+	confidence += 0.4 * math.Min(math.Max(best.Importance, 0), 1)
+	if !inRegion(best) {
+		confidence *= 0.3
+	}
+
+	competing := false
+	for i, r := range results {
+		if i == bestIdx || !inRegion(r) {
+			continue
+		}
+		d := haversineKm(best.Lat, best.Lng, r.Lat, r.Lng)
+		if d <= geocodeSamePlaceKm {
+			continue
+		}
+		if r.Importance >= best.Importance-0.1 {
+			competing = true
+		}
+		if len(ambiguous) < maxGeocodeAmbiguous {
+			ambiguous = append(ambiguous, geocodeMatch{DisplayName: r.DisplayName, Lat: r.Lat, Lng: r.Lng, DistanceKm: math.Round(d*10) / 10})
+		}
+	}
+	if competing {
+		confidence *= 0.7
+	}
+	return best, math.Round(confidence*100) / 100, ambiguous
+}
+
+// geocodeTripItems 為沒有座標的 item 查詢並直接寫入 trip；信心分數低於 minConfidence 的不寫入。
+// ctx 結束 (例如使用者中斷請求) 時不再查詢，剩下的 item 回報為 error
+func geocodeTripItems(ctx context.Context, trip *Trip, g Geocoder, minConfidence float64) []geocodeItemResult {
+	// 地區中心用來排除其他城市的同名地點；查不到時不限制
+	var anchor *GeoResult
+	if region := strings.TrimSpace(trip.Region); region != "" {
+		if rs, err := g.Search(ctx, region, 1); err == nil && len(rs) > 0 {
+			anchor = &rs[0]
+		}
+	}
+
+	var results []geocodeItemResult
+	for d := range trip.Plan {
+		for i := range trip.Plan[d].Items {
+			it := &trip.Plan[d].Items[i]
+			queries := itemGeocodeQueries(*it, trip.Region)
+			if (it.Lat != 0 || it.Lng != 0) || len(queries) == 0 {
+				continue
+			}
+			r := geocodeItemResult{ItemID: it.ID, DayIndex: trip.Plan[d].DayIndex, Title: it.Title, Query: queries[0], Status: "not_found"}
+			for qi, q := range queries {
+				if err := ctx.Err(); err != nil {
+					r.Status, r.Error = "error", err.Error()
+					break
+				}
+				found, err := g.Search(ctx, q, geocodeCandidateLimit)
+				if err != nil {
+					r.Status, r.Error = "error", err.Error()
+					break
+				}
+				if len(found) == 0 {
+					continue
+				}
+				byAddress := qi == 0 && strings.TrimSpace(it.Address) != ""
+				best, confidence, ambiguous := scoreGeocode(found, anchor, byAddress)
+				r.Query, r.Lat, r.Lng, r.DisplayName = q, best.Lat, best.Lng, best.DisplayName
+				r.Confidence, r.Ambiguous = confidence, ambiguous
+				r.Status, r.Error = "low_confidence", ""
+				if confidence >= minConfidence {
+					r.Status = "filled"
+					it.Lat, it.Lng = best.Lat, best.Lng
+				}
+				break
+			}
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// geocodeTrip 補上行程中 item 的座標：POST /api/trips/:id/geocode
+// 寫入時行程已被修改則重新讀取後再補一次 (查詢結果有快取，不會重打 API)
+func geocodeTrip(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var req geocodeRequest
+	// body 可省略，全部使用預設值
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	minConfidence := defaultGeocodeMinConfidence
+	if req.MinConfidence != nil {
+		minConfidence = *req.MinConfidence
+	}
+
+	ctx := c.Request.Context()
+	for attempt := 0; attempt < 3; attempt++ {
+		trip, err := findTripByID(ctx, id)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Trip not found"})
+			return
+		}
+		results := geocodeTripItems(ctx, &trip, geocoder, minConfidence)
+		if ctx.Err() != nil {
+			return // 使用者已中斷請求
+		}
+		filled := 0
+		for _, r := range results {
+			if r.Status == "filled" {
+				filled++
+			}
+		}
+		resp := gin.H{"geocoder": geocoder.Name(), "results": results, "filled": filled, "version": trip.Version}
+		if filled == 0 {
+			c.JSON(200, resp)
+			return
+		}
+		err = saveTripPlanAt(ctx, id, trip.Plan, trip.Version)
+		if errors.Is(err, errVersionConflict) {
+			continue
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		resp["version"] = trip.Version + 1
+		c.JSON(200, resp)
+		return
+	}
+	c.JSON(409, gin.H{"error": "行程已被修改，請重新整理後再試"})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// mapGeocoder 依查詢字詞回傳固定結果，並記錄查詢順序
+type mapGeocoder struct {
+	results map[string][]GeoResult
+	calls   []string
+}
+
+func (g *mapGeocoder) Name() string { return "map" }
+
+func (g *mapGeocoder) Search(ctx context.Context, query string, limit int) ([]GeoResult, error) {
+	g.calls = append(g.calls, query)
+	if query == "fail 京都" {
+		return nil, errors.New("HTTP 429")
+	}
+	return g.results[query], nil
+}
+
+func TestGeocodeTripItems(t *testing.T) {
+	g := &mapGeocoder{results: map[string][]GeoResult{
+		"京都":            {{Lat: 35.0116, Lng: 135.7681, Importance: 0.8}},
+		"京都市東山区清水1-294": {{Lat: 34.9949, Lng: 135.7850, DisplayName: "清水寺", Importance: 0.6}},
+		"金閣寺 京都":        {{Lat: 35.0394, Lng: 135.7292, DisplayName: "金閣寺", Importance: 0.5}},
+		"中央公園 京都": {
+			{Lat: 40.7829, Lng: -73.9654, DisplayName: "Central Park, New York", Importance: 0.7},
+			{Lat: 35.0050, Lng: 135.7600, DisplayName: "中央公園, 京都", Importance: 0.3},
+			{Lat: 35.0500, Lng: 135.7000, DisplayName: "中央公園, 右京", Importance: 0.25},
+			{Lat: 35.0052, Lng: 135.7601, DisplayName: "中央公園 (入口)", Importance: 0.3},
+		},
+		"東京鐵塔 京都": {{Lat: 35.6586, Lng: 139.7454, DisplayName: "東京タワー", Importance: 0.5}},
+	}}
+	trip := Trip{Region: "京都", Plan: []Day{
+		{DayIndex: 1, Items: []Item{
+			{ID: "d1-1", Title: "清水寺", Address: "京都市東山区清水1-294"},
+			{ID: "d1-2", Title: "金閣寺", Address: "unknown street"},
+			{ID: "d1-3", Title: "二条城", Lat: 35.0142, Lng: 135.7482},
+			{ID: "d1-4", Title: "中央公園"},
+		}},
+		{DayIndex: 2, Items: []Item{
+			{ID: "d2-1", Title: "東京鐵塔"},
+			{ID: "d2-2", Title: "nowhere"},
+			{ID: "d2-3", Title: "fail"},
+		}},
+	}}
+
+	results := geocodeTripItems(context.Background(), &trip, g, 0.3)
+	if g.calls[0] != "京都" {
+		t.Errorf("calls = %q", g.calls)
+	}
+	byID := map[string]geocodeItemResult{}
+	for _, r := range results {
+		byID[r.ItemID] = r
+	}
+	if len(results) != 6 {
+		t.Fatalf("results = %+v", results)
+	}
+	if _, ok := byID["d1-3"]; ok {
+		t.Error("items with coordinates should be skipped")
+	}
+
+	// 地址查到的信心分數最高
+	if r := byID["d1-1"]; r.Status != "filled" || r.Confidence != 0.84 || len(r.Ambiguous) != 0 {
+		t.Errorf("d1-1 = %+v", r)
+	}
+	// 地址查無結果時改用標題 + 地區
+	if r := byID["d1-2"]; r.Status != "filled" || r.Query != "金閣寺 京都" || r.Confidence != 0.65 {
+		t.Errorf("d1-2 = %+v", r)
+	}
+	// 排除其他城市的同名地點，地區內還有另一個相近的結果時降低分數並列出
+	r := byID["d1-4"]
+	if r.Status != "filled" || r.Lat != 35.0050 || r.Confidence != 0.4 || len(r.Ambiguous) != 1 || r.Ambiguous[0].DisplayName != "中央公園, 右京" {
+		t.Errorf("d1-4 = %+v", r)
+	}
+	// 只找到其他城市的結果：回報但不寫入
+	if r := byID["d2-1"]; r.Status != "low_confidence" || r.Confidence != 0.2 || trip.Plan[1].Items[0].Lat != 0 {
+		t.Errorf("d2-1 = %+v", r)
+	}
+	if r := byID["d2-2"]; r.Status != "not_found" || r.Query != "nowhere 京都" {
+		t.Errorf("d2-2 = %+v", r)
+	}
+	if r := byID["d2-3"]; r.Status != "error" || r.Error == "" {
+		t.Errorf("d2-3 = %+v", r)
+	}
+
+	if it := trip.Plan[0].Items[0]; it.Lat != 34.9949 || it.Lng != 135.7850 {
+		t.Errorf("d1-1 item = %+v", it)
+	}
+	if it := trip.Plan[0].Items[2]; it.Lat != 35.0142 {
+		t.Errorf("existing coordinates changed: %+v", it)
+	}
+}
+
+func TestGeocodeTripItemsCanceled(t *testing.T) {
+	g := &mapGeocoder{}
+	trip := Trip{Region: "京都", Plan: []Day{{DayIndex: 1, Items: []Item{{ID: "d1-1", Title: "清水寺"}, {ID: "d1-2", Title: "金閣寺"}}}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := geocodeTripItems(ctx, &trip, g, 0.3)
+	if len(results) != 2 || results[0].Status != "error" || results[1].Status != "error" {
+		t.Errorf("results = %+v", results)
+	}
+	for _, q := range g.calls {
+		if q != "京都" {
+			t.Errorf("queried %q after cancel", q)
+		}
+	}
+}
+
+func TestItemGeocodeQueries(t *testing.T) {
+	if q := itemGeocodeQueries(Item{Title: "清水寺", Address: " 京都市東山区 "}, "京都"); len(q) != 2 || q[0] != "京都市東山区" || q[1] != "清水寺 京都" {
+		t.Errorf("queries = %q", q)
+	}
+	if q := itemGeocodeQueries(Item{Title: "京都塔", Address: "京都塔"}, "京都"); len(q) != 1 {
+		t.Errorf("duplicate queries = %q", q)
+	}
+	if q := itemGeocodeQueries(Item{}, ""); len(q) != 0 {
+		t.Errorf("empty item = %q", q)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// parseItinerary 將 Gemini 的 Markdown 行程回覆轉成結構化的 plan
+// 帶 trip_id 時會用行程的出發日期填日期；apply=true 時直接寫回該行程
+func parseItinerary(c *gin.Context) {
+	var req struct {
+		Text      string `json:"text"`
+		TripID    int    `json:"trip_id"`
+		StartDate string `json:"start_date"`
+		Apply     bool   `json:"apply"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Text == "" {
+		c.JSON(400, gin.H{"error": "Missing text"})
+		return
+	}
+	if req.Apply && req.TripID == 0 {
+		c.JSON(400, gin.H{"error": "apply 需要 trip_id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if req.TripID != 0 {
+		trip, err := findTripByID(ctx, req.TripID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Trip not found"})
+			return
+		}
+		if req.StartDate == "" {
+			req.StartDate = trip.StartDate
+		}
+	}
+
+	result := ParseItinerary(req.Text, req.StartDate)
+
+	if req.Apply {
+		if len(result.Days) == 0 {
+			c.JSON(422, gin.H{"error": "沒有解析出任何行程", "unparsed": result.Unparsed})
+			return
+		}
+		if err := saveTripPlan(ctx, req.TripID, result.Days); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"days":     result.Days,
+		"unparsed": result.Unparsed,
+		"applied":  req.Apply,
+	})
+}
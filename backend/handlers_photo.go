@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 由照片辨識地點 ==========
+//
+// 上傳社群媒體截圖，以 inline 圖片交給 vision 任務的模型辨識地點，
+// 再用 geocoder 查座標，回傳可以直接加入行程的 Item (不會寫入行程)。
+//
+//	PHOTO_MAX_BYTES=8388608   上傳圖片大小上限
+
+const defaultPhotoMaxBytes = 8 << 20
+
+// 接受的圖片格式 (以內容判斷，不信任前端的 Content-Type)
+var photoMIMETypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// 各類地點的預設停留時間 (分鐘)
+var photoPlaceDurations = map[string]int{
+	"attraction": 90,
+	"restaurant": 90,
+	"cafe":       60,
+	"shop":       60,
+	"hotel":      30,
+	"nature":     120,
+}
+
+// photoPlace 模型依 photoPlaceSchema 回傳的辨識結果
+type photoPlace struct {
+	Identified  bool    `json:"identified"`
+	Name        string  `json:"name"`
+	City        string  `json:"city"`
+	Country     string  `json:"country"`
+	Type        string  `json:"type"`
+	Address     string  `json:"address"`
+	Description string  `json:"description"`
+	Confidence  float64 `json:"confidence"`
+}
+
+func photoPlaceSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"identified":  {Type: "boolean", Description: "圖片中是否有可辨識的地點"},
+			"name":        {Type: "string"},
+			"city":        {Type: "string"},
+			"country":     {Type: "string"},
+			"type":        {Type: "string", Enum: []string{"attraction", "restaurant", "cafe", "shop", "hotel", "nature", "other"}},
+			"address":     {Type: "string"},
+			"description": {Type: "string"},
+			"confidence":  {Type: "number", Description: "0 到 1"},
+		},
+		Required: []string{"identified", "name", "city", "country", "type", "confidence"},
+	}
+}
+
+// photoUploadError 上傳檢查失敗，Status 為要回傳的 HTTP 狀態
+type photoUploadError struct {
+	Status int
+	Msg    string
+}
+
+func (e *photoUploadError) Error() string { return e.Msg }
+
+// readPhotoUpload 讀取 multipart 的 image 欄位並檢查大小與格式
+func readPhotoUpload(c *gin.Context) (LLMImage, error) {
+	maxBytes := int64(envInt("PHOTO_MAX_BYTES", defaultPhotoMaxBytes))
+	tooLarge := &photoUploadError{413, fmt.Sprintf("圖片不可超過 %d MB", maxBytes>>20)}
+
+	// 整個 body 也設上限，避免先把超大的檔案讀進暫存
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20)
+
+	fh, err := c.FormFile("image")
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return LLMImage{}, tooLarge
+		}
+		return LLMImage{}, &photoUploadError{400, "缺少 image 欄位: " + err.Error()}
+	}
+	if fh.Size > maxBytes {
+		return LLMImage{}, tooLarge
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return LLMImage{}, &photoUploadError{400, err.Error()}
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return LLMImage{}, &photoUploadError{400, err.Error()}
+	}
+	if int64(len(data)) > maxBytes {
+		return LLMImage{}, tooLarge
+	}
+	if len(data) == 0 {
+		return LLMImage{}, &photoUploadError{400, "圖片是空的"}
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !photoMIMETypes[mimeType] {
+		return LLMImage{}, &photoUploadError{415, fmt.Sprintf("不支援的圖片格式 %s，請上傳 JPEG、PNG 或 WebP", mimeType)}
+	}
+	return LLMImage{MIMEType: mimeType, Data: data}, nil
+}
+
+// errModelJSON 模型的結構化輸出無法解析
+var errModelJSON = errors.New("模型輸出不是合法的 JSON")
+
+// identifyPhotoPlace 將圖片交給模型辨識地點
+func identifyPhotoPlace(ctx context.Context, llm LLMProvider, locale string, vars PhotoPlacePromptVars, img LLMImage) (photoPlace, PromptRef, error) {
+	var place photoPlace
+
+	prompt, ref, err := prompts.Render("photo_place", locale, vars)
+	if err != nil {
+		return place, ref, err
+	}
+	res, err := llm.Structured(ctx, LLMRequest{
+		Prompt:      prompt,
+		Images:      []LLMImage{img},
+		Temperature: float32Ptr(0.2),
+		MaxTokens:   1024,
+	}, photoPlaceSchema())
+	if err != nil {
+		return place, ref, err
+	}
+	if err := json.Unmarshal([]byte(res.Text), &place); err != nil {
+		return place, ref, fmt.Errorf("%w: %v", errModelJSON, err)
+	}
+	return place, ref, nil
+}
+
+// geocodePlace 依名稱 + 城市查座標，查不到時改用地址
+func geocodePlace(ctx context.Context, p photoPlace) (*GeoResult, error) {
+	var parts []string
+	for _, s := range []string{p.Name, p.City, p.Country} {
+		if s = strings.TrimSpace(s); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	queries := []string{strings.Join(parts, ", ")}
+	if p.Address != "" {
+		queries = append(queries, p.Address)
+	}
+
+	for _, q := range queries {
+		results, err := geocoder.Search(ctx, q, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) > 0 {
+			return &results[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// photoPlaceItem 將辨識與地理編碼結果轉成 Item
+func photoPlaceItem(p photoPlace, geo *GeoResult, clock string) Item {
+	duration, ok := photoPlaceDurations[p.Type]
+	if !ok {
+		duration = 60
+	}
+	it := Item{
+		Time:        clock,
+		DurationMin: duration,
+		Title:       p.Name,
+		Address:     p.Address,
+		Note:        p.Description,
+	}
+	if geo != nil {
+		it.Lat, it.Lng = geo.Lat, geo.Lng
+		if it.Address == "" {
+			it.Address = geo.DisplayName
+		}
+		it.Link = fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%f,%f", geo.Lat, geo.Lng)
+	}
+	return it
+}
+
+// itemFromPhoto 辨識照片中的地點並回傳可加入該天的 Item：POST /api/trips/:id/days/:day_index/items/from-photo
+// multipart 欄位：image (必填)、time (HH:MM，可選)、hint (補充說明，可選)
+func itemFromPhoto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	dayIndex, err := strconv.Atoi(c.Param("day_index"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid day_index"})
+		return
+	}
+
+	img, err := readPhotoUpload(c)
+	if err != nil {
+		var ue *photoUploadError
+		if errors.As(err, &ue) {
+			c.JSON(ue.Status, gin.H{"error": ue.Msg})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	clock := c.PostForm("time")
+	if clock != "" && !reClock.MatchString(clock) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("time %q 不是 HH:MM", clock)})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	plan := trip.Plan
+	if len(plan) == 0 {
+		plan = expandDays(trip.StartDate, trip.Days)
+	}
+	if _, err := planDay(plan, dayIndex); err != nil {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+
+	llm, err := llmFor(taskVision)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
+		return
+	}
+
+	vars := PhotoPlacePromptVars{Region: trip.Region, Hint: c.PostForm("hint")}
+	place, ref, err := identifyPhotoPlace(ctx, llm, requestLocale(c), vars, img)
+	if err != nil {
+		if errors.Is(err, errModelJSON) {
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		respondLLMError(c, llm.Name(), err, nil)
+		return
+	}
+	if !place.Identified || strings.TrimSpace(place.Name) == "" {
+		c.JSON(422, gin.H{"error": "圖片中找不到可辨識的地點", "code": "not_identified", "place": place})
+		return
+	}
+
+	var warnings []string
+	geo, err := geocodePlace(ctx, place)
+	switch {
+	case err != nil:
+		warnings = append(warnings, "查詢座標失敗: "+err.Error())
+	case geo == nil:
+		warnings = append(warnings, "查不到這個地點的座標")
+	}
+
+	c.JSON(200, gin.H{
+		"item":      photoPlaceItem(place, geo, clock),
+		"day_index": dayIndex,
+		"place":     place,
+		"geocode":   geo,
+		"warnings":  warnings,
+		"prompts":   []PromptRef{ref},
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 最小的 PNG 檔頭，足以讓 http.DetectContentType 判斷為 image/png
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+
+func photoUploadRequest(t *testing.T, data []byte) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("image", "screenshot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	w.WriteField("time", "15:00")
+	w.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestReadPhotoUpload(t *testing.T) {
+	t.Setenv("PHOTO_MAX_BYTES", "1024")
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.POST("/upload", func(c *gin.Context) {
+		img, err := readPhotoUpload(c)
+		if err != nil {
+			c.JSON(err.(*photoUploadError).Status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"mime": img.MIMEType, "size": len(img.Data)})
+	})
+
+	cases := []struct {
+		name string
+		data []byte
+		code int
+	}{
+		{"png", testPNG, 200},
+		{"text", []byte("hello, this is not an image"), 415},
+		{"too large", append(append([]byte(nil), testPNG...), make([]byte, 2048)...), 413},
+		{"empty", nil, 400},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, photoUploadRequest(t, tc.data))
+		if w.Code != tc.code {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, w.Code, tc.code, w.Body.String())
+		}
+	}
+}
+
+func TestIdentifyPhotoPlaceWithFakeProvider(t *testing.T) {
+	llm := newFakeProvider().On("辨識圖中的地點", `{"identified":true,"name":"清水寺","city":"京都","country":"日本","type":"attraction","description":"京都最知名的寺院","confidence":0.92}`)
+
+	img := LLMImage{MIMEType: "image/png", Data: testPNG}
+	place, ref, err := identifyPhotoPlace(context.Background(), llm, "zh-TW", PhotoPlacePromptVars{Region: "京都"}, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place.Name != "清水寺" || place.City != "京都" || ref.Name != "photo_place" {
+		t.Errorf("place = %+v, ref = %v", place, ref)
+	}
+
+	calls := llm.Calls()
+	if len(calls) != 1 || len(calls[0].Images) != 1 || calls[0].Images[0].MIMEType != "image/png" {
+		t.Fatalf("image was not sent inline: %+v", calls)
+	}
+	if !strings.Contains(calls[0].Prompt, "京都") {
+		t.Errorf("prompt should mention the trip region: %q", calls[0].Prompt)
+	}
+
+	geo, err := geocodePlace(context.Background(), place)
+	if err != nil || geo == nil {
+		t.Fatalf("geocode = %v, %v", geo, err)
+	}
+	it := photoPlaceItem(place, geo, "15:00")
+	if it.Title != "清水寺" || it.Time != "15:00" || it.DurationMin != 90 || it.Lat == 0 || it.Address != "清水寺, 京都, 日本" {
+		t.Errorf("item = %+v", it)
+	}
+
+	// 模型輸出壞掉時回傳 errModelJSON
+	bad := newFakeProvider().On("", "not json")
+	if _, _, err := identifyPhotoPlace(context.Background(), bad, "zh-TW", PhotoPlacePromptVars{}, img); err == nil || !strings.Contains(err.Error(), errModelJSON.Error()) {
+		t.Errorf("err = %v", err)
+	}
+}
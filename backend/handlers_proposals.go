@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ========== AI 修改提案 ==========
+//
+// 與 trip_tools.go 直接修改行程不同，這裡模型只產生 change set，
+// 存成 pending 提案，由使用者全部接受、部分接受或拒絕。
+// 接受時會檢查 Trip.Version，行程在提案之後被改過就回傳 409。
+
+// proposedChanges 模型依 proposalResponseSchema 回傳的 JSON
+type proposedChanges struct {
+	Summary string `json:"summary"`
+	Changes []struct {
+		Op       string `json:"op"`
+		ItemID   string `json:"item_id"`
+		DayIndex int    `json:"day_index"`
+		Item     Item   `json:"item"`
+		Reason   string `json:"reason"`
+	} `json:"changes"`
+}
+
+// proposalResponseSchema change set 的 JSON schema
+func proposalResponseSchema() *JSONSchema {
+	item := &JSONSchema{
+		Type:        "object",
+		Description: "add 時為完整的新 item；modify 時只填要改的欄位；move 時可只填新的 time",
+		Properties: map[string]*JSONSchema{
+			"time":         {Type: "string", Description: "開始時間，24 小時制 HH:MM"},
+			"duration_min": {Type: "integer", Description: "停留分鐘數"},
+			"title":        {Type: "string", Description: "景點或餐廳名稱"},
+			"address":      {Type: "string", Description: "地址"},
+			"note":         {Type: "string", Description: "介紹、交通方式與注意事項"},
+		},
+	}
+	change := &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"op":        {Type: "string", Enum: []string{"add", "remove", "move", "modify"}},
+			"item_id":   {Type: "string", Description: "remove / move / modify 的目標 item id；add 留空"},
+			"day_index": {Type: "integer", Description: "add / move 的目標天數，從 1 開始"},
+			"item":      item,
+			"reason":    {Type: "string", Description: "為什麼這樣改，一句話"},
+		},
+		Required: []string{"op", "reason"},
+	}
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"summary": {Type: "string", Description: "整體修改的簡短說明"},
+			"changes": {Type: "array", Items: change},
+		},
+		Required: []string{"summary", "changes"},
+	}
+}
+
+// buildPlanChanges 依目前的 plan 補上 before / after，並剔除無法套用的修改
+func buildPlanChanges(plan []Day, raw proposedChanges) ([]PlanChange, []string) {
+	var changes []PlanChange
+	var problems []string
+
+	for i, rc := range raw.Changes {
+		ch := PlanChange{
+			ID:       fmt.Sprintf("c%d", len(changes)+1),
+			Op:       rc.Op,
+			ItemID:   rc.ItemID,
+			DayIndex: rc.DayIndex,
+			Reason:   rc.Reason,
+			Status:   "pending",
+		}
+
+		var current *Item
+		if rc.Op != "add" {
+			d, j, ok := findPlanItem(plan, rc.ItemID)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("第 %d 筆修改：找不到 item %q", i+1, rc.ItemID))
+				continue
+			}
+			it := plan[d].Items[j]
+			current = &it
+			ch.Before = current
+			ch.FromDayIndex = plan[d].DayIndex
+		}
+
+		switch rc.Op {
+		case "add":
+			if _, err := planDay(plan, rc.DayIndex); err != nil {
+				problems = append(problems, fmt.Sprintf("第 %d 筆修改：%v", i+1, err))
+				continue
+			}
+			if strings.TrimSpace(rc.Item.Title) == "" {
+				problems = append(problems, fmt.Sprintf("第 %d 筆修改：新增的 item 缺少 title", i+1))
+				continue
+			}
+			after := rc.Item
+			after.ID = ""
+			ch.After = &after
+			ch.ItemID = ""
+		case "remove":
+			ch.DayIndex = ch.FromDayIndex
+		case "move":
+			if _, err := planDay(plan, rc.DayIndex); err != nil {
+				problems = append(problems, fmt.Sprintf("第 %d 筆修改：%v", i+1, err))
+				continue
+			}
+			after := *current
+			if rc.Item.Time != "" {
+				after.Time = rc.Item.Time
+			}
+			ch.After = &after
+		case "modify":
+			after := mergeItem(*current, rc.Item)
+			if after == *current {
+				problems = append(problems, fmt.Sprintf("第 %d 筆修改：item %s 沒有任何變動", i+1, rc.ItemID))
+				continue
+			}
+			ch.After = &after
+			ch.DayIndex = ch.FromDayIndex
+		default:
+			problems = append(problems, fmt.Sprintf("第 %d 筆修改：未知的 op %q", i+1, rc.Op))
+			continue
+		}
+
+		if ch.After != nil && ch.After.Time != "" && !reClock.MatchString(ch.After.Time) {
+			problems = append(problems, fmt.Sprintf("第 %d 筆修改：time %q 不是 HH:MM", i+1, ch.After.Time))
+			continue
+		}
+		changes = append(changes, ch)
+	}
+	return changes, problems
+}
+
+// proposePlanChanges 依使用者的要求產生修改提案：POST /api/trips/:id/proposals
+func proposePlanChanges(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		Message string `json:"message" binding:"required"`
+		Model   string `json:"model"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	plan := trip.Plan
+	if len(plan) == 0 {
+		plan = expandDays(trip.StartDate, trip.Days)
+	}
+
+	llm, err := llmFor(taskGenerate)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
+		return
+	}
+
+	locale := requestLocale(c)
+	prompt, ref, err := prompts.Render("plan_proposal", locale, PlanProposalPromptVars{
+		Trip:    tripWithoutPhotos(trip),
+		Plan:    planWithoutPhotos(plan),
+		Message: req.Message,
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	system, sref := tourGuideSystem(locale)
+	res, err := llm.Structured(ctx, LLMRequest{
+		Model:       req.Model,
+		System:      system,
+		Prompt:      prompt,
+		Temperature: float32Ptr(0.4),
+		MaxTokens:   8192,
+	}, proposalResponseSchema())
+	if err != nil {
+		respondLLMError(c, llm.Name(), err, nil)
+		return
+	}
+
+	var raw proposedChanges
+	if err := json.Unmarshal([]byte(res.Text), &raw); err != nil {
+		c.JSON(502, gin.H{"error": "模型輸出不是合法的 JSON: " + err.Error()})
+		return
+	}
+
+	changes, problems := buildPlanChanges(plan, raw)
+	if changes == nil {
+		changes = []PlanChange{}
+	}
+
+	proposal := PlanProposal{
+		ID:          primitive.NewObjectID(),
+		TripID:      id,
+		UserID:      requestUserID(c),
+		Message:     req.Message,
+		Summary:     raw.Summary,
+		BaseVersion: trip.Version,
+		Status:      "pending",
+		Changes:     changes,
+		Prompts:     []PromptRef{sref, ref},
+		CreatedAt:   time.Now(),
+	}
+	if _, err := proposalsCollection.InsertOne(ctx, proposal); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"proposal": proposal, "problems": problems})
+}
+
+// listPlanProposals 列出行程的提案，可用 ?status=pending 篩選：GET /api/trips/:id/proposals
+func listPlanProposals(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	filter := bson.M{"trip_id": id, "user_id": requestUserID(c)}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	cursor, err := proposalsCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	proposals := []PlanProposal{}
+	if err := cursor.All(ctx, &proposals); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, proposals)
+}
+
+// loadPlanProposal 依 :pid 讀取目前使用者的提案
+func loadPlanProposal(c *gin.Context) (*PlanProposal, error) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("pid"))
+	if err != nil {
+		return nil, err
+	}
+	var p PlanProposal
+	err = proposalsCollection.FindOne(c.Request.Context(), bson.M{"_id": oid, "user_id": requestUserID(c)}).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// getPlanProposal 讀取單一提案：GET /api/proposals/:pid
+func getPlanProposal(c *gin.Context) {
+	p, err := loadPlanProposal(c)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Proposal not found"})
+		return
+	}
+	c.JSON(200, p)
+}
+
+// acceptPlanProposal 套用提案：POST /api/proposals/:pid/accept
+// body 的 change_ids 為空時接受全部，否則只套用列出的修改，其餘視為拒絕
+func acceptPlanProposal(c *gin.Context) {
+	var req struct {
+		ChangeIDs []string `json:"change_ids"`
+	}
+	// body 可省略
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	p, err := loadPlanProposal(c)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Proposal not found"})
+		return
+	}
+	if p.Status != "pending" {
+		c.JSON(409, gin.H{"error": "提案已處理過", "status": p.Status})
+		return
+	}
+
+	selected := map[string]bool{}
+	for _, cid := range req.ChangeIDs {
+		selected[cid] = true
+	}
+	for cid := range selected {
+		found := false
+		for _, ch := range p.Changes {
+			found = found || ch.ID == cid
+		}
+		if !found {
+			c.JSON(400, gin.H{"error": "未知的 change id: " + cid})
+			return
+		}
+	}
+
+	trip, err := findTripByID(ctx, p.TripID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	if trip.Version != p.BaseVersion {
+		c.JSON(409, gin.H{
+			"error":           "行程在提案之後已被修改，請重新產生提案",
+			"base_version":    p.BaseVersion,
+			"current_version": trip.Version,
+		})
+		return
+	}
+
+	plan := trip.Plan
+	if len(plan) == 0 {
+		plan = expandDays(trip.StartDate, trip.Days)
+	}
+
+	accepted := 0
+	for i := range p.Changes {
+		ch := &p.Changes[i]
+		if len(selected) > 0 && !selected[ch.ID] {
+			ch.Status = "rejected"
+			continue
+		}
+		if err := applyPlanChange(plan, *ch); err != nil {
+			c.JSON(422, gin.H{"error": fmt.Sprintf("無法套用 %s: %v", ch.ID, err)})
+			return
+		}
+		ch.Status = "accepted"
+		accepted++
+	}
+
+	// 沒有套用任何修改時不寫入行程，避免 version 遞增讓其他待處理的提案衝突
+	if accepted == 0 {
+		p.Status = "rejected"
+		if err := resolveProposal(c, p); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"proposal": p,
+			"plan":     trip.Plan,
+			"version":  trip.Version,
+		})
+		return
+	}
+
+	if err := saveTripPlanAt(ctx, p.TripID, plan, p.BaseVersion); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			c.JSON(409, gin.H{"error": "行程在提案之後已被修改，請重新產生提案"})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	p.Status = "accepted"
+	if accepted < len(p.Changes) {
+		p.Status = "partial"
+	}
+	// 行程已寫入，提案狀態寫入失敗只記錄下來
+	if err := resolveProposal(c, p); err != nil {
+		fmt.Println("❌ 提案狀態寫入失敗:", err)
+	}
+
+	c.JSON(200, gin.H{
+		"proposal": p,
+		"plan":     plan,
+		"version":  p.BaseVersion + 1,
+	})
+}
+
+// rejectPlanProposal 拒絕整個提案：POST /api/proposals/:pid/reject
+func rejectPlanProposal(c *gin.Context) {
+	p, err := loadPlanProposal(c)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Proposal not found"})
+		return
+	}
+	if p.Status != "pending" {
+		c.JSON(409, gin.H{"error": "提案已處理過", "status": p.Status})
+		return
+	}
+
+	p.Status = "rejected"
+	for i := range p.Changes {
+		p.Changes[i].Status = "rejected"
+	}
+	if err := resolveProposal(c, p); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, p)
+}
+
+// resolveProposal 寫回提案的處理結果
+func resolveProposal(c *gin.Context, p *PlanProposal) error {
+	now := time.Now()
+	p.ResolvedAt = &now
+	_, err := proposalsCollection.UpdateOne(
+		c.Request.Context(),
+		bson.M{"_id": p.ID},
+		bson.M{"$set": bson.M{"status": p.Status, "changes": p.Changes, "resolved_at": now}},
+	)
+	return err
+}
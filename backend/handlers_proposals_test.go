@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func proposalTestPlan() []Day {
+	return []Day{
+		{DayIndex: 1, Items: []Item{{ID: "d1-1", Time: "09:00", Title: "淺草寺"}, {ID: "d1-2", Time: "14:00", Title: "晴空塔"}}},
+		{DayIndex: 2, Items: []Item{{ID: "d2-1", Time: "10:00", Title: "明治神宮"}}},
+		{DayIndex: 3, Items: []Item{{ID: "d3-1", Time: "09:00", Title: "迪士尼", DurationMin: 600}}},
+	}
+}
+
+func TestBuildPlanChanges(t *testing.T) {
+	var raw proposedChanges
+	err := json.Unmarshal([]byte(`{
+		"summary": "第三天輕鬆一點，加一個拉麵晚餐",
+		"changes": [
+			{"op": "modify", "item_id": "d3-1", "item": {"duration_min": 300}, "reason": "縮短停留"},
+			{"op": "add", "day_index": 3, "item": {"time": "19:00", "title": "一蘭拉麵"}, "reason": "拉麵晚餐"},
+			{"op": "move", "item_id": "d1-2", "day_index": 2, "item": {"time": "15:00"}, "reason": "分散行程"},
+			{"op": "remove", "item_id": "d9-9", "reason": "不存在"},
+			{"op": "modify", "item_id": "d2-1", "item": {}, "reason": "沒改"}
+		]
+	}`), &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, problems := buildPlanChanges(proposalTestPlan(), raw)
+	if len(changes) != 3 || len(problems) != 2 {
+		t.Fatalf("changes=%+v problems=%v", changes, problems)
+	}
+
+	mod := changes[0]
+	if mod.ID != "c1" || mod.Before.DurationMin != 600 || mod.After.DurationMin != 300 || mod.After.Title != "迪士尼" || mod.DayIndex != 3 {
+		t.Errorf("modify = %+v", mod)
+	}
+	move := changes[2]
+	if move.FromDayIndex != 1 || move.DayIndex != 2 || move.Before.Time != "14:00" || move.After.Time != "15:00" {
+		t.Errorf("move = %+v", move)
+	}
+}
+
+func TestApplyPlanChangesPartially(t *testing.T) {
+	plan := proposalTestPlan()
+	changes := []PlanChange{
+		{ID: "c1", Op: "add", DayIndex: 3, After: &Item{Time: "19:00", Title: "一蘭拉麵"}},
+		{ID: "c2", Op: "remove", ItemID: "d1-1"},
+		{ID: "c3", Op: "move", ItemID: "d1-2", DayIndex: 2, After: &Item{Time: "08:00"}},
+		{ID: "c4", Op: "modify", ItemID: "d3-1", After: &Item{Time: "09:00", Title: "迪士尼海洋", DurationMin: 300}},
+	}
+
+	// 只接受 c1、c3、c4
+	for _, ch := range changes {
+		if ch.ID == "c2" {
+			continue
+		}
+		if err := applyPlanChange(plan, ch); err != nil {
+			t.Fatalf("%s: %v", ch.ID, err)
+		}
+	}
+
+	if len(plan[0].Items) != 1 || plan[0].Items[0].ID != "d1-1" {
+		t.Errorf("day 1 = %+v", plan[0].Items)
+	}
+	if d2 := plan[1].Items; len(d2) != 2 || d2[0].ID != "d1-2" || d2[0].Time != "08:00" {
+		t.Errorf("day 2 = %+v", d2)
+	}
+	d3 := plan[2].Items
+	if len(d3) != 2 || d3[0].ID != "d3-1" || d3[0].Title != "迪士尼海洋" || d3[1].ID != "d3-2" || d3[1].Title != "一蘭拉麵" {
+		t.Errorf("day 3 = %+v", d3)
+	}
+
+	if err := applyPlanChange(plan, PlanChange{ID: "c5", Op: "remove", ItemID: "d1-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPlanChange(plan, PlanChange{ID: "c6", Op: "remove", ItemID: "d1-1"}); err == nil {
+		t.Error("expected error removing a missing item")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ========== 重新產生單日 / 時段 ==========
+//
+// 下雨或景點休館時只重排一部分：範圍可以是整天、一段時間 (from / to) 或指定的 item_ids。
+// 範圍外的 item 以「保留不動」交給模型，模型只輸出範圍內的新 items。
+// 結果不會直接寫入行程，而是存成修改提案 (remove 舊的 + add 新的)，
+// 由使用者透過 /proposals/:pid/accept 套用。
+
+// RegenerateConstraints 重新產生時的限制條件
+type RegenerateConstraints struct {
+	IndoorOnly   bool     `json:"indoor_only"`
+	MaxBudgetTWD int      `json:"max_budget_twd"` // 這段行程每人花費上限
+	NearHotel    string   `json:"near_hotel"`     // 住宿名稱或地址
+	MustInclude  []string `json:"must_include"`   // 一定要排進去的地點
+}
+
+// regenerateRequest POST /api/trips/:id/days/:day_index/regenerate 的 body
+type regenerateRequest struct {
+	From        string                `json:"from"` // HH:MM，與 to 一起限定時段 (可選)
+	To          string                `json:"to"`
+	ItemIDs     []string              `json:"item_ids"` // 只重新產生這些 item (可選)
+	Constraints RegenerateConstraints `json:"constraints"`
+	Message     string                `json:"message"` // 其他說明，例如「下雨」
+	Model       string                `json:"model"`
+}
+
+// regenerateScope 這次要重新產生的範圍
+type regenerateScope struct {
+	Targets []Item // 要被取代的 item
+	Fixed   []Item // 保留不動的 item
+	Scoped  bool   // 是否限定時段
+	From    string
+	To      string
+}
+
+// inRange 時段為 [from, to)，沒有時間的 item 不屬於任何時段
+func (s regenerateScope) inRange(t string) bool {
+	return t != "" && t >= s.From && t < s.To
+}
+
+// resolveRegenerateScope 依 item_ids 或時段將當天的 item 分成 targets 與 fixed
+func resolveRegenerateScope(day Day, req regenerateRequest) (regenerateScope, error) {
+	s := regenerateScope{From: req.From, To: req.To}
+
+	if len(req.ItemIDs) > 0 && (req.From != "" || req.To != "") {
+		return s, errors.New("item_ids 與 from / to 只能擇一")
+	}
+
+	if req.From != "" || req.To != "" {
+		if s.From == "" {
+			s.From = "00:00"
+		}
+		if s.To == "" {
+			s.To = "24:00"
+		}
+		for _, t := range []string{req.From, req.To} {
+			if t != "" && !reClock.MatchString(t) {
+				return s, fmt.Errorf("時間 %q 不是 HH:MM", t)
+			}
+		}
+		if s.From >= s.To {
+			return s, errors.New("from 必須早於 to")
+		}
+		s.Scoped = true
+	}
+
+	byID := len(req.ItemIDs) > 0
+	want := map[string]bool{}
+	for _, id := range req.ItemIDs {
+		want[id] = true
+	}
+
+	for _, it := range day.Items {
+		switch {
+		case byID:
+			if want[it.ID] {
+				s.Targets = append(s.Targets, it)
+				delete(want, it.ID)
+				continue
+			}
+		case s.Scoped:
+			if s.inRange(it.Time) {
+				s.Targets = append(s.Targets, it)
+				continue
+			}
+		default:
+			s.Targets = append(s.Targets, it)
+			continue
+		}
+		s.Fixed = append(s.Fixed, it)
+	}
+
+	for id := range want {
+		return s, fmt.Errorf("第 %d 天沒有 item %q", day.DayIndex, id)
+	}
+	return s, nil
+}
+
+// regeneratedItems 模型依 regenerateResponseSchema 回傳的 JSON
+type regeneratedItems struct {
+	Summary string `json:"summary"`
+	Items   []Item `json:"items"`
+}
+
+func regenerateResponseSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"summary": {Type: "string", Description: "這次安排的簡短說明"},
+			"items":   {Type: "array", Items: planItemSchema()},
+		},
+		Required: []string{"summary", "items"},
+	}
+}
+
+// clockMinutes HH:MM 轉成分鐘數
+func clockMinutes(t string) int {
+	h, _ := strconv.Atoi(t[:2])
+	m, _ := strconv.Atoi(t[3:])
+	return h*60 + m
+}
+
+// overlapsFixed 新 item 的開始時間落在保留 item 的停留時間內
+func overlapsFixed(it Item, fixed []Item) (Item, bool) {
+	start := clockMinutes(it.Time)
+	for _, f := range fixed {
+		if !reClock.MatchString(f.Time) {
+			continue
+		}
+		fs := clockMinutes(f.Time)
+		if start >= fs && start < fs+max(f.DurationMin, 1) {
+			return f, true
+		}
+	}
+	return Item{}, false
+}
+
+// buildRegenerateChanges 將模型輸出轉成 remove (targets) + add (新 items) 的修改，並檢查限制條件
+func buildRegenerateChanges(dayIndex int, scope regenerateScope, raw regeneratedItems, cons RegenerateConstraints) ([]PlanChange, []string) {
+	var changes []PlanChange
+	var problems []string
+
+	for _, it := range scope.Targets {
+		before := it
+		changes = append(changes, PlanChange{
+			ID:           fmt.Sprintf("c%d", len(changes)+1),
+			Op:           "remove",
+			ItemID:       it.ID,
+			FromDayIndex: dayIndex,
+			DayIndex:     dayIndex,
+			Before:       &before,
+			Reason:       "重新產生",
+			Status:       "pending",
+		})
+	}
+
+	var added []Item
+	for i, it := range raw.Items {
+		it.ID = ""
+		switch {
+		case strings.TrimSpace(it.Title) == "":
+			problems = append(problems, fmt.Sprintf("第 %d 個新項目缺少 title", i+1))
+			continue
+		case !reClock.MatchString(it.Time):
+			problems = append(problems, fmt.Sprintf("%s：time %q 不是 HH:MM", it.Title, it.Time))
+			continue
+		case scope.Scoped && !scope.inRange(it.Time):
+			problems = append(problems, fmt.Sprintf("%s：%s 不在 %s-%s 之間", it.Title, it.Time, scope.From, scope.To))
+			continue
+		}
+		if f, ok := overlapsFixed(it, scope.Fixed); ok {
+			problems = append(problems, fmt.Sprintf("%s：%s 與保留的 %s 時間重疊", it.Title, it.Time, f.Title))
+			continue
+		}
+
+		after := it
+		added = append(added, it)
+		changes = append(changes, PlanChange{
+			ID:       fmt.Sprintf("c%d", len(changes)+1),
+			Op:       "add",
+			DayIndex: dayIndex,
+			After:    &after,
+			Reason:   raw.Summary,
+			Status:   "pending",
+		})
+	}
+
+	// 模型可能漏掉必須包含的地點，只提醒不擋
+	for _, must := range cons.MustInclude {
+		found := false
+		for _, it := range append(added, scope.Fixed...) {
+			if strings.Contains(strings.ToLower(it.Title+" "+it.Address), strings.ToLower(must)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("新的行程沒有包含「%s」", must))
+		}
+	}
+	return changes, problems
+}
+
+// regenerateDay 重新產生單日或時段，結果存成修改提案：POST /api/trips/:id/days/:day_index/regenerate
+func regenerateDay(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	dayIndex, err := strconv.Atoi(c.Param("day_index"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid day_index"})
+		return
+	}
+
+	var req regenerateRequest
+	// body 可省略，代表整天重新產生
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	plan := trip.Plan
+	if len(plan) == 0 {
+		plan = expandDays(trip.StartDate, trip.Days)
+	}
+	day, err := planDay(plan, dayIndex)
+	if err != nil {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope, err := resolveRegenerateScope(*day, req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, ref, err := prompts.Render("day_regenerate", requestLocale(c), DayRegeneratePromptVars{
+		Trip:        trip,
+		Day:         *day,
+		Fixed:       scope.Fixed,
+		Targets:     scope.Targets,
+		Scoped:      scope.Scoped,
+		From:        scope.From,
+		To:          scope.To,
+		Constraints: req.Constraints,
+		Message:     req.Message,
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	llm, err := llmFor(taskGenerate)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
+		return
+	}
+
+	system, sref := tourGuideSystem(requestLocale(c))
+	res, err := llm.Structured(ctx, LLMRequest{
+		Model:       req.Model,
+		System:      system,
+		Prompt:      prompt,
+		Temperature: float32Ptr(0.7),
+		MaxTokens:   8192,
+	}, regenerateResponseSchema())
+	if err != nil {
+		respondLLMError(c, llm.Name(), err, nil)
+		return
+	}
+
+	var raw regeneratedItems
+	if err := json.Unmarshal([]byte(res.Text), &raw); err != nil {
+		c.JSON(502, gin.H{"error": "模型輸出不是合法的 JSON: " + err.Error()})
+		return
+	}
+
+	changes, problems := buildRegenerateChanges(dayIndex, scope, raw, req.Constraints)
+	if changes == nil {
+		changes = []PlanChange{}
+	}
+
+	// 預覽全部接受後的這一天
+	preview := clonePlan(plan)
+	for _, ch := range changes {
+		if err := applyPlanChange(preview, ch); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	previewDay, _ := planDay(preview, dayIndex)
+
+	message := fmt.Sprintf("重新產生第 %d 天", dayIndex)
+	switch {
+	case scope.Scoped:
+		message += fmt.Sprintf(" %s-%s", scope.From, scope.To)
+	case len(req.ItemIDs) > 0:
+		message += " " + strings.Join(req.ItemIDs, "、")
+	}
+	if req.Message != "" {
+		message += "：" + req.Message
+	}
+
+	proposal := PlanProposal{
+		ID:          primitive.NewObjectID(),
+		TripID:      id,
+		UserID:      requestUserID(c),
+		Message:     message,
+		Summary:     raw.Summary,
+		BaseVersion: trip.Version,
+		Status:      "pending",
+		Changes:     changes,
+		Prompts:     []PromptRef{sref, ref},
+		CreatedAt:   time.Now(),
+	}
+	if _, err := proposalsCollection.InsertOne(ctx, proposal); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"proposal": proposal, "preview": previewDay, "problems": problems})
+}
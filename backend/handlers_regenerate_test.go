@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func regenerateTestDay() Day {
+	return Day{DayIndex: 2, Date: "2025-04-02", Items: []Item{
+		{ID: "d2-1", Time: "09:00", DurationMin: 90, Title: "伏見稻荷"},
+		{ID: "d2-2", Time: "11:00", DurationMin: 120, Title: "嵐山竹林"},
+		{ID: "d2-3", Time: "14:00", DurationMin: 60, Title: "金閣寺"},
+		{ID: "d2-4", Time: "18:00", DurationMin: 90, Title: "先斗町晚餐"},
+	}}
+}
+
+func itemTitles(items []Item) string {
+	var out []string
+	for _, it := range items {
+		out = append(out, it.Title)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestResolveRegenerateScope(t *testing.T) {
+	day := regenerateTestDay()
+
+	s, err := resolveRegenerateScope(day, regenerateRequest{From: "10:00", To: "15:00"})
+	if err != nil || !s.Scoped || itemTitles(s.Targets) != "嵐山竹林,金閣寺" || itemTitles(s.Fixed) != "伏見稻荷,先斗町晚餐" {
+		t.Errorf("time range: targets = %s, fixed = %s, err = %v", itemTitles(s.Targets), itemTitles(s.Fixed), err)
+	}
+
+	s, err = resolveRegenerateScope(day, regenerateRequest{ItemIDs: []string{"d2-3"}})
+	if err != nil || s.Scoped || itemTitles(s.Targets) != "金閣寺" || len(s.Fixed) != 3 {
+		t.Errorf("item ids: targets = %s, err = %v", itemTitles(s.Targets), err)
+	}
+
+	s, err = resolveRegenerateScope(day, regenerateRequest{})
+	if err != nil || len(s.Targets) != 4 || len(s.Fixed) != 0 {
+		t.Errorf("whole day: targets = %s, err = %v", itemTitles(s.Targets), err)
+	}
+
+	bad := []regenerateRequest{
+		{ItemIDs: []string{"d9-1"}},
+		{From: "15:00", To: "10:00"},
+		{From: "9am"},
+		{From: "10:00", ItemIDs: []string{"d2-1"}},
+	}
+	for _, req := range bad {
+		if _, err := resolveRegenerateScope(day, req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+}
+
+func TestBuildRegenerateChanges(t *testing.T) {
+	day := regenerateTestDay()
+	scope, _ := resolveRegenerateScope(day, regenerateRequest{From: "10:00", To: "17:00"})
+
+	raw := regeneratedItems{Summary: "下雨改室內", Items: []Item{
+		{Time: "10:30", DurationMin: 120, Title: "京都國立博物館"},
+		{Time: "09:30", Title: "太早"}, // 與保留的伏見稻荷重疊、也不在時段內
+		{Time: "13:30", DurationMin: 90, Title: "錦市場"},
+		{Time: "下午", Title: "時間格式錯誤"},
+	}}
+	cons := RegenerateConstraints{IndoorOnly: true, MustInclude: []string{"錦市場", "京都水族館"}}
+
+	changes, problems := buildRegenerateChanges(2, scope, raw, cons)
+
+	var ops []string
+	for _, ch := range changes {
+		ops = append(ops, ch.Op+":"+ch.ItemID)
+	}
+	if got := strings.Join(ops, ","); got != "remove:d2-2,remove:d2-3,add:,add:" {
+		t.Errorf("changes = %s", got)
+	}
+	if len(problems) != 3 || !strings.Contains(strings.Join(problems, "\n"), "京都水族館") {
+		t.Errorf("problems = %q", problems)
+	}
+
+	// 全部套用後，時段外的 item 不變
+	plan := clonePlan([]Day{day})
+	for _, ch := range changes {
+		if err := applyPlanChange(plan, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := itemTitles(plan[0].Items); got != "伏見稻荷,京都國立博物館,錦市場,先斗町晚餐" {
+		t.Errorf("preview = %s", got)
+	}
+	if itemTitles(day.Items) != "伏見稻荷,嵐山竹林,金閣寺,先斗町晚餐" {
+		t.Errorf("original day modified: %s", itemTitles(day.Items))
+	}
+}
+
+func TestDayRegeneratePrompt(t *testing.T) {
+	day := regenerateTestDay()
+	scope, _ := resolveRegenerateScope(day, regenerateRequest{From: "10:00", To: "17:00"})
+
+	text, _, err := prompts.Render("day_regenerate", "zh-TW", DayRegeneratePromptVars{
+		Trip:        Trip{Region: "京都", Days: 3, People: 2},
+		Day:         day,
+		Fixed:       scope.Fixed,
+		Targets:     scope.Targets,
+		Scoped:      true,
+		From:        scope.From,
+		To:          scope.To,
+		Constraints: RegenerateConstraints{IndoorOnly: true, MustInclude: []string{"錦市場"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"09:00 伏見稻荷", "只安排 10:00 到 17:00", "室內", "必須包含：錦市場"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("prompt missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "住宿") {
+		t.Errorf("unset constraint rendered:\n%s", text)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ========== 伺服器端對話 session ==========
+//
+// 對話紀錄存在 chat_sessions collection，依 trip_id + user_id 區分，
+// 換裝置或清除瀏覽器資料都不會遺失。前端在 /gemini/chat 帶 session_id
+// 時，歷史由伺服器載入，不再需要傳 history。
+
+// 前端第一則規劃指令的前綴，這類訊息存成 session 的 system_prompt 而不是使用者訊息
+const systemPromptPrefix = "SYSTEM_prompt:"
+
+// modelHistory 組出送給模型的歷史：system_prompt 放在最前面當作第一則使用者訊息；
+// 有摘要時以摘要取代 system_prompt 與前 SummaryUpTo 則訊息
+func (s ChatSession) modelHistory() []ChatPart {
+	var history []ChatPart
+	messages := s.Messages
+	if s.Summary != "" {
+		history = summaryTurns(s.Summary)
+		messages = messages[min(s.SummaryUpTo, len(messages)):]
+	} else if s.SystemPrompt != "" {
+		history = append(history, ChatPart{Role: "user", Text: s.SystemPrompt})
+	}
+	for _, m := range messages {
+		history = append(history, ChatPart{Role: m.Role, Text: m.Text})
+	}
+	return history
+}
+
+// historyPrefix modelHistory 開頭不屬於 Messages 的訊息數
+func (s ChatSession) historyPrefix() int {
+	switch {
+	case s.Summary != "":
+		return 2
+	case s.SystemPrompt != "":
+		return 1
+	}
+	return 0
+}
+
+// loadChatSession 依 session_id 讀取目前使用者的 session
+func loadChatSession(c *gin.Context, sessionID string) (*ChatSession, error) {
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s, err := findChatSession(c.Request.Context(), oid, requestUserID(c))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// recordChatTurn 將這一輪的使用者訊息與模型回覆一起寫入 session
+// refs 記錄產生這次回覆的 prompt 模板版本
+func recordChatTurn(c *gin.Context, s *ChatSession, message, reply string, refs []PromptRef) error {
+	now := time.Now()
+	var msgs []ChatMessage
+	var set bson.M
+
+	if strings.HasPrefix(message, systemPromptPrefix) {
+		set = bson.M{"system_prompt": message}
+	} else {
+		msgs = append(msgs, ChatMessage{Role: "user", Text: message, CreatedAt: now})
+	}
+	msgs = append(msgs, ChatMessage{Role: "model", Text: reply, Prompts: refs, CreatedAt: now})
+
+	return appendChatMessages(c.Request.Context(), s.ID, msgs, set)
+}
+
+// createChatSession 建立新的對話：POST /api/trips/:id/chat/sessions
+func createChatSession(c *gin.Context) {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		Title        string `json:"title"`
+		SystemPrompt string `json:"system_prompt"`
+	}
+	// body 可省略
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	trip, err := findTripByID(ctx, tripID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+
+	title := req.Title
+	if title == "" {
+		title = trip.Name
+	}
+
+	now := time.Now()
+	session := ChatSession{
+		ID:           primitive.NewObjectID(),
+		TripID:       tripID,
+		UserID:       requestUserID(c),
+		Title:        title,
+		SystemPrompt: req.SystemPrompt,
+		Messages:     []ChatMessage{},
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	if _, err := chatSessionsCollection.InsertOne(ctx, session); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, session)
+}
+
+// listChatSessions 列出目前使用者在此行程的對話 (不含訊息內容)：GET /api/trips/:id/chat/sessions
+func listChatSessions(c *gin.Context) {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	opts := options.Find().
+		SetSort(bson.M{"updated_at": -1}).
+		SetProjection(bson.M{"messages": 0})
+	cursor, err := chatSessionsCollection.Find(ctx, bson.M{"trip_id": tripID, "user_id": requestUserID(c)}, opts)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []ChatSession{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, sessions)
+}
+
+// getChatSession 讀取單一對話 (含完整訊息) 以便接續：GET /api/chat/sessions/:sid
+func getChatSession(c *gin.Context) {
+	session, err := loadChatSession(c, c.Param("sid"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+	c.JSON(200, session)
+}
+
+// deleteChatSession 刪除單一對話：DELETE /api/chat/sessions/:sid
+func deleteChatSession(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("sid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	result, err := chatSessionsCollection.DeleteOne(c.Request.Context(), bson.M{"_id": oid, "user_id": requestUserID(c)})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Session deleted"})
+}
+
+// deleteTripChatSessions 清除目前使用者在此行程的所有對話 (行程重置時使用)：DELETE /api/trips/:id/chat/sessions
+func deleteTripChatSessions(c *gin.Context) {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	result, err := chatSessionsCollection.DeleteMany(c.Request.Context(), bson.M{"trip_id": tripID, "user_id": requestUserID(c)})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"deleted": result.DeletedCount})
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestChatSessionModelHistory(t *testing.T) {
+	s := ChatSession{
+		SystemPrompt: systemPromptPrefix + " 請規劃行程",
+		Messages: []ChatMessage{
+			{Role: "model", Text: "第一天..."},
+			{Role: "user", Text: "第二天改去海邊"},
+		},
+	}
+
+	got := s.modelHistory()
+	if len(got) != 3 {
+		t.Fatalf("len = %d, want 3", len(got))
+	}
+	if got[0].Role != "user" || got[0].Text != s.SystemPrompt {
+		t.Errorf("first turn = %+v, want system prompt as user turn", got[0])
+	}
+	if got[1].Role != "model" || got[2].Text != "第二天改去海邊" {
+		t.Errorf("history = %+v", got)
+	}
+
+	if h := (ChatSession{}).modelHistory(); len(h) != 0 {
+		t.Errorf("empty session history = %+v", h)
+	}
+}
+
+func TestChatSessionModelHistoryWithSummary(t *testing.T) {
+	s := ChatSession{
+		SystemPrompt: systemPromptPrefix + " 請規劃行程",
+		Summary:      "- 目的地：京都",
+		SummaryUpTo:  2,
+		Messages: []ChatMessage{
+			{Role: "model", Text: "第一天..."},
+			{Role: "user", Text: "改去大阪"},
+			{Role: "user", Text: "第三天呢？"},
+		},
+	}
+
+	got := s.modelHistory()
+	if len(got) != 3 || got[0].Text != chatSummaryPrefix+s.Summary || got[2].Text != "第三天呢？" {
+		t.Errorf("history = %+v", got)
+	}
+	if s.historyPrefix() != 2 {
+		t.Errorf("prefix = %d, want 2", s.historyPrefix())
+	}
+}
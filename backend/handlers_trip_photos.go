@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ========== 行程封面與景點照片 ==========
+//
+// 選定的圖片直接存在 Trip.CoverImage 與 Item.Photo (含作者、授權與來源網址)，
+// 之後每次顯示都是同一張，不受搜尋快取淘汰影響；讀取行程時會重新登記到圖片代理，
+// 磁碟上的縮圖被刪掉也能由來源網址重新下載。
+//
+// 挑選流程：先 GET candidates 取得候選圖片，再以候選的 image_id 設定封面或照片。
+// 候選圖片只在伺服器保留一段時間 (imageCandidates)，避免前端送來任意網址。
+
+// photoCandidateLimit 候選圖片預設與最多幾張
+const (
+	photoCandidateLimit    = 6
+	maxPhotoCandidateLimit = 20
+)
+
+// photoFillTimeout 自動補照片時每個 item 的查詢時間上限
+const photoFillTimeout = 15 * time.Second
+
+// 自動補照片每次最多查詢幾個 item (含封面)，避免一次用完來源的速率限制；
+// 沒查到的 item 列在回應的 pending，以 item_ids 帶回再呼叫一次
+const (
+	photoFillLimit    = 10
+	maxPhotoFillLimit = 30
+)
+
+// autoFillRequest POST /photos/auto-fill 的 body (可省略)
+type autoFillRequest struct {
+	Limit   int      `json:"limit"`    // 預設 10，最多 30
+	ItemIDs []string `json:"item_ids"` // 只處理這些 item (不補封面)；省略時為全部
+}
+
+// imageCandidates 最近提供過的候選圖片，依 image_id (imageProxyID) 查詢
+var imageCandidates = newTTLCache[ImageResult]("image_candidates", 2000, 6*time.Hour, 0, nil)
+
+// imageCandidate 候選圖片；image_id 用於設定封面或照片
+type imageCandidate struct {
+	ImageID string `json:"image_id"`
+	ImageResult
+}
+
+// choosePhotoRequest PUT /cover 與 /items/:item_id/photo 的 body
+type choosePhotoRequest struct {
+	ImageID string `json:"image_id" binding:"required"`
+}
+
+// photoFillResult 自動補照片時每個 item 的結果
+type photoFillResult struct {
+	ItemID   string       `json:"item_id"`
+	DayIndex int          `json:"day_index"`
+	Title    string       `json:"title"`
+	Query    string       `json:"query"`
+	Status   string       `json:"status"` // filled / not_found / error
+	Error    string       `json:"error,omitempty"`
+	Photo    *ImageResult `json:"photo,omitempty"`
+}
+
+// placeQuery 以標題加上地區搜尋，避免同名景點找到別的城市；標題已含地區時不重複 (照片與地理編碼共用)
+func placeQuery(title, region string) string {
+	title, region = strings.TrimSpace(title), strings.TrimSpace(region)
+	if region == "" || strings.Contains(title, region) {
+		return title
+	}
+	if title == "" {
+		return region
+	}
+	return title + " " + region
+}
+
+// coverQuery 封面以地區搜尋，沒有地區時用行程名稱
+func coverQuery(trip Trip) string {
+	if q := strings.TrimSpace(trip.Region); q != "" {
+		return q
+	}
+	return strings.TrimSpace(trip.Name)
+}
+
+// registerTripImages 重新登記行程裡的圖片並更新 proxy_url
+func registerTripImages(trip *Trip) {
+	if trip.CoverImage != nil {
+		img := withProxyURL(*trip.CoverImage)
+		trip.CoverImage = &img
+	}
+	for d := range trip.Plan {
+		for i := range trip.Plan[d].Items {
+			if p := trip.Plan[d].Items[i].Photo; p != nil {
+				img := withProxyURL(*p)
+				trip.Plan[d].Items[i].Photo = &img
+			}
+		}
+	}
+}
+
+// fillTripPhotos 為沒有照片的 item (與沒有封面的行程) 各找一張圖片，直接修改 trip。
+// 最多查詢 limit 次 (封面也算一次)，pending 為超過上限、還沒查詢的 item；
+// itemIDs 不為空時只處理這些 item，也不補封面。
+// find 回傳 nil 代表查無結果；coverChanged 表示這次補上了封面
+func fillTripPhotos(ctx context.Context, trip *Trip, limit int, itemIDs []string, find func(ctx context.Context, query string) (*ImageResult, error)) (results []photoFillResult, coverChanged bool, pending []string) {
+	only := map[string]bool{}
+	for _, id := range itemIDs {
+		only[id] = true
+	}
+	lookups := 0
+	lookup := func(q string) (*ImageResult, error) {
+		ctx, cancel := context.WithTimeout(ctx, photoFillTimeout)
+		defer cancel()
+		lookups++
+		img, err := find(ctx, q)
+		if img != nil {
+			stored := withProxyURL(*img)
+			img = &stored
+		}
+		return img, err
+	}
+
+	if trip.CoverImage == nil && len(only) == 0 && limit > 0 {
+		if q := coverQuery(*trip); q != "" {
+			if img, err := lookup(q); err == nil && img != nil {
+				trip.CoverImage = img
+				coverChanged = true
+			}
+		}
+	}
+
+	for d := range trip.Plan {
+		for i := range trip.Plan[d].Items {
+			it := &trip.Plan[d].Items[i]
+			if it.Photo != nil || strings.TrimSpace(it.Title) == "" || (len(only) > 0 && !only[it.ID]) {
+				continue
+			}
+			if lookups >= limit {
+				pending = append(pending, it.ID)
+				continue
+			}
+			r := photoFillResult{ItemID: it.ID, DayIndex: trip.Plan[d].DayIndex, Title: it.Title, Query: placeQuery(it.Title, trip.Region)}
+			img, err := lookup(r.Query)
+			switch {
+			case err != nil:
+				r.Status, r.Error = "error", err.Error()
+			case img == nil:
+				r.Status = "not_found"
+			default:
+				r.Status, r.Photo = "filled", img
+				it.Photo = img
+			}
+			results = append(results, r)
+		}
+	}
+	return results, coverChanged, pending
+}
+
+// findCachedImage autoFillTripPhotos 使用的查詢，與 /api/images/search 共用快取
+func findCachedImage(ctx context.Context, query string) (*ImageResult, error) {
+	img, found, err := lookupImage(ctx, query)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// tripPhotoCandidates 候選圖片：GET /api/trips/:id/photos/candidates?item_id=d1-1&query=&limit=6
+// 沒有 item_id 時為封面的候選；query 可覆寫預設的搜尋字詞
+func tripPhotoCandidates(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	limit := photoCandidateLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPhotoCandidateLimit {
+			c.JSON(400, gin.H{"error": "limit 必須介於 1 到 " + strconv.Itoa(maxPhotoCandidateLimit)})
+			return
+		}
+		limit = n
+	}
+
+	ctx := c.Request.Context()
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	query := coverQuery(trip)
+	if itemID := c.Query("item_id"); itemID != "" {
+		d, i, ok := findPlanItem(trip.Plan, itemID)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Item not found"})
+			return
+		}
+		query = placeQuery(trip.Plan[d].Items[i].Title, trip.Region)
+	}
+	if q := strings.TrimSpace(c.Query("query")); q != "" {
+		query = q
+	}
+	if query == "" {
+		c.JSON(400, gin.H{"error": "missing query"})
+		return
+	}
+
+	providers := imageProviders
+	if len(providers) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errNoImageProvider.Error()})
+		return
+	}
+	list, err := searchImageCandidates(ctx, providers, query, limit)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	candidates := make([]imageCandidate, len(list))
+	for i, img := range list {
+		imageID := imageProxyID(img.URL)
+		imageCandidates.Set(ctx, imageID, img, true)
+		candidates[i] = imageCandidate{ImageID: imageID, ImageResult: withProxyURL(img)}
+	}
+	c.JSON(200, gin.H{"query": query, "candidates": candidates})
+}
+
+// chosenCandidate 讀取 body 的 image_id 並找出對應的候選圖片，失敗時已寫入回應
+func chosenCandidate(c *gin.Context) (*ImageResult, bool) {
+	var req choosePhotoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	img, found, ok := imageCandidates.Get(req.ImageID)
+	if !ok || !found {
+		c.JSON(404, gin.H{"error": "找不到候選圖片，請重新搜尋"})
+		return nil, false
+	}
+	// 使用者選定了這張圖片，通知來源 (Unsplash download tracking，每張只通知一次)
+	trackImageUse(imageProviders, img)
+	img = withProxyURL(img)
+	return &img, true
+}
+
+// setTripCover 設定封面：PUT /api/trips/:id/cover {"image_id": "..."}
+func setTripCover(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	img, ok := chosenCandidate(c)
+	if !ok {
+		return
+	}
+	respondCoverSaved(c, saveTripCoverImage(c.Request.Context(), id, img), img)
+}
+
+// clearTripCover 移除封面：DELETE /api/trips/:id/cover
+func clearTripCover(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	respondCoverSaved(c, saveTripCoverImage(c.Request.Context(), id, nil), nil)
+}
+
+func respondCoverSaved(c *gin.Context, err error, img *ImageResult) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"cover_image": img})
+}
+
+// setItemPhoto 設定 item 的照片：PUT /api/trips/:id/items/:item_id/photo {"image_id": "..."}
+func setItemPhoto(c *gin.Context) {
+	img, ok := chosenCandidate(c)
+	if !ok {
+		return
+	}
+	saveItemPhoto(c, img)
+}
+
+// clearItemPhoto 移除 item 的照片：DELETE /api/trips/:id/items/:item_id/photo
+func clearItemPhoto(c *gin.Context) {
+	saveItemPhoto(c, nil)
+}
+
+func saveItemPhoto(c *gin.Context, img *ImageResult) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	trip, err := findTripByID(ctx, id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Trip not found"})
+		return
+	}
+	d, i, ok := findPlanItem(trip.Plan, c.Param("item_id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Item not found"})
+		return
+	}
+	trip.Plan[d].Items[i].Photo = img
+	if err := saveTripPlanAt(ctx, id, trip.Plan, trip.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			c.JSON(409, gin.H{"error": "行程已被修改，請重新整理後再試"})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"item": trip.Plan[d].Items[i], "version": trip.Version + 1})
+}
+
+// autoFillTripPhotos 為沒有照片的 item 與沒有封面的行程自動挑圖：POST /api/trips/:id/photos/auto-fill
+// 查詢與 /api/images/search 共用快取；寫入時行程已被修改則重新讀取後再補一次
+func autoFillTripPhotos(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid ID"})
+		return
+	}
+	if len(imageProviders) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errNoImageProvider.Error()})
+		return
+	}
+	var req autoFillRequest
+	// body 可省略，全部使用預設值
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = photoFillLimit
+	}
+	limit = min(limit, maxPhotoFillLimit)
+
+	ctx := c.Request.Context()
+	for attempt := 0; attempt < 3; attempt++ {
+		trip, err := findTripByID(ctx, id)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Trip not found"})
+			return
+		}
+		results, coverChanged, pending := fillTripPhotos(ctx, &trip, limit, req.ItemIDs, findCachedImage)
+		if pending == nil {
+			pending = []string{}
+		}
+		filled := 0
+		for _, r := range results {
+			if r.Status == "filled" {
+				filled++
+			}
+		}
+		resp := gin.H{"results": results, "filled": filled, "pending": pending, "cover_image": trip.CoverImage, "version": trip.Version}
+		if filled == 0 && !coverChanged {
+			c.JSON(200, resp)
+			return
+		}
+		var cover *ImageResult
+		if coverChanged {
+			cover = trip.CoverImage
+		}
+		err = saveTripPhotosAt(ctx, id, trip.Plan, cover, trip.Version)
+		if errors.Is(err, errVersionConflict) {
+			continue
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		// 自動補上的照片和手動選定的一樣要通知來源
+		if cover != nil {
+			trackImageUse(imageProviders, *cover)
+		}
+		for _, r := range results {
+			if r.Photo != nil {
+				trackImageUse(imageProviders, *r.Photo)
+			}
+		}
+		resp["version"] = trip.Version + 1
+		c.JSON(200, resp)
+		return
+	}
+	c.JSON(409, gin.H{"error": "行程已被修改，請重新整理後再試"})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPlaceQuery(t *testing.T) {
+	cases := []struct{ title, region, want string }{
+		{"清水寺", "京都", "清水寺 京都"},
+		{"京都塔", "京都", "京都塔"},
+		{" 伏見稻荷 ", "", "伏見稻荷"},
+		{"", "大阪", "大阪"},
+	}
+	for _, c := range cases {
+		if got := placeQuery(c.title, c.region); got != c.want {
+			t.Errorf("placeQuery(%q, %q) = %q, want %q", c.title, c.region, got, c.want)
+		}
+	}
+}
+
+func TestFillTripPhotos(t *testing.T) {
+	kept := &ImageResult{Provider: "pexels", URL: "https://example.com/kept.jpg"}
+	trip := Trip{Region: "京都", Plan: []Day{
+		{DayIndex: 1, Items: []Item{
+			{ID: "d1-1", Title: "清水寺"},
+			{ID: "d1-2", Title: "金閣寺", Photo: kept},
+			{ID: "d1-3", Title: " "},
+		}},
+		{DayIndex: 2, Items: []Item{
+			{ID: "d2-1", Title: "none"},
+			{ID: "d2-2", Title: "fail"},
+		}},
+	}}
+	var queries []string
+	find := func(ctx context.Context, q string) (*ImageResult, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("lookup without timeout")
+		}
+		queries = append(queries, q)
+		switch {
+		case strings.HasPrefix(q, "none"):
+			return nil, nil
+		case strings.HasPrefix(q, "fail"):
+			return nil, errors.New("rate limited")
+		}
+		return &ImageResult{Provider: "unsplash", URL: "https://example.com/" + q + ".jpg"}, nil
+	}
+
+	results, coverChanged, pending := fillTripPhotos(context.Background(), &trip, 10, nil, find)
+	if len(pending) != 0 {
+		t.Errorf("pending = %q", pending)
+	}
+	if !coverChanged || trip.CoverImage == nil || trip.CoverImage.URL != "https://example.com/京都.jpg" {
+		t.Errorf("cover = %+v (changed %v)", trip.CoverImage, coverChanged)
+	}
+	if want := "京都|清水寺 京都|none 京都|fail 京都"; strings.Join(queries, "|") != want {
+		t.Errorf("queries = %q", queries)
+	}
+	if len(results) != 3 || results[0].Status != "filled" || results[1].Status != "not_found" || results[2].Status != "error" || results[2].DayIndex != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	if p := trip.Plan[0].Items[0].Photo; p == nil || p.URL != "https://example.com/清水寺 京都.jpg" {
+		t.Errorf("d1-1 photo = %+v", p)
+	}
+	if trip.Plan[0].Items[1].Photo != kept || trip.Plan[1].Items[0].Photo != nil {
+		t.Error("existing photos must be kept and misses left empty")
+	}
+
+	// 都有照片時不再查詢
+	queries = nil
+	trip.Plan = trip.Plan[:1]
+	trip.Plan[0].Items = trip.Plan[0].Items[:2]
+	if results, changed, _ := fillTripPhotos(context.Background(), &trip, 10, nil, find); len(results) != 0 || changed || len(queries) != 0 {
+		t.Errorf("second run: %+v %v %q", results, changed, queries)
+	}
+}
+
+func TestFillTripPhotosLimit(t *testing.T) {
+	trip := Trip{Region: "京都", Plan: []Day{
+		{DayIndex: 1, Items: []Item{{ID: "d1-1", Title: "none"}, {ID: "d1-2", Title: "清水寺"}}},
+		{DayIndex: 2, Items: []Item{{ID: "d2-1", Title: "金閣寺"}, {ID: "d2-2", Title: "銀閣寺"}}},
+	}}
+	var queries []string
+	find := func(ctx context.Context, q string) (*ImageResult, error) {
+		queries = append(queries, q)
+		if strings.HasPrefix(q, "none") {
+			return nil, nil
+		}
+		return &ImageResult{URL: "https://example.com/" + q + ".jpg"}, nil
+	}
+
+	// 封面也算一次查詢
+	results, _, pending := fillTripPhotos(context.Background(), &trip, 2, nil, find)
+	if len(results) != 1 || results[0].Status != "not_found" || strings.Join(pending, ",") != "d1-2,d2-1,d2-2" {
+		t.Fatalf("results = %+v, pending = %q", results, pending)
+	}
+
+	// 帶回 pending 繼續，查不到的 item 不會一直佔用上限
+	queries = nil
+	results, changed, pending := fillTripPhotos(context.Background(), &trip, 2, pending, find)
+	if changed || len(results) != 2 || strings.Join(pending, ",") != "d2-2" || strings.Join(queries, "|") != "清水寺 京都|金閣寺 京都" {
+		t.Errorf("results = %+v, pending = %q, queries = %q", results, pending, queries)
+	}
+}
+
+func TestSearchImageCandidates(t *testing.T) {
+	srv, _ := imageStubServer(t)
+	ctx := context.Background()
+	unsplash := newUnsplashProvider(srv.URL, "test-key")
+	wikimedia := newWikimediaProvider(srv.URL, "trip-planner-test")
+
+	list, err := searchImageCandidates(ctx, []ImageProvider{unsplash, unsplash, wikimedia}, "東京鐵塔", 3)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if list[0].Provider != "unsplash" || list[1].URL != "https://upload.wikimedia.org/thumb/a.jpg" || list[2].URL != "https://upload.wikimedia.org/thumb/b.jpg" {
+		t.Errorf("list = %+v", list)
+	}
+
+	failing := stubImageProvider{name: "a", err: context.DeadlineExceeded}
+	if list, err := searchImageCandidates(ctx, []ImageProvider{failing, wikimedia}, "東京鐵塔", 1); err != nil || len(list) != 1 {
+		t.Errorf("fallback = %+v, %v", list, err)
+	}
+	if _, err := searchImageCandidates(ctx, []ImageProvider{failing, wikimedia}, "none", 3); err == nil {
+		t.Error("expected error when nothing found and a provider failed")
+	}
+}
+
+func TestChosenCandidate(t *testing.T) {
+	old := imageCandidates
+	imageCandidates = newTTLCache[ImageResult]("image_candidates", 10, time.Hour, 0, nil)
+	defer func() { imageCandidates = old }()
+	img := ImageResult{Provider: "wikimedia", URL: "https://upload.wikimedia.org/a.jpg", Author: "Kim"}
+	imageCandidates.Set(context.Background(), imageProxyID(img.URL), img, true)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/choose", func(c *gin.Context) {
+		if img, ok := chosenCandidate(c); ok {
+			c.JSON(200, img)
+		}
+	})
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("PUT", "/choose", strings.NewReader(body)))
+		return w
+	}
+
+	if w := put(`{"image_id":"` + imageProxyID(img.URL) + `"}`); w.Code != 200 || !strings.Contains(w.Body.String(), `"author":"Kim"`) {
+		t.Errorf("known = %d %s", w.Code, w.Body)
+	}
+	// 不能指定沒有提供過的圖片
+	if w := put(`{"image_id":"` + imageProxyID("https://evil.example/x.jpg") + `"}`); w.Code != 404 {
+		t.Errorf("unknown = %d", w.Code)
+	}
+	if w := put(`{}`); w.Code != 400 {
+		t.Errorf("missing = %d", w.Code)
+	}
+}
+
+func TestTripWithoutPhotos(t *testing.T) {
+	photo := &ImageResult{URL: "https://example.com/a.jpg"}
+	trip := Trip{CoverImage: photo, Plan: []Day{{DayIndex: 1, Items: []Item{{ID: "d1-1", Title: "清水寺", Photo: photo}}}}}
+	stripped := tripWithoutPhotos(trip)
+	if stripped.CoverImage != nil || stripped.Plan[0].Items[0].Photo != nil || stripped.Plan[0].Items[0].Title != "清水寺" {
+		t.Errorf("stripped = %+v", stripped)
+	}
+	if trip.CoverImage == nil || trip.Plan[0].Items[0].Photo == nil {
+		t.Error("original trip modified")
+	}
+}
+
+// proxy_url 只在回應時填入，不存到 Mongo
+func TestImageResultBSONOmitsProxyURL(t *testing.T) {
+	data, err := bson.Marshal(Item{ID: "d1-1", Photo: &ImageResult{URL: "https://example.com/a.jpg", ProxyURL: "/api/images/abc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("proxy_url")) || bytes.Contains(data, []byte("/api/images/abc")) || !bytes.Contains(data, []byte("https://example.com/a.jpg")) {
+		t.Errorf("bson = %q", data)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 由一句話建立行程 ==========
+//
+// 例如「2 人 4/22 去東京 9 天 預算 2 萬 喜歡博物館 自然」，
+// 以結構化輸出抽出 Trip 的欄位，並標示每個欄位的來源：
+//
+//	text      句子裡明確提到
+//	inferred  模型推測 (例如由地區取名、由「下週末」算出日期)
+//	default   模型沒有給或不合法，使用與前端精靈相同的預設值
+//
+// create=true 時直接建立行程，否則只回傳草稿讓使用者確認。
+
+// 偏好選項，與前端精靈 (static/index.html) 的選項一致
+var (
+	paceOptions      = []string{"悠閒", "適中", "緊湊"}
+	typeOptions      = []string{"景點", "美食", "購物", "自然", "博物館", "夜生活", "親子", "展演"}
+	transportOptions = []string{"步行", "大眾運輸", "計程車", "自駕"}
+	diningOptions    = []string{"在地小吃", "米其林/評鑑", "平價優先", "素食友善"}
+)
+
+// 與前端精靈相同的預設值
+const (
+	defaultTripPeople     = 1
+	defaultTripDailyHours = 8
+	defaultTripPace       = "適中"
+	maxTripDays           = 60
+	tripTextMaxLength     = 1000 // text 的字數上限
+)
+
+// tripTextFields 回傳 fields 時的欄位順序
+var tripTextFields = []string{
+	"name", "region", "start_date", "days", "budget_twd", "people", "daily_hours",
+	"preferences.pace", "preferences.types", "preferences.transport", "preferences.dining",
+}
+
+// 欄位來源
+const (
+	fieldFromText  = "text"
+	fieldInferred  = "inferred"
+	fieldDefaulted = "default"
+)
+
+// tripFromTextRequest POST /api/trips/from-text 的 body
+type tripFromTextRequest struct {
+	Text   string `json:"text" binding:"required"`
+	Create bool   `json:"create"` // true 時直接建立行程
+	Model  string `json:"model"`
+}
+
+// tripExtraction 模型依 tripExtractionSchema 回傳的 JSON
+type tripExtraction struct {
+	Name        string      `json:"name"`
+	Region      string      `json:"region"`
+	StartDate   string      `json:"start_date"`
+	Days        int         `json:"days"`
+	BudgetTWD   int         `json:"budget_twd"`
+	People      int         `json:"people"`
+	DailyHours  int         `json:"daily_hours"`
+	Preferences Preferences `json:"preferences"`
+	Mentioned   []string    `json:"mentioned"` // 句子裡明確提到的欄位
+}
+
+func tripExtractionSchema() *JSONSchema {
+	list := func(options []string) *JSONSchema {
+		return &JSONSchema{Type: "array", Items: &JSONSchema{Type: "string", Enum: options}}
+	}
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"name":        {Type: "string", Description: "行程名稱"},
+			"region":      {Type: "string", Description: "目的地"},
+			"start_date":  {Type: "string", Description: "YYYY-MM-DD，不知道就留空"},
+			"days":        {Type: "integer", Description: "天數，不知道填 0"},
+			"budget_twd":  {Type: "integer", Description: "總預算 (新台幣)，不知道填 0"},
+			"people":      {Type: "integer", Description: "人數，不知道填 0"},
+			"daily_hours": {Type: "integer", Description: "每天活動時數，不知道填 0"},
+			"preferences": {
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"pace":      {Type: "string", Description: strings.Join(paceOptions, " / ") + "，沒提到就留空"},
+					"types":     list(typeOptions),
+					"transport": list(transportOptions),
+					"dining":    list(diningOptions),
+				},
+				Required: []string{"pace", "types", "transport", "dining"},
+			},
+			"mentioned": list(tripTextFields),
+		},
+		Required: []string{"name", "region", "start_date", "days", "budget_twd", "people", "daily_hours", "preferences", "mentioned"},
+	}
+}
+
+// filterOptions 只保留合法的選項 (去除重複)，其餘放進 dropped
+func filterOptions(values, options []string) (kept, dropped []string) {
+	kept = []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "" || slices.Contains(kept, v):
+		case slices.Contains(options, v):
+			kept = append(kept, v)
+		default:
+			dropped = append(dropped, v)
+		}
+	}
+	return kept, dropped
+}
+
+// buildTripFromText 檢查模型輸出、補上預設值，並標示每個欄位的來源
+func buildTripFromText(ext tripExtraction, today time.Time) (Trip, map[string]string, []string) {
+	fields := map[string]string{}
+	var warnings []string
+
+	mentioned := map[string]bool{}
+	for _, f := range ext.Mentioned {
+		mentioned[f] = true
+	}
+	// 有值的欄位：句子裡提到的算 text，其餘算 inferred
+	source := func(field string, ok bool) bool {
+		switch {
+		case !ok:
+			fields[field] = fieldDefaulted
+		case mentioned[field]:
+			fields[field] = fieldFromText
+		default:
+			fields[field] = fieldInferred
+		}
+		return ok
+	}
+
+	trip := Trip{
+		Region: strings.TrimSpace(ext.Region),
+		Name:   strings.TrimSpace(ext.Name),
+	}
+	source("region", trip.Region != "")
+	if !source("name", trip.Name != "") {
+		trip.Name = trip.Region
+	}
+
+	if ext.StartDate != "" {
+		start, err := time.Parse("2006-01-02", ext.StartDate)
+		switch {
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("start_date %q 不是 YYYY-MM-DD，已忽略", ext.StartDate))
+		case start.Format("2006-01-02") < today.Format("2006-01-02"):
+			trip.StartDate = ext.StartDate
+			warnings = append(warnings, fmt.Sprintf("出發日 %s 已經過了，請確認年份", ext.StartDate))
+		default:
+			trip.StartDate = ext.StartDate
+		}
+	}
+	source("start_date", trip.StartDate != "")
+
+	if ext.Days > maxTripDays {
+		warnings = append(warnings, fmt.Sprintf("天數 %d 超過上限 %d，已忽略", ext.Days, maxTripDays))
+	} else if ext.Days > 0 {
+		trip.Days = ext.Days
+	}
+	source("days", trip.Days > 0)
+
+	if ext.BudgetTWD > 0 {
+		trip.BudgetTWD = ext.BudgetTWD
+	}
+	source("budget_twd", trip.BudgetTWD > 0)
+
+	trip.People = ext.People
+	if !source("people", ext.People > 0) {
+		trip.People = defaultTripPeople
+	}
+
+	trip.DailyHours = ext.DailyHours
+	if !source("daily_hours", ext.DailyHours > 0 && ext.DailyHours <= 24) {
+		trip.DailyHours = defaultTripDailyHours
+	}
+
+	trip.Preferences.Pace = ext.Preferences.Pace
+	if !source("preferences.pace", slices.Contains(paceOptions, ext.Preferences.Pace)) {
+		trip.Preferences.Pace = defaultTripPace
+	}
+
+	for _, p := range []struct {
+		field   string
+		values  []string
+		options []string
+		dst     *[]string
+	}{
+		{"preferences.types", ext.Preferences.Types, typeOptions, &trip.Preferences.Types},
+		{"preferences.transport", ext.Preferences.Transport, transportOptions, &trip.Preferences.Transport},
+		{"preferences.dining", ext.Preferences.Dining, diningOptions, &trip.Preferences.Dining},
+	} {
+		kept, dropped := filterOptions(p.values, p.options)
+		if len(dropped) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s 不支援 %s，已忽略", p.field, strings.Join(dropped, "、")))
+		}
+		*p.dst = kept
+		source(p.field, len(kept) > 0)
+	}
+
+	return trip, fields, warnings
+}
+
+// fieldsBySource 依 tripTextFields 的順序列出某個來源的欄位
+func fieldsBySource(fields map[string]string, src string) []string {
+	out := []string{}
+	for _, f := range tripTextFields {
+		if fields[f] == src {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// createTripFromText 由一句話抽出行程設定：POST /api/trips/from-text
+func createTripFromText(c *gin.Context) {
+	var req tripFromTextRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		c.JSON(400, gin.H{"error": "text 不可為空"})
+		return
+	}
+	if len([]rune(req.Text)) > tripTextMaxLength {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("text 不可超過 %d 字", tripTextMaxLength)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	today := time.Now()
+
+	prompt, ref, err := prompts.Render("trip_from_text", requestLocale(c), TripFromTextPromptVars{
+		Text:  req.Text,
+		Today: today.Format("2006-01-02"),
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	llm, err := llmFor(taskGenerate)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Client error: " + err.Error()})
+		return
+	}
+
+	res, err := llm.Structured(ctx, LLMRequest{
+		Model:       req.Model,
+		Prompt:      prompt,
+		Temperature: float32Ptr(0.1),
+		MaxTokens:   1024,
+	}, tripExtractionSchema())
+	if err != nil {
+		respondLLMError(c, llm.Name(), err, nil)
+		return
+	}
+
+	var ext tripExtraction
+	if err := json.Unmarshal([]byte(res.Text), &ext); err != nil {
+		c.JSON(502, gin.H{"error": fmt.Sprintf("%v: %v", errModelJSON, err)})
+		return
+	}
+
+	trip, fields, warnings := buildTripFromText(ext, today)
+	if trip.Region == "" {
+		warnings = append(warnings, "找不到目的地，請補上 region")
+	}
+
+	// 沒有目的地的行程連名稱都沒有，不直接建立，回傳草稿讓使用者補上
+	if req.Create && trip.Region == "" {
+		c.JSON(422, gin.H{
+			"error":    "找不到目的地，無法建立行程，請補上 region",
+			"trip":     trip,
+			"created":  false,
+			"fields":   fields,
+			"warnings": warnings,
+			"prompts":  []PromptRef{ref},
+		})
+		return
+	}
+
+	code := 200
+	if req.Create {
+		if err := insertTrip(ctx, &trip); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		code = 201
+	}
+
+	c.JSON(code, gin.H{
+		"trip":      trip,
+		"created":   req.Create,
+		"fields":    fields,
+		"inferred":  fieldsBySource(fields, fieldInferred),
+		"defaulted": fieldsBySource(fields, fieldDefaulted),
+		"warnings":  warnings,
+		"prompts":   []PromptRef{ref},
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTripFromText(t *testing.T) {
+	today := time.Date(2025, 5, 1, 10, 0, 0, 0, time.Local)
+	ext := tripExtraction{
+		Name:       "東京 9 日遊",
+		Region:     "東京",
+		StartDate:  "2026-04-22",
+		Days:       9,
+		BudgetTWD:  20000,
+		People:     2,
+		DailyHours: 30,
+		Preferences: Preferences{
+			Types:     []string{"博物館", "自然", "博物館", "溫泉"},
+			Transport: []string{},
+		},
+		Mentioned: []string{"region", "start_date", "days", "budget_twd", "people", "preferences.types"},
+	}
+
+	trip, fields, warnings := buildTripFromText(ext, today)
+
+	if trip.Region != "東京" || trip.People != 2 || trip.Days != 9 || trip.BudgetTWD != 20000 || trip.StartDate != "2026-04-22" {
+		t.Errorf("trip = %+v", trip)
+	}
+	if trip.DailyHours != defaultTripDailyHours || trip.Preferences.Pace != defaultTripPace {
+		t.Errorf("defaults not applied: %+v", trip)
+	}
+	if strings.Join(trip.Preferences.Types, ",") != "博物館,自然" || trip.Preferences.Transport == nil {
+		t.Errorf("preferences = %+v", trip.Preferences)
+	}
+	if fields["region"] != fieldFromText || fields["name"] != fieldInferred || fields["daily_hours"] != fieldDefaulted {
+		t.Errorf("fields = %v", fields)
+	}
+	if got := strings.Join(fieldsBySource(fields, fieldInferred), ","); got != "name" {
+		t.Errorf("inferred = %s", got)
+	}
+	if got := strings.Join(fieldsBySource(fields, fieldDefaulted), ","); got != "daily_hours,preferences.pace,preferences.transport,preferences.dining" {
+		t.Errorf("defaulted = %s", got)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "溫泉") {
+		t.Errorf("warnings = %q", warnings)
+	}
+
+	// 日期已經過了只警告；格式錯誤則忽略
+	_, _, warnings = buildTripFromText(tripExtraction{Region: "大阪", StartDate: "2025-04-22"}, today)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "已經過了") {
+		t.Errorf("past date warnings = %q", warnings)
+	}
+	trip, fields, _ = buildTripFromText(tripExtraction{Region: "大阪", StartDate: "4/22"}, today)
+	if trip.StartDate != "" || fields["start_date"] != fieldDefaulted || trip.Name != "大阪" || trip.People != defaultTripPeople {
+		t.Errorf("trip = %+v, fields = %v", trip, fields)
+	}
+}
+
+func TestTripFromTextPromptWithFakeProvider(t *testing.T) {
+	out, _ := json.Marshal(tripExtraction{
+		Region:    "東京",
+		StartDate: "2026-04-22",
+		Days:      9,
+		People:    2,
+		Mentioned: []string{"region", "start_date", "days", "people"},
+	})
+	llm := newFakeProvider().On("2 人 4/22 去東京", string(out))
+
+	prompt, ref, err := prompts.Render("trip_from_text", "zh-TW", TripFromTextPromptVars{
+		Text:  "2 人 4/22 去東京 9 天 預算 2 萬 喜歡博物館 自然",
+		Today: "2025-05-01",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Name != "trip_from_text" || !strings.Contains(prompt, "今天是 2025-05-01") {
+		t.Errorf("ref = %v, prompt = %q", ref, prompt)
+	}
+
+	res, err := llm.Structured(context.Background(), LLMRequest{Prompt: prompt}, tripExtractionSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ext tripExtraction
+	if err := json.Unmarshal([]byte(res.Text), &ext); err != nil {
+		t.Fatal(err)
+	}
+	trip, fields, _ := buildTripFromText(ext, time.Date(2025, 5, 1, 0, 0, 0, 0, time.Local))
+	if trip.Region != "東京" || trip.Days != 9 || fields["people"] != fieldFromText || fields["name"] != fieldDefaulted {
+		t.Errorf("trip = %+v, fields = %v", trip, fields)
+	}
+}
@@ -21,6 +21,7 @@ func getTrips(c *gin.Context) {
 	for cursor.Next(context.Background()) {
 		var t Trip
 		if err := cursor.Decode(&t); err == nil {
+			registerTripImages(&t)
 			tripList = append(tripList, t)
 		}
 	}
@@ -42,6 +43,7 @@ func getTrip(c *gin.Context) {
 		return
 	}
 
+	registerTripImages(&trip)
 	c.JSON(200, trip)
 }
 
@@ -52,7 +54,18 @@ func createTrip(c *gin.Context) {
 		return
 	}
 
+	if err := insertTrip(context.Background(), &trip); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, trip)
+}
+
+// insertTrip 補上 ID / 版本 / 時間與空白的每日 plan 後寫入 trips
+func insertTrip(ctx context.Context, trip *Trip) error {
 	trip.ID = int(time.Now().Unix())
+	trip.Version = 1
 	trip.CreatedAt = time.Now()
 	trip.UpdatedAt = time.Now()
 
@@ -60,13 +73,8 @@ func createTrip(c *gin.Context) {
 		trip.Plan = expandDays(trip.StartDate, trip.Days)
 	}
 
-	_, err := tripsCollection.InsertOne(context.Background(), trip)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(201, trip)
+	_, err := tripsCollection.InsertOne(ctx, trip)
+	return err
 }
 
 func updateTrip(c *gin.Context) {
@@ -120,11 +128,17 @@ func updateTrip(c *gin.Context) {
 		update["plan"] = v
 	}
 
-	// 4. 執行更新
+	// 4. 有帶 version 時只在版本相符才更新，避免覆蓋別人剛做的修改
+	filter := bson.M{"id": id}
+	if v, ok := rawMap["version"].(float64); ok {
+		filter["version"] = versionFilter(int(v))
+	}
+
+	// 5. 執行更新
 	result, err := tripsCollection.UpdateOne(
 		context.Background(),
-		bson.M{"id": id},
-		bson.M{"$set": update},
+		filter,
+		bson.M{"$set": update, "$inc": bson.M{"version": 1}},
 	)
 
 	if err != nil {
@@ -133,6 +147,12 @@ func updateTrip(c *gin.Context) {
 	}
 
 	if result.MatchedCount == 0 {
+		if _, ok := filter["version"]; ok {
+			if _, err := findTripByID(context.Background(), id); err == nil {
+				c.JSON(409, gin.H{"error": "行程已被修改，請重新整理後再試"})
+				return
+			}
+		}
 		c.JSON(404, gin.H{"error": "Trip not found"})
 		return
 	}
@@ -153,5 +173,11 @@ func deleteTrip(c *gin.Context) {
 		return
 	}
 
+	// 行程刪除後，所屬的對話也一併清除
+	if _, err := chatSessionsCollection.DeleteMany(context.Background(), bson.M{"trip_id": id}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(200, gin.H{"message": "Trip deleted"})
 }
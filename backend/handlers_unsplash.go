@@ -1,88 +1,119 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"errors"
+	"log"
 	"net/http"
-	"net/url"
-	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ========== Unsplash helper (simple proxy + cache) ==========
-var unsplashCache = struct {
-	mu sync.Mutex
-	m  map[string]string
-}{m: make(map[string]string)}
+// ========== 圖片搜尋 (proxy + cache) ==========
+//
+// 依序查詢各圖片來源 (images.go)，結果放在 LRU 快取 (cache.go)，查無結果也會快取一段較短的時間，
+// 避免同樣的關鍵字一直打到 Unsplash 的速率限制 (變數名稱沿用 UNSPLASH_ 開頭)：
+//
+//	UNSPLASH_CACHE_SIZE=500              最多快取幾個關鍵字
+//	UNSPLASH_CACHE_TTL_HOURS=168         有結果的保留時間
+//	UNSPLASH_CACHE_MISS_TTL_MINUTES=60   查無結果的保留時間
+//	UNSPLASH_CACHE_STORE=memory          memory / file / mongo，後兩者重新啟動後仍保留
+//	UNSPLASH_CACHE_FILE=../data/cache/unsplash.json
+//	UNSPLASH_DOWNLOADS_CACHE_STORE=file  已通知 download_location 的照片 (images.go)，預設存在 ../data/cache/unsplash_downloads.json
 
-func unsplashHandler(c *gin.Context) {
-	q := c.Query("query")
-	if q == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing query"})
-		return
-	}
+var unsplashCache = newUnsplashCache(nil)
 
-	// simple cache key
-	key := strings.ToLower(strings.TrimSpace(q))
-	unsplashCache.mu.Lock()
-	if v, ok := unsplashCache.m[key]; ok {
-		unsplashCache.mu.Unlock()
-		c.JSON(200, gin.H{"url": v})
-		return
+func newUnsplashCache(store cacheStore) *ttlCache[ImageResult] {
+	return newTTLCache[ImageResult]("unsplash",
+		envInt("UNSPLASH_CACHE_SIZE", 500),
+		time.Duration(envInt("UNSPLASH_CACHE_TTL_HOURS", 168))*time.Hour,
+		time.Duration(envInt("UNSPLASH_CACHE_MISS_TTL_MINUTES", 60))*time.Minute,
+		store,
+	)
+}
+
+// initUnsplashCache 依 UNSPLASH_CACHE_STORE 設定持久化並載回上次的資料；需在 initMongo 之後呼叫
+func initUnsplashCache() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 已通知過的照片要跨重新啟動保留，才不會重複通知
+	unsplashDownloads = newUnsplashDownloads(cacheStoreFromEnv(ctx, "UNSPLASH_DOWNLOADS", "unsplash_downloads", "file"))
+	if _, err := unsplashDownloads.Restore(ctx); err != nil {
+		log.Printf("Unsplash 下載紀錄載入失敗: %v", err)
 	}
-	unsplashCache.mu.Unlock()
 
-	accessKey := os.Getenv("UNSPLASH_ACCESS_KEY")
-	if accessKey == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "UNSPLASH_ACCESS_KEY not set"})
+	store := cacheStoreFromEnv(ctx, "UNSPLASH", "unsplash", "memory")
+	// 重新建立一次，讓 .env 裡的設定生效
+	unsplashCache = newUnsplashCache(store)
+	if store == nil {
 		return
 	}
-
-	api := fmt.Sprintf("https://api.unsplash.com/search/photos?query=%s&per_page=1", url.QueryEscape(q))
-	req, err := http.NewRequest("GET", api, nil)
+	n, err := unsplashCache.Restore(ctx)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		log.Printf("Unsplash 快取載入失敗: %v", err)
 		return
 	}
-	req.Header.Set("Authorization", "Client-ID "+accessKey)
+	log.Printf("Unsplash 快取 (%s) 載入 %d 筆", store.Name(), n)
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+// imageSearchHandler 依關鍵字找一張圖片：GET /api/images/search?query=東京鐵塔
+// (舊路徑 /api/unsplash 保留給前端)；查無結果時 url 為空字串
+func imageSearchHandler(c *gin.Context) {
+	q := c.Query("query")
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing query"})
 		return
 	}
-	defer resp.Body.Close()
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	var result struct {
-		Results []struct {
-			Urls map[string]string `json:"urls"`
-		} `json:"results"`
+	img, found, err := lookupImage(c.Request.Context(), q)
+	if errors.Is(err, errNoImageProvider) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "invalid response from unsplash"})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 		return
 	}
+	respondImage(c, img, found)
+}
+
+var errNoImageProvider = errors.New("no image provider configured")
 
-	if len(result.Results) > 0 {
-		url := result.Results[0].Urls["regular"]
-		if url == "" {
-			url = result.Results[0].Urls["small"]
+// lookupImage 透過快取找一張圖片；found 為 false 代表查無結果
+func lookupImage(ctx context.Context, q string) (ImageResult, bool, error) {
+	// simple cache key
+	key := strings.ToLower(strings.TrimSpace(q))
+	providers := imageProviders
+	if len(providers) == 0 {
+		// 沒有可用的來源時仍可使用先前 (例如從檔案載回) 的快取
+		if img, found, ok := unsplashCache.Get(key); ok {
+			return img, found, nil
 		}
-		if url != "" {
-			unsplashCache.mu.Lock()
-			unsplashCache.m[key] = url
-			unsplashCache.mu.Unlock()
-			c.JSON(200, gin.H{"url": url})
-			return
+		return ImageResult{}, false, errNoImageProvider
+	}
+
+	return unsplashCache.GetOrLoad(ctx, key, func(ctx context.Context) (ImageResult, bool, error) {
+		// 只是顯示搜尋結果，不算使用；選定圖片時才通知來源 (Unsplash download tracking)
+		img, err := searchImage(ctx, providers, q)
+		if err != nil || img == nil {
+			return ImageResult{}, false, err
 		}
+		return *img, true, nil
+	})
+}
+
+func respondImage(c *gin.Context, img ImageResult, found bool) {
+	if !found {
+		c.JSON(200, gin.H{"url": ""})
+		return
 	}
+	c.JSON(200, withProxyURL(img))
+}
 
-	c.JSON(200, gin.H{"url": ""})
+// cacheStatsHandler 各個快取的命中、未命中與淘汰次數：GET /api/admin/cache
+func cacheStatsHandler(c *gin.Context) {
+	c.JSON(200, gin.H{"caches": allCacheStats()})
 }
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ========== 圖片代理 (縮圖 + 磁碟快取) ==========
+//
+// 搜尋到的圖片以來源網址的 hash 當作 ID 登記 (respondImage 回傳 proxy_url)，
+// GET /api/images/:id?size=card 第一次使用時下載原圖存到磁碟，再產生各尺寸的 JPEG：
+//
+//	IMAGE_CACHE_DIR=../data/cache/images   原圖與縮圖的存放位置
+//	IMAGE_CACHE_MAX_MB=512                  超過時刪除最久沒用的檔案 (來源登記不會刪除，之後可重新下載)
+//	IMAGE_MAX_SOURCE_MB=20                  原圖大小上限
+//	IMAGE_MAX_SOURCE_MEGAPIXELS=50          原圖像素上限；解碼前先以 DecodeConfig 檢查，避免小檔案宣告超大尺寸耗盡記憶體
+//
+// 同一個 ID 的內容不會改變，回應帶一年的 Cache-Control 與 ETag。
+// x/image 只有 WebP 解碼器，沒有純 Go 的編碼器，所以輸出一律是 JPEG。
+
+// imageVariant 縮圖尺寸；等比例縮小到框內，不會放大
+type imageVariant struct {
+	Width, Height int
+}
+
+var imageVariants = map[string]imageVariant{
+	"thumb": {240, 240},
+	"card":  {800, 600},
+	"hero":  {1920, 1080},
+}
+
+// 調整縮圖參數時要改版號，讓瀏覽器與磁碟上的舊縮圖失效
+const imageVariantVersion = "v1"
+
+const imageJPEGQuality = 82
+
+var reImageProxyID = regexp.MustCompile(`^[0-9a-f]{20}$`)
+
+var errImageNotFound = errors.New("unknown image id")
+
+// imageCache 由 initImageCache 建立；為 nil 時不提供代理
+var imageCache *imageStore
+
+// imageStore 磁碟上的圖片快取；sources 是 ID 對應的來源網址，files 是已下載或產生的檔案
+type imageStore struct {
+	dir            string
+	maxBytes       int64
+	maxSourceBytes int64
+	maxPixels      int64
+	client         *http.Client
+	now            func() time.Time
+
+	mu       sync.Mutex
+	sources  map[string]string
+	files    map[string]*imageFile
+	total    int64
+	inflight map[string]*imageCall
+	stats    cacheStats
+}
+
+type imageFile struct {
+	size int64
+	used time.Time
+}
+
+// imageCall 進行中的下載或縮圖
+type imageCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// imageSourceRecord 存在 <id>.json 的來源登記
+type imageSourceRecord struct {
+	URL      string `json:"url"`
+	Provider string `json:"provider,omitempty"`
+}
+
+func initImageCache() {
+	s, err := newImageStore(
+		envOr("IMAGE_CACHE_DIR", "../data/cache/images"),
+		int64(envInt("IMAGE_CACHE_MAX_MB", 512))<<20,
+	)
+	if err != nil {
+		log.Printf("圖片快取目錄無法使用，停用圖片代理: %v", err)
+		return
+	}
+	s.maxSourceBytes = int64(envInt("IMAGE_MAX_SOURCE_MB", 20)) << 20
+	s.maxPixels = int64(envInt("IMAGE_MAX_SOURCE_MEGAPIXELS", 50)) * 1000 * 1000
+	imageCache = s
+	st := s.Stats()
+	log.Printf("圖片快取 %s：%d 個來源、%d 個檔案 (%d KB)", s.dir, len(s.sources), st.Restored, st.Size>>10)
+}
+
+// newImageStore 建立目錄並載回上次的來源登記與檔案
+func newImageStore(dir string, maxBytes int64) (*imageStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &imageStore{
+		dir:            dir,
+		maxBytes:       max(maxBytes, 1),
+		maxSourceBytes: 20 << 20,
+		maxPixels:      50 * 1000 * 1000,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		now:            time.Now,
+		sources:        map[string]string{},
+		files:          map[string]*imageFile{},
+		inflight:       map[string]*imageCall{},
+		stats:          cacheStats{Name: "images", Store: "disk"},
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		if id, ok := strings.CutSuffix(name, ".json"); ok {
+			var rec imageSourceRecord
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil && json.Unmarshal(data, &rec) == nil && rec.URL != "" {
+				s.sources[id] = rec.URL
+			}
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s.files[name] = &imageFile{size: info.Size(), used: info.ModTime()}
+		s.total += info.Size()
+		s.stats.Restored++
+	}
+	s.mu.Lock()
+	s.evictLocked("")
+	s.mu.Unlock()
+
+	cachesMu.Lock()
+	caches[s.stats.Name] = s
+	cachesMu.Unlock()
+	return s, nil
+}
+
+// Stats 與其他快取一起列在 /api/admin/cache；Size 與 Capacity 以 bytes 計
+func (s *imageStore) Stats() cacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.Size = int(s.total)
+	st.Capacity = int(s.maxBytes)
+	return st
+}
+
+// imageProxyID 同一個來源網址永遠得到同一個 ID
+func imageProxyID(sourceURL string) string {
+	sum := sha256.Sum256([]byte(sourceURL))
+	return hex.EncodeToString(sum[:10])
+}
+
+// Register 登記圖片並回傳 ID；只有登記過的網址才能透過代理下載，避免變成任意網址的 proxy
+func (s *imageStore) Register(img ImageResult) string {
+	id := imageProxyID(img.URL)
+	s.mu.Lock()
+	_, ok := s.sources[id]
+	if !ok {
+		s.sources[id] = img.URL
+	}
+	s.mu.Unlock()
+	if ok {
+		return id
+	}
+	data, _ := json.Marshal(imageSourceRecord{URL: img.URL, Provider: img.Provider})
+	if err := writeFileAtomic(filepath.Join(s.dir, id+".json"), data); err != nil {
+		log.Printf("圖片來源登記寫入失敗 (%s): %v", id, err)
+	}
+	return id
+}
+
+// withProxyURL 登記圖片並填入 proxy_url；沒有啟用圖片代理時原樣回傳
+func withProxyURL(img ImageResult) ImageResult {
+	if imageCache != nil && img.URL != "" {
+		img.ProxyURL = "/api/images/" + imageCache.Register(img)
+	}
+	return img
+}
+
+// Variant 回傳某個尺寸的 JPEG；原圖與縮圖都只會產生一次
+func (s *imageStore) Variant(ctx context.Context, id, size string) ([]byte, error) {
+	v, ok := imageVariants[size]
+	if !ok {
+		return nil, fmt.Errorf("unknown size %q", size)
+	}
+	s.mu.Lock()
+	src, ok := s.sources[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errImageNotFound
+	}
+	name := fmt.Sprintf("%s_%s_%s.jpg", id, size, imageVariantVersion)
+	return s.load(ctx, name, func(ctx context.Context) ([]byte, error) {
+		orig, err := s.load(ctx, id+".orig", func(ctx context.Context) ([]byte, error) {
+			return s.fetch(ctx, src)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resizeImage(orig, v, s.maxPixels)
+	})
+}
+
+// load 讀取磁碟上的檔案，沒有時呼叫 fill 產生並寫入；同一個檔案同時只會有一個 fill 在執行
+func (s *imageStore) load(ctx context.Context, name string, fill func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	path := filepath.Join(s.dir, name)
+	s.mu.Lock()
+	if f, ok := s.files[name]; ok {
+		now := s.now()
+		f.used = now
+		s.mu.Unlock()
+		if data, err := os.ReadFile(path); err == nil {
+			os.Chtimes(path, now, now) // 重新啟動後依 mtime 判斷最近使用
+			s.mu.Lock()
+			s.stats.Hits++
+			s.mu.Unlock()
+			return data, nil
+		}
+		// 檔案被外部刪除，重新產生
+		s.mu.Lock()
+		if f, ok := s.files[name]; ok {
+			s.total -= f.size
+			delete(s.files, name)
+		}
+	}
+	if call, ok := s.inflight[name]; ok {
+		s.stats.Coalesced++
+		s.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.data, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &imageCall{done: make(chan struct{})}
+	s.inflight[name] = call
+	s.stats.Misses++
+	s.mu.Unlock()
+
+	// 其他請求也在等這個結果，不跟著第一個請求一起取消
+	call.data, call.err = fill(context.WithoutCancel(ctx))
+	if call.err == nil {
+		if err := writeFileAtomic(path, call.data); err != nil {
+			log.Printf("圖片快取寫入失敗 (%s): %v", name, err)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.inflight, name)
+	if call.err != nil {
+		s.stats.LoadErrors++
+	} else if _, err := os.Stat(path); err == nil {
+		if old, ok := s.files[name]; ok {
+			s.total -= old.size
+		}
+		s.files[name] = &imageFile{size: int64(len(call.data)), used: s.now()}
+		s.total += int64(len(call.data))
+		s.evictLocked(name)
+	}
+	s.mu.Unlock()
+	close(call.done)
+	return call.data, call.err
+}
+
+// evictLocked 在 s.mu 鎖住時呼叫，刪除最久沒用的檔案直到低於上限；keep 是剛寫入的檔案
+func (s *imageStore) evictLocked(keep string) {
+	for s.total > s.maxBytes {
+		oldest := ""
+		for name, f := range s.files {
+			if name != keep && (oldest == "" || f.used.Before(s.files[oldest].used)) {
+				oldest = name
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		if err := os.Remove(filepath.Join(s.dir, oldest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("圖片快取刪除失敗 (%s): %v", oldest, err)
+		}
+		s.total -= s.files[oldest].size
+		delete(s.files, oldest)
+		s.stats.Evictions++
+	}
+}
+
+// fetch 下載原圖，超過 maxSourceBytes 或不是圖片時回傳錯誤
+func (s *imageStore) fetch(ctx context.Context, src string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", envOr("IMAGE_USER_AGENT", "trip-planner/1.0"))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.maxSourceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxSourceBytes {
+		return nil, fmt.Errorf("image larger than %d bytes", s.maxSourceBytes)
+	}
+	if ct := http.DetectContentType(data); !strings.HasPrefix(ct, "image/") {
+		return nil, fmt.Errorf("not an image (%s)", ct)
+	}
+	// 不符合的原圖不存到磁碟
+	if err := checkImagePixels(data, s.maxPixels); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkImagePixels 只讀取標頭取得尺寸，超過 maxPixels 時回傳錯誤
+func checkImagePixels(data []byte, maxPixels int64) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode image: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return fmt.Errorf("image too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// resizeImage 等比例縮小到 v 的框內 (不放大)，透明的部分補白色後輸出 JPEG；
+// 像素超過 maxPixels 的圖片不解碼
+func resizeImage(data []byte, v imageVariant, maxPixels int64) ([]byte, error) {
+	if err := checkImagePixels(data, maxPixels); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, errors.New("empty image")
+	}
+	scale := min(1, float64(v.Width)/float64(w), float64(v.Height)/float64(h))
+	dw, dh := max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: imageJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic 先寫暫存檔再 rename，避免讀到寫一半的檔案
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// imageProxyHandler 回傳縮圖：GET /api/images/:id?size=thumb|card|hero (預設 card)
+func imageProxyHandler(c *gin.Context) {
+	if imageCache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "image cache disabled"})
+		return
+	}
+	id := c.Param("id")
+	size := c.DefaultQuery("size", "card")
+	if !reImageProxyID.MatchString(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": errImageNotFound.Error()})
+		return
+	}
+	if _, ok := imageVariants[size]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be thumb, card or hero"})
+		return
+	}
+
+	// 同一個 ID 與尺寸的內容不會改變，不用讀檔就能回 304
+	etag := fmt.Sprintf(`"%s-%s-%s"`, id, size, imageVariantVersion)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, err := imageCache.Variant(c.Request.Context(), id, size)
+	if errors.Is(err, errImageNotFound) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageSourceServer 提供一張 1000x500 的 PNG (/photo.png)、一個不是圖片的網址 (/page) 與標頭尺寸超大的 PNG (/bomb.png)，
+// hits 記錄原圖被下載的次數
+func imageSourceServer(t *testing.T) (srv *httptest.Server, hits *atomic.Int32) {
+	t.Helper()
+	src := image.NewNRGBA(image.Rect(0, 0, 1000, 500))
+	for x := 0; x < 1000; x++ {
+		for y := 0; y < 500; y++ {
+			src.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	hits = &atomic.Int32{}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.png":
+			hits.Add(1)
+			w.Write(buf.Bytes())
+		case "/page":
+			w.Write([]byte("<html>not an image</html>"))
+		case "/bomb.png":
+			w.Write(pngBomb(buf.Bytes()))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hits
+}
+
+// pngBomb 把 PNG 標頭的尺寸改成 100000x100000 (檔案仍然很小)
+func pngBomb(data []byte) []byte {
+	out := bytes.Clone(data)
+	binary.BigEndian.PutUint32(out[16:], 100000)
+	binary.BigEndian.PutUint32(out[20:], 100000)
+	binary.BigEndian.PutUint32(out[29:], crc32.ChecksumIEEE(out[12:29]))
+	return out
+}
+
+func decodeJPEGSize(t *testing.T, data []byte) (int, int) {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a JPEG: %v", err)
+	}
+	return img.Bounds().Dx(), img.Bounds().Dy()
+}
+
+func TestImageStoreVariants(t *testing.T) {
+	srv, hits := imageSourceServer(t)
+	ctx := context.Background()
+	s, err := newImageStore(t.TempDir(), 10<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := s.Register(ImageResult{Provider: "unsplash", URL: srv.URL + "/photo.png"})
+	if id != s.Register(ImageResult{URL: srv.URL + "/photo.png"}) || !reImageProxyID.MatchString(id) {
+		t.Fatalf("id = %q", id)
+	}
+
+	// 同時要求同一個尺寸只會下載、縮圖一次
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Variant(ctx, id, "thumb"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	thumb, _ := s.Variant(ctx, id, "thumb")
+	if w, h := decodeJPEGSize(t, thumb); w != 240 || h != 120 {
+		t.Errorf("thumb = %dx%d", w, h)
+	}
+	card, _ := s.Variant(ctx, id, "card")
+	if w, h := decodeJPEGSize(t, card); w != 800 || h != 400 {
+		t.Errorf("card = %dx%d", w, h)
+	}
+	// 不會放大
+	hero, _ := s.Variant(ctx, id, "hero")
+	if w, h := decodeJPEGSize(t, hero); w != 1000 || h != 500 {
+		t.Errorf("hero = %dx%d", w, h)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("source fetched %d times", hits.Load())
+	}
+
+	// 重新啟動後沿用磁碟上的來源登記與檔案
+	restored, err := newImageStore(s.dir, 10<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Variant(ctx, id, "card"); err != nil || hits.Load() != 1 {
+		t.Errorf("restored: err=%v hits=%d", err, hits.Load())
+	}
+	if st := restored.Stats(); st.Restored != 4 || st.Hits != 1 || st.Size == 0 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	if _, err := s.Variant(ctx, "00000000000000000000", "card"); err != errImageNotFound {
+		t.Errorf("unknown id: %v", err)
+	}
+	page := s.Register(ImageResult{URL: srv.URL + "/page"})
+	if _, err := s.Variant(ctx, page, "card"); err == nil {
+		t.Error("expected error for non-image source")
+	}
+	// 宣告超大尺寸的圖片在解碼前就拒絕，也不存到磁碟
+	bomb := s.Register(ImageResult{URL: srv.URL + "/bomb.png"})
+	if _, err := s.Variant(ctx, bomb, "thumb"); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("bomb: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, bomb+".orig")); !os.IsNotExist(err) {
+		t.Errorf("bomb source stored: %v", err)
+	}
+}
+
+func TestImageStoreEviction(t *testing.T) {
+	srv, hits := imageSourceServer(t)
+	ctx := context.Background()
+	s, err := newImageStore(t.TempDir(), 1) // 只能留下剛寫入的檔案
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := s.Register(ImageResult{URL: srv.URL + "/photo.png"})
+
+	if _, err := s.Variant(ctx, id, "thumb"); err != nil {
+		t.Fatal(err)
+	}
+	st := s.Stats()
+	if len(s.files) != 1 || st.Evictions != 1 {
+		t.Errorf("files = %v, stats = %+v", s.files, st)
+	}
+	// 原圖被刪除後會重新下載，來源登記仍然保留
+	if _, err := s.Variant(ctx, id, "card"); err != nil || hits.Load() != 2 {
+		t.Errorf("err=%v hits=%d", err, hits.Load())
+	}
+}
+
+func TestImageProxyHandler(t *testing.T) {
+	srv, _ := imageSourceServer(t)
+	old := imageCache
+	s, err := newImageStore(t.TempDir(), 10<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageCache = s
+	defer func() { imageCache = old }()
+	id := s.Register(ImageResult{URL: srv.URL + "/photo.png"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/images/search", func(c *gin.Context) { c.String(200, "search") })
+	r.GET("/images/:id", imageProxyHandler)
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/images/"+id+"?size=thumb", "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/jpeg" || w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Fatalf("thumb = %d %v", w.Code, w.Header())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag")
+	}
+	if w := get("/images/"+id+"?size=thumb", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match = %d", w.Code)
+	}
+	if w := get("/images/"+id, etag); w.Code != 200 {
+		t.Errorf("card with thumb etag = %d", w.Code)
+	}
+	if w := get("/images/"+id+"?size=huge", ""); w.Code != 400 {
+		t.Errorf("bad size = %d", w.Code)
+	}
+	if w := get("/images/ffffffffffffffffffff", ""); w.Code != 404 || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unknown id = %d %v", w.Code, w.Header())
+	}
+	if w := get("/images/search", ""); w.Body.String() != "search" {
+		t.Errorf("search route shadowed: %q", w.Body.String())
+	}
+}
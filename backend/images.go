@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========== 圖片來源 ==========
+//
+// 依 IMAGE_PROVIDERS 的順序查詢，第一個有結果的為準：
+//
+//	IMAGE_PROVIDERS=unsplash,pexels,wikimedia
+//	UNSPLASH_ACCESS_KEY / PEXELS_API_KEY   沒有設定的來源會略過
+//	UNSPLASH_API_URL / PEXELS_API_URL / WIKIMEDIA_API_URL   覆寫端點 (測試時指向本地 stub)
+//	UNSPLASH_APP_NAME=trip_planner   Unsplash 出處連結的 utm_source
+//
+// Wikimedia Commons 不需要 key，放在最後當作備援。
+// 結果統一成 ImageResult (網址、尺寸、作者、授權)。
+
+// ImageResult 一張圖片；前端依 author、author_url、provider_url 顯示出處
+type ImageResult struct {
+	Provider    string            `json:"provider"`
+	ID          string            `json:"id,omitempty"` // 來源網站的圖片 ID
+	URL         string            `json:"url"`
+	URLs        map[string]string `json:"urls,omitempty"` // 各種尺寸 (Unsplash: raw / full / regular / small / thumb)
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	BlurHash    string            `json:"blur_hash,omitempty"` // 載入前的模糊預覽
+	Author      string            `json:"author,omitempty"`
+	AuthorURL   string            `json:"author_url,omitempty"`
+	License     string            `json:"license"`
+	SourceURL   string            `json:"source_url,omitempty"`         // 圖片在來源網站的頁面
+	ProviderURL string            `json:"provider_url,omitempty"`       // 來源網站首頁 (出處裡的「on Unsplash」)
+	ProxyURL    string            `json:"proxy_url,omitempty" bson:"-"` // 本機的縮圖代理 (image_proxy.go)，回應時才填入，不存到行程
+
+	// 使用圖片時要通知的網址 (Unsplash 的 download_location)，只在查詢當下使用，不回傳也不存到行程
+	DownloadLocation string `json:"-" bson:"-"`
+}
+
+// imageUseTracker 使用圖片時需要通知來源的 provider (例如 Unsplash 的 download tracking)
+type imageUseTracker interface {
+	TrackUse(img ImageResult)
+}
+
+// trackImageUse 通知圖片的來源；不需要通知的來源直接略過
+func trackImageUse(providers []ImageProvider, img ImageResult) {
+	for _, p := range providers {
+		if t, ok := p.(imageUseTracker); ok && p.Name() == img.Provider {
+			t.TrackUse(img)
+		}
+	}
+}
+
+// ImageProvider 圖片搜尋
+type ImageProvider interface {
+	Name() string
+	// Search 回傳最相關的一張圖片，查無結果時回傳 nil
+	Search(ctx context.Context, query string) (*ImageResult, error)
+}
+
+// imageListSearcher 可以一次回傳多個候選圖片的 provider (供使用者挑選)
+type imageListSearcher interface {
+	SearchList(ctx context.Context, query string, limit int) ([]ImageResult, error)
+}
+
+// firstImage 由 SearchList 的結果取第一張，實作 Search
+func firstImage(list []ImageResult, err error) (*ImageResult, error) {
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// imageProviders 依序查詢的來源，由 initImageProviders 設定；測試時可替換
+var imageProviders []ImageProvider
+
+var imageHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func initImageProviders() {
+	imageProviders = nil
+	for _, name := range strings.Split(envOr("IMAGE_PROVIDERS", "unsplash,pexels,wikimedia"), ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "unsplash":
+			if key := os.Getenv("UNSPLASH_ACCESS_KEY"); key != "" {
+				imageProviders = append(imageProviders, newUnsplashProvider(envOr("UNSPLASH_API_URL", "https://api.unsplash.com"), key))
+			}
+		case "pexels":
+			if key := os.Getenv("PEXELS_API_KEY"); key != "" {
+				imageProviders = append(imageProviders, newPexelsProvider(envOr("PEXELS_API_URL", "https://api.pexels.com"), key))
+			}
+		case "wikimedia":
+			imageProviders = append(imageProviders, newWikimediaProvider(
+				envOr("WIKIMEDIA_API_URL", "https://commons.wikimedia.org"),
+				envOr("IMAGE_USER_AGENT", "trip-planner/1.0"),
+			))
+		case "":
+		default:
+			log.Printf("未知的圖片來源 %q，已略過", name)
+		}
+	}
+	names := make([]string, len(imageProviders))
+	for i, p := range imageProviders {
+		names[i] = p.Name()
+	}
+	fmt.Println("🖼️ Image providers:", strings.Join(names, " → "))
+}
+
+// searchImage 依序查詢各來源；全部沒有結果時回傳 nil。
+// 有來源出錯且沒有任何結果時回傳錯誤，避免把暫時的失敗當成「查無結果」快取起來
+func searchImage(ctx context.Context, providers []ImageProvider, query string) (*ImageResult, error) {
+	var errs []error
+	for _, p := range providers {
+		img, err := p.Search(ctx, query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		if img != nil && img.URL != "" {
+			img.Provider = p.Name()
+			return img, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// searchImageCandidates 依序向各來源要候選圖片，湊滿 limit 張為止 (相同網址只留一張)。
+// 只有全部沒有結果且有來源出錯時才回傳錯誤
+func searchImageCandidates(ctx context.Context, providers []ImageProvider, query string, limit int) ([]ImageResult, error) {
+	var list []ImageResult
+	var errs []error
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if len(list) >= limit {
+			break
+		}
+		var found []ImageResult
+		var err error
+		if ls, ok := p.(imageListSearcher); ok {
+			found, err = ls.SearchList(ctx, query, limit-len(list))
+		} else {
+			var img *ImageResult
+			if img, err = p.Search(ctx, query); img != nil {
+				found = []ImageResult{*img}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		for _, img := range found {
+			if img.URL == "" || seen[img.URL] || len(list) >= limit {
+				continue
+			}
+			seen[img.URL] = true
+			img.Provider = p.Name()
+			list = append(list, img)
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return list, nil
+}
+
+// getImageJSON 發出 GET 並解析 JSON
+func getImageJSON(ctx context.Context, endpoint string, header http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// ========== Unsplash ==========
+//
+// 依 Unsplash API 的規範：
+//   - 顯示攝影師與 Unsplash 的連結，連結要加上 utm_source=<app 名稱>&utm_medium=referral
+//   - 使用者選定圖片 (或自動補上照片) 時呼叫該照片的 download_location，只顯示搜尋結果不算；
+//     每張照片只通知一次，見 unsplashDownloads
+
+// unsplashDownloads 已通知過的照片 ID，由 initUnsplashCache 設定持久化
+var unsplashDownloads = newUnsplashDownloads(nil)
+
+func newUnsplashDownloads(store cacheStore) *ttlCache[bool] {
+	return newTTLCache[bool]("unsplash_downloads", 100000, 5*365*24*time.Hour, 0, store)
+}
+
+type unsplashProvider struct {
+	baseURL   string
+	accessKey string
+	appName   string // utm_source
+}
+
+func newUnsplashProvider(baseURL, accessKey string) *unsplashProvider {
+	return &unsplashProvider{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		accessKey: accessKey,
+		appName:   envOr("UNSPLASH_APP_NAME", "trip_planner"),
+	}
+}
+
+func (p *unsplashProvider) Name() string { return "unsplash" }
+
+// referral 加上 Unsplash 要求的 utm 參數
+func (p *unsplashProvider) referral(link string) string {
+	if link == "" {
+		return ""
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("utm_source", p.appName)
+	q.Set("utm_medium", "referral")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (p *unsplashProvider) Search(ctx context.Context, query string) (*ImageResult, error) {
+	return firstImage(p.SearchList(ctx, query, 1))
+}
+
+func (p *unsplashProvider) SearchList(ctx context.Context, query string, limit int) ([]ImageResult, error) {
+	q := url.Values{}
+	q.Set("query", query)
+	q.Set("per_page", strconv.Itoa(limit))
+	var result struct {
+		Results []struct {
+			ID       string            `json:"id"`
+			Width    int               `json:"width"`
+			Height   int               `json:"height"`
+			BlurHash string            `json:"blur_hash"`
+			Urls     map[string]string `json:"urls"`
+			Links    map[string]string `json:"links"`
+			User     struct {
+				Name  string            `json:"name"`
+				Links map[string]string `json:"links"`
+			} `json:"user"`
+		} `json:"results"`
+	}
+	header := http.Header{"Authorization": {"Client-ID " + p.accessKey}}
+	if err := getImageJSON(ctx, p.baseURL+"/search/photos?"+q.Encode(), header, &result); err != nil {
+		return nil, err
+	}
+	var list []ImageResult
+	for _, r := range result.Results {
+		img := ImageResult{
+			ID:               r.ID,
+			URL:              r.Urls["regular"],
+			URLs:             r.Urls,
+			Width:            r.Width,
+			Height:           r.Height,
+			BlurHash:         r.BlurHash,
+			Author:           r.User.Name,
+			AuthorURL:        p.referral(r.User.Links["html"]),
+			License:          "Unsplash License",
+			SourceURL:        p.referral(r.Links["html"]),
+			ProviderURL:      p.referral("https://unsplash.com/"),
+			DownloadLocation: r.Links["download_location"],
+		}
+		if img.URL == "" {
+			img.URL = r.Urls["small"]
+		}
+		if img.URL != "" {
+			list = append(list, img)
+		}
+	}
+	return list, nil
+}
+
+// TrackUse 在背景通知 Unsplash 照片被使用
+func (p *unsplashProvider) TrackUse(img ImageResult) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := p.trackDownload(ctx, img); err != nil {
+			log.Printf("unsplash download tracking %s: %v", img.ID, err)
+		}
+	}()
+}
+
+// trackDownload 每張照片只呼叫一次 download_location；失敗時不記錄，下次使用再試。
+// 從持久化快取載回的圖片沒有 download_location，改用 /photos/:id/download
+func (p *unsplashProvider) trackDownload(ctx context.Context, img ImageResult) error {
+	if img.ID == "" {
+		return nil
+	}
+	if img.DownloadLocation == "" {
+		img.DownloadLocation = p.baseURL + "/photos/" + url.PathEscape(img.ID) + "/download"
+	}
+	_, _, err := unsplashDownloads.GetOrLoad(ctx, img.ID, func(ctx context.Context) (bool, bool, error) {
+		var ack struct {
+			URL string `json:"url"`
+		}
+		header := http.Header{"Authorization": {"Client-ID " + p.accessKey}}
+		if err := getImageJSON(ctx, img.DownloadLocation, header, &ack); err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	})
+	return err
+}
+
+// ========== Pexels ==========
+
+type pexelsProvider struct {
+	baseURL string
+	apiKey  string
+}
+
+func newPexelsProvider(baseURL, apiKey string) *pexelsProvider {
+	return &pexelsProvider{baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey}
+}
+
+func (p *pexelsProvider) Name() string { return "pexels" }
+
+func (p *pexelsProvider) Search(ctx context.Context, query string) (*ImageResult, error) {
+	return firstImage(p.SearchList(ctx, query, 1))
+}
+
+func (p *pexelsProvider) SearchList(ctx context.Context, query string, limit int) ([]ImageResult, error) {
+	q := url.Values{}
+	q.Set("query", query)
+	q.Set("per_page", strconv.Itoa(limit))
+	var result struct {
+		Photos []struct {
+			Width           int               `json:"width"`
+			Height          int               `json:"height"`
+			URL             string            `json:"url"`
+			Photographer    string            `json:"photographer"`
+			PhotographerURL string            `json:"photographer_url"`
+			Src             map[string]string `json:"src"`
+		} `json:"photos"`
+	}
+	header := http.Header{"Authorization": {p.apiKey}}
+	if err := getImageJSON(ctx, p.baseURL+"/v1/search?"+q.Encode(), header, &result); err != nil {
+		return nil, err
+	}
+	var list []ImageResult
+	for _, r := range result.Photos {
+		img := ImageResult{
+			URL:       r.Src["large"],
+			Width:     r.Width,
+			Height:    r.Height,
+			Author:    r.Photographer,
+			AuthorURL: r.PhotographerURL,
+			License:   "Pexels License",
+			SourceURL: r.URL,
+		}
+		if img.URL == "" {
+			img.URL = r.Src["original"]
+		}
+		if img.URL != "" {
+			list = append(list, img)
+		}
+	}
+	return list, nil
+}
+
+// ========== Wikimedia Commons ==========
+
+// wikimediaThumbWidth 請 Commons 產生的縮圖寬度
+const wikimediaThumbWidth = 1280
+
+type wikimediaProvider struct {
+	baseURL   string
+	userAgent string // Wikimedia 要求可辨識的 User-Agent
+}
+
+func newWikimediaProvider(baseURL, userAgent string) *wikimediaProvider {
+	return &wikimediaProvider{baseURL: strings.TrimRight(baseURL, "/"), userAgent: userAgent}
+}
+
+func (p *wikimediaProvider) Name() string { return "wikimedia" }
+
+var reHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+func (p *wikimediaProvider) Search(ctx context.Context, query string) (*ImageResult, error) {
+	return firstImage(p.SearchList(ctx, query, 1))
+}
+
+func (p *wikimediaProvider) SearchList(ctx context.Context, query string, limit int) ([]ImageResult, error) {
+	q := url.Values{}
+	q.Set("action", "query")
+	q.Set("format", "json")
+	q.Set("generator", "search")
+	q.Set("gsrsearch", query+" filetype:bitmap") // 排除 PDF、SVG 與影片
+	q.Set("gsrnamespace", "6")                   // File:
+	q.Set("gsrlimit", strconv.Itoa(limit))
+	q.Set("prop", "imageinfo")
+	q.Set("iiprop", "url|size|extmetadata")
+	q.Set("iiurlwidth", strconv.Itoa(wikimediaThumbWidth))
+
+	type metaValue struct {
+		Value string `json:"value"`
+	}
+	var result struct {
+		Query struct {
+			Pages map[string]struct {
+				Index     int `json:"index"`
+				ImageInfo []struct {
+					URL            string               `json:"url"`
+					Width          int                  `json:"width"`
+					Height         int                  `json:"height"`
+					ThumbURL       string               `json:"thumburl"`
+					ThumbWidth     int                  `json:"thumbwidth"`
+					ThumbHeight    int                  `json:"thumbheight"`
+					DescriptionURL string               `json:"descriptionurl"`
+					ExtMetadata    map[string]metaValue `json:"extmetadata"`
+				} `json:"imageinfo"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	header := http.Header{"User-Agent": {p.userAgent}}
+	if err := getImageJSON(ctx, p.baseURL+"/w/api.php?"+q.Encode(), header, &result); err != nil {
+		return nil, err
+	}
+
+	// pages 是以 page id 為 key 的物件，依搜尋排名 (index) 排序
+	type ranked struct {
+		index int
+		img   ImageResult
+	}
+	var found []ranked
+	for _, page := range result.Query.Pages {
+		if len(page.ImageInfo) == 0 {
+			continue
+		}
+		info := page.ImageInfo[0]
+		img := ImageResult{
+			URL:       info.ThumbURL,
+			Width:     info.ThumbWidth,
+			Height:    info.ThumbHeight,
+			Author:    strings.TrimSpace(reHTMLTag.ReplaceAllString(info.ExtMetadata["Artist"].Value, "")),
+			License:   info.ExtMetadata["LicenseShortName"].Value,
+			SourceURL: info.DescriptionURL,
+		}
+		if img.URL == "" {
+			img.URL, img.Width, img.Height = info.URL, info.Width, info.Height
+		}
+		if img.URL != "" {
+			found = append(found, ranked{page.Index, img})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].index < found[j].index })
+	list := make([]ImageResult, len(found))
+	for i, r := range found {
+		list[i] = r.img
+	}
+	return list, nil
+}
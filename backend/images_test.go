@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageStubServer 模擬三個來源的 API；query 含 "none" 時查無結果，含 "fail" 時回傳 429。
+// downloads 記錄 Unsplash download_location 被呼叫的次數
+func imageStubServer(t *testing.T) (srv *httptest.Server, downloads *atomic.Int32) {
+	t.Helper()
+	downloads = &atomic.Int32{}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("query") + r.URL.Query().Get("gsrsearch")
+		if strings.Contains(q, "fail") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		empty := strings.Contains(q, "none")
+		switch r.URL.Path {
+		case "/search/photos":
+			if r.Header.Get("Authorization") != "Client-ID test-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if empty {
+				w.Write([]byte(`{"results":[]}`))
+				return
+			}
+			w.Write([]byte(`{"results":[{"id":"abc","width":4000,"height":3000,"blur_hash":"LEHV6nWB2yk8pyo0adR*.7kCMdnj",
+				"urls":{"regular":"https://images.unsplash.com/photo-1?w=1080","small":"https://images.unsplash.com/photo-1?w=400"},
+				"links":{"html":"https://unsplash.com/photos/abc","download_location":"http://` + r.Host + `/photos/abc/download?ixid=x"},
+				"user":{"name":"Taro Yamada","links":{"html":"https://unsplash.com/@taro"}}}]}`))
+		case "/photos/abc/download":
+			// 由快取載回的圖片沒有 download_location，會直接呼叫 /photos/:id/download (不帶 ixid)
+			if ixid := r.URL.Query().Get("ixid"); r.Header.Get("Authorization") != "Client-ID test-key" || (ixid != "" && ixid != "x") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			downloads.Add(1)
+			w.Write([]byte(`{"url":"https://images.unsplash.com/photo-1"}`))
+		case "/v1/search":
+			if empty {
+				w.Write([]byte(`{"photos":[]}`))
+				return
+			}
+			w.Write([]byte(`{"photos":[{"width":1920,"height":1280,"url":"https://www.pexels.com/photo/1/",
+				"photographer":"Ana","photographer_url":"https://www.pexels.com/@ana",
+				"src":{"large":"https://images.pexels.com/1-large.jpg"}}]}`))
+		case "/w/api.php":
+			if r.Header.Get("User-Agent") != "trip-planner-test" || r.URL.Query().Get("gsrnamespace") != "6" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if empty {
+				w.Write([]byte(`{"batchcomplete":""}`))
+				return
+			}
+			w.Write([]byte(`{"query":{"pages":{
+				"2":{"index":2,"imageinfo":[{"url":"https://upload.wikimedia.org/b.jpg","thumburl":"https://upload.wikimedia.org/thumb/b.jpg"}]},
+				"1":{"index":1,"imageinfo":[{"url":"https://upload.wikimedia.org/a.jpg","width":6000,"height":4000,
+					"thumburl":"https://upload.wikimedia.org/thumb/a.jpg","thumbwidth":1280,"thumbheight":853,
+					"descriptionurl":"https://commons.wikimedia.org/wiki/File:A.jpg",
+					"extmetadata":{"Artist":{"value":"<a href=\"//commons.wikimedia.org/wiki/User:Kim\">Kim</a>"},"LicenseShortName":{"value":"CC BY-SA 4.0"}}}]}}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, downloads
+}
+
+func TestImageProviders(t *testing.T) {
+	srv, _ := imageStubServer(t)
+	ctx := context.Background()
+
+	img, err := newUnsplashProvider(srv.URL, "test-key").Search(ctx, "東京鐵塔")
+	if err != nil || img == nil {
+		t.Fatalf("unsplash = %v, %v", img, err)
+	}
+	if img.ID != "abc" || img.URL != "https://images.unsplash.com/photo-1?w=1080" || img.URLs["small"] == "" || img.Width != 4000 || img.BlurHash == "" || img.License != "Unsplash License" {
+		t.Errorf("unsplash = %+v", img)
+	}
+	// 出處連結要帶 utm 參數
+	if img.Author != "Taro Yamada" || img.AuthorURL != "https://unsplash.com/@taro?utm_medium=referral&utm_source=trip_planner" ||
+		img.SourceURL != "https://unsplash.com/photos/abc?utm_medium=referral&utm_source=trip_planner" || !strings.HasPrefix(img.ProviderURL, "https://unsplash.com/?utm_") {
+		t.Errorf("unsplash attribution = %+v", img)
+	}
+	if data, _ := json.Marshal(img); strings.Contains(string(data), "download") {
+		t.Errorf("download_location should not be serialized: %s", data)
+	}
+
+	img, err = newPexelsProvider(srv.URL, "k").Search(ctx, "東京鐵塔")
+	if err != nil || img == nil || img.URL != "https://images.pexels.com/1-large.jpg" || img.Author != "Ana" || img.License != "Pexels License" {
+		t.Errorf("pexels = %+v, %v", img, err)
+	}
+
+	img, err = newWikimediaProvider(srv.URL, "trip-planner-test").Search(ctx, "東京鐵塔")
+	if err != nil || img == nil {
+		t.Fatalf("wikimedia = %v, %v", img, err)
+	}
+	if img.URL != "https://upload.wikimedia.org/thumb/a.jpg" || img.Width != 1280 || img.Height != 853 || img.Author != "Kim" || img.License != "CC BY-SA 4.0" || img.SourceURL != "https://commons.wikimedia.org/wiki/File:A.jpg" {
+		t.Errorf("wikimedia = %+v", img)
+	}
+
+	for _, p := range []ImageProvider{newUnsplashProvider(srv.URL, "test-key"), newPexelsProvider(srv.URL, "k"), newWikimediaProvider(srv.URL, "trip-planner-test")} {
+		if img, err := p.Search(ctx, "none"); img != nil || err != nil {
+			t.Errorf("%s: empty result = %+v, %v", p.Name(), img, err)
+		}
+	}
+	if _, err := newUnsplashProvider(srv.URL, "wrong").Search(ctx, "東京"); err == nil {
+		t.Error("unsplash: expected auth error")
+	}
+}
+
+// stubImageProvider 固定回傳某個結果
+type stubImageProvider struct {
+	name string
+	img  *ImageResult
+	err  error
+}
+
+func (p stubImageProvider) Name() string { return p.name }
+func (p stubImageProvider) Search(ctx context.Context, query string) (*ImageResult, error) {
+	return p.img, p.err
+}
+
+func TestSearchImageOrder(t *testing.T) {
+	ctx := context.Background()
+	found := &ImageResult{URL: "https://example.com/a.jpg"}
+	failing := stubImageProvider{name: "a", err: context.DeadlineExceeded}
+	empty := stubImageProvider{name: "b"}
+
+	img, err := searchImage(ctx, []ImageProvider{failing, empty, stubImageProvider{name: "c", img: found}}, "x")
+	if err != nil || img == nil || img.Provider != "c" {
+		t.Errorf("fallback = %+v, %v", img, err)
+	}
+
+	// 全部沒有結果才算查無結果；有來源出錯時回傳錯誤 (不會被當成查無結果快取)
+	if img, err := searchImage(ctx, []ImageProvider{empty}, "x"); img != nil || err != nil {
+		t.Errorf("empty = %+v, %v", img, err)
+	}
+	if _, err := searchImage(ctx, []ImageProvider{failing, empty}, "x"); err == nil || !strings.Contains(err.Error(), "a:") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestUnsplashDownloadTracking(t *testing.T) {
+	srv, downloads := imageStubServer(t)
+	ctx := context.Background()
+	p := newUnsplashProvider(srv.URL, "test-key")
+	path := filepath.Join(t.TempDir(), "unsplash_downloads.json")
+	store := newFileCacheStore(path)
+	old := unsplashDownloads
+	unsplashDownloads = newUnsplashDownloads(store)
+	defer func() { unsplashDownloads = old }()
+
+	img, _ := p.Search(ctx, "東京鐵塔")
+	for i := 0; i < 3; i++ {
+		if err := p.trackDownload(ctx, *img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if downloads.Load() != 1 {
+		t.Errorf("downloads = %d, want 1", downloads.Load())
+	}
+
+	// 重新啟動後仍記得已通知過；沒有 download_location 的圖片也不會再通知
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	unsplashDownloads = newUnsplashDownloads(newFileCacheStore(path))
+	if n, err := unsplashDownloads.Restore(ctx); err != nil || n != 1 {
+		t.Fatalf("restored %d, %v", n, err)
+	}
+	restored := *img
+	restored.DownloadLocation = ""
+	if err := p.trackDownload(ctx, restored); err != nil || downloads.Load() != 1 {
+		t.Errorf("after restart: err=%v downloads=%d", err, downloads.Load())
+	}
+
+	// 沒有 download_location 時改用 /photos/:id/download
+	unsplashDownloads = newUnsplashDownloads(nil)
+	if err := p.trackDownload(ctx, restored); err != nil || downloads.Load() != 2 {
+		t.Errorf("fallback: err=%v downloads=%d", err, downloads.Load())
+	}
+
+	// 失敗時不記錄，下次使用再試
+	bad := newUnsplashProvider(srv.URL, "wrong-key")
+	broken := *img
+	broken.ID = "other"
+	if err := bad.trackDownload(ctx, broken); err == nil {
+		t.Error("expected error")
+	}
+	if _, _, ok := unsplashDownloads.Get("other"); ok {
+		t.Error("failed tracking should not be cached")
+	}
+}
+
+func TestImageSearchHandler(t *testing.T) {
+	srv, downloads := imageStubServer(t)
+	old, oldCache := imageProviders, unsplashCache
+	imageProviders = []ImageProvider{newUnsplashProvider(srv.URL, "test-key"), newWikimediaProvider(srv.URL, "trip-planner-test")}
+	unsplashCache = newUnsplashCache(nil)
+	oldImages := imageCache
+	imageCache, _ = newImageStore(t.TempDir(), 1<<20)
+	defer func() { imageProviders, unsplashCache, imageCache = old, oldCache, oldImages }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/images/search", imageSearchHandler)
+
+	get := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/images/search?query="+query, nil))
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	if code, body := get("tokyo"); code != 200 || body["provider"] != "unsplash" || body["author"] != "Taro Yamada" || body["id"] != "abc" ||
+		body["proxy_url"] != "/api/images/"+imageProxyID("https://images.unsplash.com/photo-1?w=1080") {
+		t.Errorf("tokyo = %d %v", code, body)
+	}
+	if code, body := get("none"); code != 200 || body["url"] != "" {
+		t.Errorf("none = %d %v", code, body)
+	}
+	if code, _ := get("fail"); code != 502 {
+		t.Errorf("fail = %d", code)
+	}
+	if code, _ := get(""); code != 400 {
+		t.Errorf("missing query = %d", code)
+	}
+	get("tokyo")
+	if s := unsplashCache.Stats(); s.Hits != 1 || s.Misses != 3 || s.LoadErrors != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+	// 只是顯示搜尋結果，不通知 Unsplash (選定圖片時才通知)
+	if downloads.Load() != 0 {
+		t.Errorf("downloads = %d, want 0", downloads.Load())
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========== Gemini Markdown 行程解析 ==========
+//
+// Gemini 回傳的行程大致長這樣 (參考 data/response.json)：
+//
+//	**Day1:** 皇居風華
+//	**地點1:** 上野公園 (Ueno Park)
+//	**概述:** ...
+//	**交通方式:** 地鐵/步行
+//
+// 或是 chat.html 規劃 prompt 要求的時段格式：
+//
+//	**午餐(12:00-13:30)**
+//	景點: 一蘭拉麵
+//
+// 解析器逐行掃描，能辨識的內容轉成 Day / Item，其餘記錄在 Unparsed。
+
+// ParseResult 解析結果
+type ParseResult struct {
+	Days     []Day              `json:"days"`
+	Unparsed []UnparsedFragment `json:"unparsed"`
+}
+
+// UnparsedFragment 無法歸類到任何 Item 的片段
+type UnparsedFragment struct {
+	Line   int    `json:"line"`   // 起始行號 (1-based)
+	Text   string `json:"text"`   // 原始文字
+	Reason string `json:"reason"` // json / code / orphan / trailing
+}
+
+var (
+	reDayHeading = regexp.MustCompile(`(?i)^(?:day\s*(\d+)|第\s*([0-9一二三四五六七八九十]+)\s*天)\s*[:：]?\s*(.*)$`)
+	rePlaceLine  = regexp.MustCompile(`(?i)^(?:地點|景點|attraction|place)\s*\d*\s*[:：]\s*(.*)$`)
+	reTimeSlot   = regexp.MustCompile(`^(上午|下午|中午|午餐|晚餐|早餐|早上|清晨|傍晚|晚上|夜間|全天|全日)\s*(?:[\(（]([^)）]*)[)）]\s*[:：]?|[:：]|$)\s*(.*)$`)
+	reFieldLine  = regexp.MustCompile(`^([^:：]{1,10})[:：]\s*(.*)$`)
+	reBullet     = regexp.MustCompile(`^(?:[*\-•]\s+|\d+[.)]\s+)+`)
+	reHasDigit   = regexp.MustCompile(`\d`)
+)
+
+// 欄位標籤 → Item 欄位
+var itineraryFieldLabels = map[string]string{
+	"概述":          "note",
+	"說明":          "note",
+	"介紹":          "note",
+	"描述":          "note",
+	"簡介":          "note",
+	"overview":    "note",
+	"description": "note",
+	"交通方式":        "transport",
+	"交通":          "transport",
+	"transport":   "transport",
+	"地址":          "address",
+	"address":     "address",
+	"時間":          "time",
+	"time":        "time",
+	"餐飲":          "extra",
+	"建議餐飲":        "extra",
+	"住宿":          "extra",
+	"備註":          "extra",
+	"注意":          "extra",
+}
+
+type itineraryParser struct {
+	days       []Day
+	unparsed   []UnparsedFragment
+	day        *Day
+	item       *Item
+	slotMode   bool // 這一天以時段行 (上午/午餐...) 分段
+	itemSlot   bool // 目前的 item 是否由時段行建立
+	itemPlaced bool // 目前的 item 是否已有明確的地點
+	trailing   bool // 最後一天之後的總結段落
+	fragStart  int
+	frag       []string
+	fragWhy    string
+}
+
+// ParseItinerary 將 Gemini 的 Markdown 行程轉成 []Day。
+// startDate (YYYY-MM-DD) 可為空；有值時會依 day_index 填入日期。
+func ParseItinerary(text, startDate string) ParseResult {
+	p := &itineraryParser{}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	inCode := false
+	jsonDepth := 0
+
+	for i := 0; i < len(lines); i++ {
+		raw := strings.TrimSpace(lines[i])
+		lineNo := i + 1
+
+		// ``` 程式碼區塊整段視為無法解析
+		if strings.HasPrefix(raw, "```") {
+			if inCode {
+				p.addFragment(lineNo, raw, "code")
+				p.flushFragment()
+			} else {
+				p.flushFragment()
+				p.addFragment(lineNo, raw, "code")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			p.addFragment(lineNo, raw, "code")
+			continue
+		}
+
+		// 模型偶爾夾帶 JSON 物件
+		if jsonDepth > 0 || strings.HasPrefix(raw, "{") || raw == "[" || strings.HasPrefix(raw, "[{") {
+			if jsonDepth == 0 {
+				p.flushFragment()
+			}
+			jsonDepth += strings.Count(raw, "{") + strings.Count(raw, "[") - strings.Count(raw, "}") - strings.Count(raw, "]")
+			p.addFragment(lineNo, raw, "json")
+			if jsonDepth <= 0 {
+				jsonDepth = 0
+				// 相鄰的 "}," 與 "{" 合併為同一段
+				if next := nextNonEmpty(lines, i+1); !strings.HasPrefix(next, "{") {
+					p.flushFragment()
+				}
+			}
+			continue
+		}
+
+		if raw == "" || isSeparator(raw) {
+			continue
+		}
+
+		norm := normalizeItineraryLine(raw)
+		if norm == "" {
+			continue
+		}
+
+		if m := reDayHeading.FindStringSubmatch(norm); m != nil {
+			p.flushFragment()
+			p.startDay(m)
+			continue
+		}
+
+		if p.trailing {
+			p.addFragment(lineNo, raw, "trailing")
+			continue
+		}
+
+		// 第一個 Day 之前是開場白與總覽，不列入解析
+		if p.day == nil {
+			continue
+		}
+
+		if m := rePlaceLine.FindStringSubmatch(norm); m != nil {
+			p.flushFragment()
+			title := strings.TrimSpace(m[1])
+			if title == "" {
+				continue
+			}
+			// 時段底下的第一個景點就是該時段的標題
+			if p.item != nil && p.itemSlot && !p.itemPlaced {
+				if p.item.Title != "" && !strings.Contains(p.item.Note, p.item.Title) {
+					appendNote(p.item, p.item.Title)
+				}
+				p.item.Title = title
+				p.itemPlaced = true
+				continue
+			}
+			p.newItem(title, false)
+			p.itemPlaced = true
+			continue
+		}
+
+		if m := reTimeSlot.FindStringSubmatch(norm); m != nil {
+			p.flushFragment()
+			// 以地點為主的一天，"上午: ..." 只是地點的補充說明
+			if p.item != nil && !p.slotMode {
+				appendNote(p.item, norm)
+				continue
+			}
+			p.startSlot(m[1], strings.TrimSpace(m[2]), strings.TrimSpace(m[3]))
+			continue
+		}
+
+		if m := reFieldLine.FindStringSubmatch(norm); m != nil {
+			if kind, ok := itineraryFieldLabels[strings.ToLower(strings.TrimSpace(m[1]))]; ok {
+				p.flushFragment()
+				if p.item == nil {
+					p.newItem("", false)
+				}
+				p.applyField(kind, strings.TrimSpace(m[1]), strings.TrimSpace(m[2]))
+				continue
+			}
+		}
+
+		// 總結段落 (例如 "**預算規劃提醒:**") 代表行程已結束
+		if isSectionHeading(raw) {
+			p.closeDay()
+			p.trailing = true
+			p.addFragment(lineNo, raw, "trailing")
+			continue
+		}
+
+		// 沒有標籤的地點名稱，下一行緊接著 "概述:"
+		if isOverviewLine(nextNonEmpty(lines, i+1)) {
+			p.flushFragment()
+			p.newItem(norm, false)
+			continue
+		}
+
+		if p.item != nil {
+			appendNote(p.item, norm)
+			continue
+		}
+		p.addFragment(lineNo, raw, "orphan")
+	}
+
+	p.flushFragment()
+	p.closeDay()
+
+	return ParseResult{
+		Days:     finalizeDays(p.days, startDate),
+		Unparsed: p.unparsed,
+	}
+}
+
+func (p *itineraryParser) startDay(m []string) {
+	p.closeDay()
+	p.trailing = false
+
+	n := 0
+	if m[1] != "" {
+		n, _ = strconv.Atoi(m[1])
+	} else {
+		n = parseChineseNumber(m[2])
+	}
+
+	// 同一天重複出現時合併
+	for i := range p.days {
+		if p.days[i].DayIndex == n {
+			d := p.days[i]
+			p.days = append(p.days[:i], p.days[i+1:]...)
+			p.day = &d
+			return
+		}
+	}
+
+	note := strings.Trim(strings.TrimSpace(m[3]), "()（）")
+	p.day = &Day{DayIndex: n, Note: note, Items: []Item{}}
+	p.slotMode = false
+}
+
+func (p *itineraryParser) closeDay() {
+	p.closeItem()
+	if p.day != nil {
+		p.days = append(p.days, *p.day)
+		p.day = nil
+	}
+}
+
+func (p *itineraryParser) newItem(title string, slot bool) {
+	p.closeItem()
+	if p.day != nil && len(p.day.Items) == 0 {
+		p.slotMode = slot
+	}
+	p.item = &Item{Title: title}
+	p.itemSlot = slot
+}
+
+func (p *itineraryParser) closeItem() {
+	if p.item != nil && p.day != nil {
+		if p.item.Title != "" || p.item.Note != "" {
+			p.day.Items = append(p.day.Items, *p.item)
+		}
+	}
+	p.item = nil
+	p.itemSlot = false
+	p.itemPlaced = false
+}
+
+// startSlot 處理 "**午餐(12:00-13:30)**" 或 "上午: 參觀淺草寺" 這類時段行
+func (p *itineraryParser) startSlot(slot, paren, rest string) {
+	p.newItem("", true)
+	p.item.Time = slot
+
+	switch {
+	case paren != "" && reHasDigit.MatchString(paren):
+		p.item.Time = paren
+	case paren != "":
+		p.item.Title = paren
+	}
+
+	if rest != "" {
+		if p.item.Title == "" {
+			p.item.Title = firstClause(rest)
+		}
+		if rest != p.item.Title {
+			appendNote(p.item, rest)
+		}
+	}
+}
+
+func (p *itineraryParser) applyField(kind, label, value string) {
+	switch kind {
+	case "address":
+		p.item.Address = value
+	case "time":
+		p.item.Time = value
+	case "transport":
+		if value != "" {
+			appendNote(p.item, "交通："+value)
+		} else {
+			appendNote(p.item, "交通：")
+		}
+	case "extra":
+		appendNote(p.item, label+"："+value)
+	default:
+		if value != "" {
+			appendNote(p.item, value)
+		}
+	}
+}
+
+func (p *itineraryParser) addFragment(lineNo int, text, reason string) {
+	if p.frag != nil && p.fragWhy != reason {
+		p.flushFragment()
+	}
+	if p.frag == nil {
+		p.fragStart = lineNo
+		p.fragWhy = reason
+	}
+	p.frag = append(p.frag, text)
+}
+
+func (p *itineraryParser) flushFragment() {
+	if p.frag == nil {
+		return
+	}
+	p.unparsed = append(p.unparsed, UnparsedFragment{
+		Line:   p.fragStart,
+		Text:   strings.Join(p.frag, "\n"),
+		Reason: p.fragWhy,
+	})
+	p.frag = nil
+	p.fragWhy = ""
+}
+
+// finalizeDays 排序天數、補上 ID 與日期
+func finalizeDays(days []Day, startDate string) []Day {
+	if len(days) == 0 {
+		return []Day{}
+	}
+
+	// 模型常從 Day0 (出發日) 開始編號，對齊 expandDays 的 1-based day_index
+	shift := 0
+	for _, d := range days {
+		if d.DayIndex == 0 {
+			shift = 1
+			break
+		}
+	}
+
+	start, err := time.Parse("2006-01-02", startDate)
+	hasStart := err == nil
+
+	out := make([]Day, 0, len(days))
+	for _, d := range days {
+		d.DayIndex += shift
+		if hasStart {
+			d.Date = start.AddDate(0, 0, d.DayIndex-1).Format("2006-01-02")
+		}
+		for j := range d.Items {
+			d.Items[j].ID = fmt.Sprintf("d%d-%d", d.DayIndex, j+1)
+		}
+		out = append(out, d)
+	}
+
+	// 依 day_index 排序 (插入排序，天數很少)
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && out[j].DayIndex < out[j-1].DayIndex; j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	return out
+}
+
+// normalizeItineraryLine 去掉 Markdown 標記：標題井號、項目符號、粗體
+func normalizeItineraryLine(raw string) string {
+	s := strings.TrimLeft(raw, "# ")
+	s = reBullet.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "**", "")
+	s = strings.ReplaceAll(s, "__", "")
+	s = reBullet.ReplaceAllString(strings.TrimSpace(s), "")
+	return strings.TrimSpace(s)
+}
+
+func isSeparator(raw string) bool {
+	return strings.Trim(raw, "-*_= ") == ""
+}
+
+// isSectionHeading 判斷是否為獨立的標題行 (不含項目符號、整行粗體或以 # 開頭)
+func isSectionHeading(raw string) bool {
+	if strings.HasPrefix(raw, "#") {
+		return true
+	}
+	if !strings.HasPrefix(raw, "**") {
+		return false
+	}
+	s := strings.TrimRight(raw, ":： ")
+	return strings.HasSuffix(s, "**") && strings.Count(s, "**") == 2
+}
+
+func isOverviewLine(raw string) bool {
+	m := reFieldLine.FindStringSubmatch(normalizeItineraryLine(raw))
+	if m == nil {
+		return false
+	}
+	return itineraryFieldLabels[strings.ToLower(strings.TrimSpace(m[1]))] == "note"
+}
+
+func nextNonEmpty(lines []string, from int) string {
+	for i := from; i < len(lines); i++ {
+		if s := strings.TrimSpace(lines[i]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func appendNote(item *Item, s string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	if item.Note == "" {
+		item.Note = s
+		return
+	}
+	item.Note += "\n" + s
+}
+
+// firstClause 取第一個逗號/句號前的文字當標題
+func firstClause(s string) string {
+	if i := strings.IndexAny(s, "，。,;；"); i > 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// parseChineseNumber 處理 "三"、"十二"、"二十" 這類天數
+func parseChineseNumber(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	digits := map[rune]int{'一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	n, cur := 0, 0
+	for _, r := range s {
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+			continue
+		}
+		cur = digits[r]
+	}
+	return n + cur
+}
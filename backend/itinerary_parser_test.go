@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "重新產生 testdata 下的 golden 檔")
+
+// testdata/itinerary/*.md 多數取自 data/ 中實際的 Gemini 回覆：
+// tokyo_heading_days.md 來自 data/_____1763808717805.json，其餘來自 data/response.json；
+// time_slots.md 是手寫的範例 (錄到的回覆都沒有用時段當標題)，用來涵蓋「上午/下午」分段
+func TestParseItineraryGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "itinerary", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no testdata found")
+	}
+
+	for _, in := range inputs {
+		name := strings.TrimSuffix(filepath.Base(in), ".md")
+		t.Run(name, func(t *testing.T) {
+			text, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(ParseItinerary(string(text), "2025-04-22"), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(in, ".md") + ".golden.json"
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden: %v (run go test -update)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s mismatch; run `go test -run TestParseItineraryGolden -update` and review the diff", golden)
+			}
+		})
+	}
+}
+
+func TestParseItineraryTimeSlots(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("testdata", "itinerary", "time_slots.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := ParseItinerary(string(text), "2025-04-22")
+
+	if len(res.Days) != 2 {
+		t.Fatalf("days = %d, want 2", len(res.Days))
+	}
+	day1 := res.Days[0]
+	if day1.DayIndex != 1 || day1.Date != "2025-04-22" || day1.Note != "東山散策" {
+		t.Errorf("day1 = %+v", day1)
+	}
+	if len(day1.Items) != 4 {
+		t.Fatalf("day1 items = %d, want 4", len(day1.Items))
+	}
+
+	first := day1.Items[0]
+	if first.Time != "09:00-12:00" || first.Title != "Kiyomizu-dera" || first.Address != "京都市東山區清水1丁目294" {
+		t.Errorf("first item = %+v", first)
+	}
+	if day1.Items[3].Title != "Yasaka Shrine" {
+		t.Errorf("second place in slot should become its own item, got %+v", day1.Items[3])
+	}
+
+	last := res.Days[1].Items[1]
+	if last.Time != "晚餐" || last.Title != "先斗町的串炸" {
+		t.Errorf("dinner item = %+v", last)
+	}
+
+	reasons := map[string]bool{}
+	for _, f := range res.Unparsed {
+		reasons[f.Reason] = true
+	}
+	if !reasons["code"] || !reasons["trailing"] {
+		t.Errorf("unparsed reasons = %v, want code and trailing", reasons)
+	}
+}
+
+func TestParseItineraryDayZeroShift(t *testing.T) {
+	res := ParseItinerary("Day0:\n地點1: 桃園機場\nDay1:\n地點1: 台北101", "")
+	if len(res.Days) != 2 || res.Days[0].DayIndex != 1 || res.Days[1].DayIndex != 2 {
+		t.Fatalf("days = %+v", res.Days)
+	}
+	if res.Days[1].Items[0].ID != "d2-1" {
+		t.Errorf("item id = %q", res.Days[1].Items[0].ID)
+	}
+}
+
+func TestParseChineseNumber(t *testing.T) {
+	cases := map[string]int{"三": 3, "十": 10, "十二": 12, "二十": 20, "二十一": 21, "7": 7}
+	for in, want := range cases {
+		if got := parseChineseNumber(in); got != want {
+			t.Errorf("parseChineseNumber(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
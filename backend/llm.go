@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ========== LLM Provider 抽象 ==========
+//
+// 所有模型呼叫都透過 LLMProvider，啟動時依環境變數建立一次：
+//
+//	LLM_PROVIDER=gemini            預設 provider (gemini / openai / fake)
+//	LLM_PROVIDER_CHAT=openai       個別任務覆寫 provider
+//	LLM_MODEL_IATA=gemini-2.0-flash 個別任務覆寫模型
+//
+// openai 指任何 OpenAI 相容的 /chat/completions 端點 (OpenAI、Ollama、llama.cpp server)。
+
+// LLM 任務名稱，用於選擇 provider 與模型
+const (
+	taskChat     = "chat"
+	taskGenerate = "generate"
+	taskIATA     = "iata"
+	taskVision   = "vision" // 圖片辨識，需要支援多模態的模型
+)
+
+// LLMProvider 各家模型的共同介面
+type LLMProvider interface {
+	Name() string
+	// Generate 單次問答，忽略 History
+	Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+	// Chat 帶歷史紀錄的對話
+	Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+	// Stream 與 Chat 相同，但每收到一段文字就呼叫 onChunk
+	Stream(ctx context.Context, req LLMRequest, onChunk func(text string)) (*LLMResponse, error)
+	// Structured 要求模型依 schema 輸出 JSON，回傳的 Text 為 JSON 字串
+	Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error)
+	// ChatWithTools 帶工具的對話：模型要求呼叫工具時以 call 執行並把結果送回，
+	// 直到模型給出文字回覆為止 (最多 llmMaxToolRounds 輪)
+	ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error)
+}
+
+// 一次 ChatWithTools 最多來回幾輪工具呼叫，避免模型無限呼叫
+const llmMaxToolRounds = 8
+
+// LLMTool 提供給模型呼叫的函式
+type LLMTool struct {
+	Name        string
+	Description string
+	Parameters  *JSONSchema // nil 表示沒有參數
+}
+
+// LLMToolCall 模型要求的一次工具呼叫
+type LLMToolCall struct {
+	Name string
+	Args map[string]any
+}
+
+// LLMToolFunc 執行工具並回傳要送回模型的結果；回傳 error 時會以 {"error": ...} 告知模型
+type LLMToolFunc func(ctx context.Context, call LLMToolCall) (map[string]any, error)
+
+// toolResult 將 LLMToolFunc 的結果轉成送回模型的內容
+func toolResult(ctx context.Context, call LLMToolFunc, tc LLMToolCall) map[string]any {
+	out, err := call(ctx, tc)
+	if err != nil {
+		return map[string]any{"error": err.Error()}
+	}
+	if out == nil {
+		out = map[string]any{}
+	}
+	return out
+}
+
+// errTooManyToolRounds 模型超過 llmMaxToolRounds 仍未給出回覆
+var errTooManyToolRounds = fmt.Errorf("model did not finish after %d tool rounds", llmMaxToolRounds)
+
+// LLMRequest 一次模型呼叫的輸入
+type LLMRequest struct {
+	Model       string     // 空字串使用任務或 provider 的預設模型
+	System      string     // system instruction
+	History     []ChatPart // 過去的對話 (Generate 會忽略)
+	Prompt      string     // 這次的使用者訊息
+	Images      []LLMImage // 附在這次訊息的圖片 (可選)
+	Temperature *float32   // nil 表示使用 provider 預設值
+	MaxTokens   int32      // 0 表示使用 provider 預設值
+}
+
+// LLMImage 以 inline 方式送給模型的圖片
+type LLMImage struct {
+	MIMEType string // image/jpeg / image/png / image/webp
+	Data     []byte
+}
+
+// LLMResponse 模型回覆
+type LLMResponse struct {
+	Text         string
+	FinishReason string
+	Model        string
+	Usage        LLMUsage
+}
+
+// LLMUsage token 用量
+type LLMUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// JSONSchema 與 provider 無關的結構化輸出 schema (OpenAPI 子集)
+type JSONSchema struct {
+	Type        string                 `json:"type"` // object / array / string / integer / number / boolean
+	Description string                 `json:"description,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+}
+
+// float32Ptr 方便設定 LLMRequest.Temperature
+func float32Ptr(v float32) *float32 {
+	return &v
+}
+
+// ========== Provider 設定 ==========
+
+type llmTask struct {
+	provider LLMProvider
+	model    string
+}
+
+var (
+	llmProviders = map[string]LLMProvider{}
+	llmTasks     = map[string]llmTask{}
+)
+
+// initLLM 依環境變數建立所有 provider，並決定每個任務使用哪一個
+func initLLM() {
+	if key := os.Getenv("GEMINI_API_KEY"); key != "" {
+		p, err := newGeminiProvider(context.Background(), key, envOr("GEMINI_MODEL", "gemini-2.5-flash-lite"))
+		if err != nil {
+			log.Printf("Gemini provider 初始化失敗: %v", err)
+		} else {
+			llmProviders[p.Name()] = p
+		}
+	}
+	if base := os.Getenv("OPENAI_BASE_URL"); base != "" || os.Getenv("OPENAI_API_KEY") != "" {
+		p := newOpenAIProvider(envOr("OPENAI_BASE_URL", "https://api.openai.com/v1"), os.Getenv("OPENAI_API_KEY"), envOr("OPENAI_MODEL", "gpt-4o-mini"))
+		llmProviders[p.Name()] = p
+	}
+	fake := newFakeProvider()
+	llmProviders[fake.Name()] = fake
+
+	defaultName := envOr("LLM_PROVIDER", "gemini")
+	for _, task := range []string{taskChat, taskGenerate, taskIATA, taskVision} {
+		name := envOr("LLM_PROVIDER_"+strings.ToUpper(task), defaultName)
+		p, ok := llmProviders[name]
+		if !ok {
+			log.Printf("LLM 任務 %s 使用的 provider %q 未設定", task, name)
+			continue
+		}
+		llmTasks[task] = llmTask{provider: p, model: os.Getenv("LLM_MODEL_" + strings.ToUpper(task))}
+		log.Printf("LLM 任務 %s -> %s", task, name)
+	}
+}
+
+// llmFor 取得任務對應的 provider；任務有指定模型時會自動帶入，並記錄每次呼叫的用量
+func llmFor(task string) (LLMProvider, error) {
+	t, ok := llmTasks[task]
+	if !ok {
+		return nil, fmt.Errorf("LLM provider for %q is not configured", task)
+	}
+	p := t.provider
+	if t.model != "" {
+		p = taskModelProvider{LLMProvider: p, model: t.model}
+	}
+	p = newResilientProvider(p, retryPolicyFor(task))
+	return usageProvider{LLMProvider: p, task: task}, nil
+}
+
+// taskModelProvider 在呼叫端沒有指定模型時套用任務的預設模型
+type taskModelProvider struct {
+	LLMProvider
+	model string
+}
+
+func (p taskModelProvider) withModel(req LLMRequest) LLMRequest {
+	if req.Model == "" {
+		req.Model = p.model
+	}
+	return req
+}
+
+func (p taskModelProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.LLMProvider.Generate(ctx, p.withModel(req))
+}
+
+func (p taskModelProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.LLMProvider.Chat(ctx, p.withModel(req))
+}
+
+func (p taskModelProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	return p.LLMProvider.Stream(ctx, p.withModel(req), onChunk)
+}
+
+func (p taskModelProvider) Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error) {
+	return p.LLMProvider.Structured(ctx, p.withModel(req), schema)
+}
+
+func (p taskModelProvider) ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error) {
+	return p.LLMProvider.ChatWithTools(ctx, p.withModel(req), tools, call)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// ========== Fake provider (測試 / 離線開發) ==========
+//
+// 回覆完全可預期：依註冊的規則比對 prompt，沒有符合的規則時回傳
+// "[fake] <prompt>"；Structured 則依 schema 產生最小的合法 JSON。
+// 設定 LLM_PROVIDER=fake 即可在沒有 API key 的情況下跑完整流程。
+
+type fakeProvider struct {
+	mu        sync.Mutex
+	rules     []fakeRule
+	toolRules []fakeToolRule
+	calls     []LLMRequest
+}
+
+type fakeRule struct {
+	contains string
+	text     string
+}
+
+type fakeToolRule struct {
+	contains string
+	call     LLMToolCall
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{}
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+// On 註冊一條規則：prompt 包含 contains 時回覆 text (空字串代表任何 prompt)
+func (p *fakeProvider) On(contains, text string) *fakeProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(p.rules, fakeRule{contains: contains, text: text})
+	return p
+}
+
+// OnTool 註冊一條工具規則：prompt 包含 contains 時，ChatWithTools 會先依註冊順序呼叫工具再回覆
+func (p *fakeProvider) OnTool(contains, name string, args map[string]any) *fakeProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.toolRules = append(p.toolRules, fakeToolRule{contains: contains, call: LLMToolCall{Name: name, Args: args}})
+	return p
+}
+
+// Calls 回傳目前為止收到的所有請求
+func (p *fakeProvider) Calls() []LLMRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]LLMRequest(nil), p.calls...)
+}
+
+func (p *fakeProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	req.History = nil
+	return p.Chat(ctx, req)
+}
+
+func (p *fakeProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	text, ok := p.match(req)
+	if !ok {
+		text = "[fake] " + req.Prompt
+	}
+	return p.response(req, text), nil
+}
+
+func (p *fakeProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	res, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// 每 16 個字元切一段
+	runes := []rune(res.Text)
+	for i := 0; i < len(runes); i += 16 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := min(i+16, len(runes))
+		onChunk(string(runes[i:end]))
+	}
+	return res, nil
+}
+
+func (p *fakeProvider) Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	text, ok := p.match(req)
+	if !ok {
+		b, _ := json.Marshal(fakeValue(schema))
+		text = string(b)
+	}
+	return p.response(req, text), nil
+}
+
+func (p *fakeProvider) ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	var calls []LLMToolCall
+	for _, r := range p.toolRules {
+		if strings.Contains(req.Prompt, r.contains) {
+			calls = append(calls, r.call)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(calls) > llmMaxToolRounds {
+		return nil, errTooManyToolRounds
+	}
+	for _, tc := range calls {
+		toolResult(ctx, call, tc)
+	}
+	return p.Chat(ctx, req)
+}
+
+func (p *fakeProvider) match(req LLMRequest) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, req)
+	for _, r := range p.rules {
+		if strings.Contains(req.Prompt, r.contains) {
+			return r.text, true
+		}
+	}
+	return "", false
+}
+
+func (p *fakeProvider) response(req LLMRequest, text string) *LLMResponse {
+	model := req.Model
+	if model == "" {
+		model = "fake"
+	}
+	prompt := len([]rune(req.System)) + len([]rune(req.Prompt))
+	for _, h := range req.History {
+		prompt += len([]rune(h.Text))
+	}
+	return &LLMResponse{
+		Text:         text,
+		FinishReason: "STOP",
+		Model:        model,
+		Usage:        LLMUsage{PromptTokens: prompt, OutputTokens: len([]rune(text))},
+	}
+}
+
+// fakeValue 依 schema 產生最小的合法值
+func fakeValue(s *JSONSchema) any {
+	if s == nil {
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		obj := map[string]any{}
+		for _, k := range s.Required {
+			obj[k] = fakeValue(s.Properties[k])
+		}
+		return obj
+	case "array":
+		return []any{}
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	default:
+		if len(s.Enum) > 0 {
+			return s.Enum[0]
+		}
+		return ""
+	}
+}
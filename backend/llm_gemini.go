@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// ========== Gemini provider ==========
+
+type geminiProvider struct {
+	client       *genai.Client
+	defaultModel string
+}
+
+// newGeminiProvider 建立共用的 genai.Client，整個程式只建立一次
+func newGeminiProvider(ctx context.Context, apiKey, defaultModel string) (*geminiProvider, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+	return &geminiProvider{client: client, defaultModel: defaultModel}, nil
+}
+
+func (p *geminiProvider) Name() string { return "gemini" }
+
+func (p *geminiProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	req.History = nil
+	return p.Chat(ctx, req)
+}
+
+func (p *geminiProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	model, name := p.model(req)
+	cs := model.StartChat()
+	cs.History = toGenaiHistory(req.History)
+
+	res, err := cs.SendMessage(ctx, promptParts(req)...)
+	if err != nil {
+		return nil, err
+	}
+	return geminiResponse(res, name), nil
+}
+
+func (p *geminiProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	model, name := p.model(req)
+	cs := model.StartChat()
+	cs.History = toGenaiHistory(req.History)
+
+	iter := cs.SendMessageStream(ctx, promptParts(req)...)
+	for {
+		res, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunk := geminiText(res); chunk != "" {
+			onChunk(chunk)
+		}
+	}
+	return geminiResponse(iter.MergedResponse(), name), nil
+}
+
+func (p *geminiProvider) Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error) {
+	model, name := p.model(req)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = toGenaiSchema(schema)
+
+	cs := model.StartChat()
+	cs.History = toGenaiHistory(req.History)
+
+	res, err := cs.SendMessage(ctx, promptParts(req)...)
+	if err != nil {
+		return nil, err
+	}
+	return geminiResponse(res, name), nil
+}
+
+func (p *geminiProvider) ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error) {
+	model, name := p.model(req)
+	model.Tools = []*genai.Tool{{FunctionDeclarations: toGenaiFunctions(tools)}}
+
+	cs := model.StartChat()
+	cs.History = toGenaiHistory(req.History)
+
+	var usage LLMUsage
+	parts := promptParts(req)
+	for round := 0; round <= llmMaxToolRounds; round++ {
+		res, err := cs.SendMessage(ctx, parts...)
+		if err != nil {
+			return nil, err
+		}
+		out := geminiResponse(res, name)
+		usage.PromptTokens += out.Usage.PromptTokens
+		usage.OutputTokens += out.Usage.OutputTokens
+
+		var calls []genai.FunctionCall
+		if len(res.Candidates) > 0 {
+			calls = res.Candidates[0].FunctionCalls()
+		}
+		if len(calls) == 0 {
+			out.Usage = usage
+			return out, nil
+		}
+
+		// 同一輪的所有工具結果一起送回 (parts 已被放進 history，不能重複使用)
+		parts = nil
+		for _, fc := range calls {
+			parts = append(parts, genai.FunctionResponse{
+				Name:     fc.Name,
+				Response: toolResult(ctx, call, LLMToolCall{Name: fc.Name, Args: fc.Args}),
+			})
+		}
+	}
+	return nil, errTooManyToolRounds
+}
+
+// promptParts 這次訊息的文字與圖片
+func promptParts(req LLMRequest) []genai.Part {
+	parts := []genai.Part{genai.Text(req.Prompt)}
+	for _, img := range req.Images {
+		parts = append(parts, genai.Blob{MIMEType: img.MIMEType, Data: img.Data})
+	}
+	return parts
+}
+
+// model 依請求設定 GenerativeModel
+func (p *geminiProvider) model(req LLMRequest) (*genai.GenerativeModel, string) {
+	name := req.Model
+	if name == "" {
+		name = p.defaultModel
+	}
+	model := p.client.GenerativeModel(name)
+	if req.System != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
+	}
+	if req.Temperature != nil {
+		model.SetTemperature(*req.Temperature)
+	}
+	if req.MaxTokens > 0 {
+		model.SetMaxOutputTokens(req.MaxTokens)
+	}
+	return model, name
+}
+
+// toGenaiHistory 將前端的對話歷史轉成 Gemini 的 Content
+func toGenaiHistory(history []ChatPart) []*genai.Content {
+	var chatHistory []*genai.Content
+	for _, h := range history {
+		role := "user"
+		if h.Role == "model" || h.Role == "assistant" {
+			role = "model"
+		}
+		chatHistory = append(chatHistory, &genai.Content{
+			Role:  role,
+			Parts: []genai.Part{genai.Text(h.Text)},
+		})
+	}
+	return chatHistory
+}
+
+// toGenaiSchema 將 JSONSchema 轉成 genai.Schema
+func toGenaiSchema(s *JSONSchema) *genai.Schema {
+	if s == nil {
+		return nil
+	}
+	types := map[string]genai.Type{
+		"string":  genai.TypeString,
+		"number":  genai.TypeNumber,
+		"integer": genai.TypeInteger,
+		"boolean": genai.TypeBoolean,
+		"array":   genai.TypeArray,
+		"object":  genai.TypeObject,
+	}
+	out := &genai.Schema{
+		Type:        types[s.Type],
+		Description: s.Description,
+		Enum:        s.Enum,
+		Items:       toGenaiSchema(s.Items),
+		Required:    s.Required,
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for k, v := range s.Properties {
+			out.Properties[k] = toGenaiSchema(v)
+		}
+	}
+	return out
+}
+
+// toGenaiFunctions 將 LLMTool 轉成 Gemini 的 FunctionDeclaration
+func toGenaiFunctions(tools []LLMTool) []*genai.FunctionDeclaration {
+	var decls []*genai.FunctionDeclaration
+	for _, t := range tools {
+		decls = append(decls, &genai.FunctionDeclaration{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  toGenaiSchema(t.Parameters),
+		})
+	}
+	return decls
+}
+
+func geminiText(res *genai.GenerateContentResponse) string {
+	var text string
+	if res == nil || len(res.Candidates) == 0 || res.Candidates[0].Content == nil {
+		return text
+	}
+	for _, part := range res.Candidates[0].Content.Parts {
+		if txt, ok := part.(genai.Text); ok {
+			text += string(txt)
+		}
+	}
+	return text
+}
+
+func geminiResponse(res *genai.GenerateContentResponse, model string) *LLMResponse {
+	out := &LLMResponse{Text: geminiText(res), Model: model}
+	if res == nil {
+		return out
+	}
+	if len(res.Candidates) > 0 {
+		out.FinishReason = res.Candidates[0].FinishReason.String()
+	}
+	if res.UsageMetadata != nil {
+		out.Usage = LLMUsage{
+			PromptTokens: int(res.UsageMetadata.PromptTokenCount),
+			OutputTokens: int(res.UsageMetadata.CandidatesTokenCount),
+		}
+	}
+	return out
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========== OpenAI 相容 provider ==========
+//
+// 支援任何實作 POST /chat/completions 的伺服器，
+// 例如 OpenAI、Ollama (http://localhost:11434/v1)、llama.cpp server (http://localhost:8081/v1)。
+
+type openAIProvider struct {
+	baseURL      string
+	apiKey       string
+	defaultModel string
+	httpClient   *http.Client
+}
+
+func newOpenAIProvider(baseURL, apiKey, defaultModel string) *openAIProvider {
+	return &openAIProvider{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		apiKey:       apiKey,
+		defaultModel: defaultModel,
+		// 本地模型生成完整行程可能很久，這裡只設一個寬鬆的上限，實際期限由 ctx 控制
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (p *openAIProvider) Name() string { return "openai" }
+
+type openAIMessage struct {
+	Role       string              `json:"role"`
+	Content    string              `json:"content"`
+	Parts      []openAIContentPart `json:"-"` // 有圖片時改以 parts 送出 content
+	ToolCalls  []openAIToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string              `json:"tool_call_id,omitempty"`
+}
+
+// openAIContentPart 多模態訊息的一段內容 (text 或 image_url)
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON 有 Parts 時 content 送陣列，否則送字串
+func (m openAIMessage) MarshalJSON() ([]byte, error) {
+	type plain openAIMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []openAIContentPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON 字串
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description,omitempty"`
+		Parameters  *JSONSchema `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIRequest struct {
+	Model          string          `json:"model"`
+	Messages       []openAIMessage `json:"messages"`
+	Temperature    *float32        `json:"temperature,omitempty"`
+	MaxTokens      int32           `json:"max_tokens,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	ResponseFormat any             `json:"response_format,omitempty"`
+	Tools          []openAITool    `json:"tools,omitempty"`
+}
+
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIMessage `json:"message"`
+		Delta        openAIMessage `json:"delta"`
+		FinishReason *string       `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func (p *openAIProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	req.History = nil
+	return p.Chat(ctx, req)
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.complete(ctx, p.request(req))
+}
+
+func (p *openAIProvider) Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error) {
+	body := p.request(req)
+	body.ResponseFormat = map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   "response",
+			"schema": schema,
+		},
+	}
+	return p.complete(ctx, body)
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	body := p.request(req)
+	body.Stream = true
+
+	resp, err := p.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := &LLMResponse{Model: body.Model}
+	var full strings.Builder
+
+	// 回應格式為 SSE：每行 "data: {...}"，最後是 "data: [DONE]"
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if chunk.Model != "" {
+			out.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			out.Usage = LLMUsage{PromptTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if fr := chunk.Choices[0].FinishReason; fr != nil {
+			out.FinishReason = *fr
+		}
+		if text := chunk.Choices[0].Delta.Content; text != "" {
+			full.WriteString(text)
+			onChunk(text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	out.Text = full.String()
+	return out, nil
+}
+
+func (p *openAIProvider) ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error) {
+	body := p.request(req)
+	for _, t := range tools {
+		var ot openAITool
+		ot.Type = "function"
+		ot.Function.Name = t.Name
+		ot.Function.Description = t.Description
+		ot.Function.Parameters = t.Parameters
+		if ot.Function.Parameters == nil {
+			ot.Function.Parameters = &JSONSchema{Type: "object"}
+		}
+		body.Tools = append(body.Tools, ot)
+	}
+
+	var usage LLMUsage
+	for round := 0; round <= llmMaxToolRounds; round++ {
+		res, msg, err := p.completeMessage(ctx, body)
+		if err != nil {
+			return nil, err
+		}
+		usage.PromptTokens += res.Usage.PromptTokens
+		usage.OutputTokens += res.Usage.OutputTokens
+
+		if len(msg.ToolCalls) == 0 {
+			res.Usage = usage
+			return res, nil
+		}
+
+		// 把模型的工具呼叫與每個結果依序接在對話後面
+		body.Messages = append(body.Messages, msg)
+		for _, tc := range msg.ToolCalls {
+			var out map[string]any
+			var args map[string]any
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); tc.Function.Arguments != "" && err != nil {
+				out = map[string]any{"error": "invalid arguments: " + err.Error()}
+			} else {
+				out = toolResult(ctx, call, LLMToolCall{Name: tc.Function.Name, Args: args})
+			}
+			result, _ := json.Marshal(out)
+			body.Messages = append(body.Messages, openAIMessage{Role: "tool", ToolCallID: tc.ID, Content: string(result)})
+		}
+	}
+	return nil, errTooManyToolRounds
+}
+
+// request 組出 /chat/completions 的請求內容
+func (p *openAIProvider) request(req LLMRequest) openAIRequest {
+	model := req.Model
+	if model == "" {
+		model = p.defaultModel
+	}
+
+	var msgs []openAIMessage
+	if req.System != "" {
+		msgs = append(msgs, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, h := range req.History {
+		role := "user"
+		if h.Role == "model" || h.Role == "assistant" {
+			role = "assistant"
+		}
+		msgs = append(msgs, openAIMessage{Role: role, Content: h.Text})
+	}
+	user := openAIMessage{Role: "user", Content: req.Prompt}
+	if len(req.Images) > 0 {
+		user.Parts = []openAIContentPart{{Type: "text", Text: req.Prompt}}
+		for _, img := range req.Images {
+			url := "data:" + img.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+			user.Parts = append(user.Parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+		}
+	}
+	msgs = append(msgs, user)
+
+	return openAIRequest{
+		Model:       model,
+		Messages:    msgs,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+}
+
+func (p *openAIProvider) complete(ctx context.Context, body openAIRequest) (*LLMResponse, error) {
+	out, _, err := p.completeMessage(ctx, body)
+	return out, err
+}
+
+// completeMessage 與 complete 相同，另外回傳原始的 assistant 訊息 (含 tool_calls)
+func (p *openAIProvider) completeMessage(ctx context.Context, body openAIRequest) (*LLMResponse, openAIMessage, error) {
+	var msg openAIMessage
+
+	resp, err := p.post(ctx, body)
+	if err != nil {
+		return nil, msg, err
+	}
+	defer resp.Body.Close()
+
+	var res openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, msg, fmt.Errorf("invalid response: %w", err)
+	}
+
+	out := &LLMResponse{Model: res.Model}
+	if out.Model == "" {
+		out.Model = body.Model
+	}
+	if len(res.Choices) > 0 {
+		msg = res.Choices[0].Message
+		out.Text = msg.Content
+		if fr := res.Choices[0].FinishReason; fr != nil {
+			out.FinishReason = *fr
+		}
+	}
+	if res.Usage != nil {
+		out.Usage = LLMUsage{PromptTokens: res.Usage.PromptTokens, OutputTokens: res.Usage.CompletionTokens}
+	}
+	return out, msg, nil
+}
+
+func (p *openAIProvider) post(ctx context.Context, body openAIRequest) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &openAIHTTPError{
+			Status:     resp.StatusCode,
+			Body:       strings.TrimSpace(string(msg)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return resp, nil
+}
+
+// openAIHTTPError 非 2xx 回應，保留狀態碼與 Retry-After 供重試判斷
+type openAIHTTPError struct {
+	Status     int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *openAIHTTPError) Error() string {
+	return fmt.Sprintf("openai: HTTP %d: %s", e.Status, e.Body)
+}
+
+// parseRetryAfter 解析 Retry-After (秒數或 HTTP 日期)
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
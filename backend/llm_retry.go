@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/grpc/codes"
+)
+
+// ========== 重試 / 退避 / 備援模型 ==========
+//
+// llmFor 回傳的 provider 外層都有 resilientProvider：
+// 遇到 429 / 5xx 以指數退避 (含 jitter) 重試，上游有給 retry delay 時以其為準；
+// 重試用完仍失敗且有設定備援模型時，改用備援模型再試一輪。
+// 整個過程 (含重試與備援) 不超過時間預算。
+//
+//	LLM_RETRY_ATTEMPTS=3         每個模型最多嘗試次數
+//	LLM_RETRY_BASE_MS=500        第一次退避時間，之後每次加倍
+//	LLM_RETRY_MAX_MS=8000        單次退避上限
+//	LLM_DEADLINE_SECONDS=180     單次呼叫的總時間預算
+//	LLM_FALLBACK_MODEL=...       備援模型；LLM_FALLBACK_MODEL_CHAT 等覆寫單一任務
+
+// LLM 錯誤代碼，前端依此決定提示方式
+const (
+	llmErrQuota       = "quota"       // 上游配額或速率限制
+	llmErrUnavailable = "unavailable" // 上游暫時無法服務
+	llmErrSafety      = "safety"      // 內容被安全機制擋下
+	llmErrInvalidKey  = "invalid_key" // API key 無效或沒有權限
+	llmErrTimeout     = "timeout"     // 超過時間預算
+	llmErrDailyQuota  = "daily_quota" // 超過本服務的每日配額 (QuotaError)
+	llmErrCanceled    = "canceled"    // 前端中斷請求
+	llmErrUpstream    = "upstream"    // 其他上游錯誤
+)
+
+// LLMError 分類後的模型錯誤
+type LLMError struct {
+	Code       string
+	Status     int // 回給前端的 HTTP 狀態
+	Retryable  bool
+	RetryAfter time.Duration // 上游建議的等待時間
+	Err        error
+}
+
+func (e *LLMError) Error() string { return e.Code + ": " + e.Err.Error() }
+func (e *LLMError) Unwrap() error { return e.Err }
+
+// classifyLLMError 將各 provider 的錯誤轉成 LLMError
+func classifyLLMError(err error) *LLMError {
+	var le *LLMError
+	if errors.As(err, &le) {
+		return le
+	}
+
+	e := &LLMError{Code: llmErrUpstream, Status: 502, Err: err}
+
+	var qe *QuotaError
+	var blocked *genai.BlockedError
+	var apiErr *apierror.APIError
+	var httpErr *openAIHTTPError
+
+	switch {
+	case errors.As(err, &qe):
+		e.Code, e.Status = llmErrDailyQuota, 429
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Code, e.Status = llmErrTimeout, 504
+	case errors.Is(err, context.Canceled):
+		e.Code, e.Status = llmErrCanceled, 499
+	case errors.As(err, &blocked):
+		e.Code, e.Status = llmErrSafety, 422
+	case errors.As(err, &apiErr):
+		status := apiErr.HTTPCode()
+		if status <= 0 && apiErr.GRPCStatus() != nil {
+			status = grpcHTTPStatus(apiErr.GRPCStatus().Code())
+		}
+		classifyStatus(e, status)
+		if ri := apiErr.Details().RetryInfo; ri != nil {
+			e.RetryAfter = ri.GetRetryDelay().AsDuration()
+		}
+		if apiErr.Reason() == "API_KEY_INVALID" {
+			e.Code, e.Status, e.Retryable = llmErrInvalidKey, 500, false
+		}
+	case errors.As(err, &httpErr):
+		classifyStatus(e, httpErr.Status)
+		e.RetryAfter = httpErr.RetryAfter
+	}
+
+	// Gemini 的無效 key 有時只是 400 + 訊息
+	if e.Code == llmErrUpstream && strings.Contains(err.Error(), "API key not valid") {
+		e.Code, e.Status = llmErrInvalidKey, 500
+	}
+	return e
+}
+
+// classifyStatus 依上游 HTTP 狀態分類
+func classifyStatus(e *LLMError, status int) {
+	switch {
+	case status == 429:
+		e.Code, e.Status, e.Retryable = llmErrQuota, 429, true
+	case status == 401 || status == 403:
+		e.Code, e.Status = llmErrInvalidKey, 500
+	case status == 408 || status == 504:
+		e.Code, e.Status, e.Retryable = llmErrUnavailable, 503, true
+	case status >= 500:
+		e.Code, e.Status, e.Retryable = llmErrUnavailable, 503, true
+	}
+}
+
+func grpcHTTPStatus(c codes.Code) int {
+	switch c {
+	case codes.ResourceExhausted:
+		return 429
+	case codes.Unauthenticated:
+		return 401
+	case codes.PermissionDenied:
+		return 403
+	case codes.Unavailable, codes.Internal:
+		return 503
+	case codes.DeadlineExceeded:
+		return 504
+	}
+	return 400
+}
+
+// llmErrorMessages 給使用者看的錯誤說明
+var llmErrorMessages = map[string]string{
+	llmErrQuota:       "模型目前使用量過高，請稍後再試",
+	llmErrUnavailable: "模型暫時無法使用，請稍後再試",
+	llmErrSafety:      "內容被模型的安全機制擋下，請換個說法",
+	llmErrInvalidKey:  "API key 無效或沒有權限，請檢查伺服器設定",
+	llmErrTimeout:     "模型回應逾時，請稍後再試",
+	llmErrCanceled:    "請求已取消",
+	llmErrUpstream:    "模型發生錯誤",
+}
+
+// llmErrorBody 組出錯誤回應：error 為說明，code 為穩定的錯誤代碼
+func llmErrorBody(name string, err error) (*LLMError, gin.H) {
+	e := classifyLLMError(err)
+	body := gin.H{
+		"error":  fmt.Sprintf("%s API 錯誤: %s", name, llmErrorMessages[e.Code]),
+		"code":   e.Code,
+		"detail": e.Err.Error(),
+	}
+	// 本機的每日配額不是模型的錯誤，沿用 QuotaError 的說明與 scope / used / limit
+	var qe *QuotaError
+	if errors.As(err, &qe) {
+		body["error"], body["scope"], body["used"], body["limit"] = qe.Error(), qe.Scope, qe.Used, qe.Limit
+	}
+	if e.RetryAfter > 0 {
+		body["retry_after"] = int(math.Ceil(e.RetryAfter.Seconds()))
+	}
+	return e, body
+}
+
+// respondLLMError 依錯誤分類回傳 HTTP 狀態與錯誤代碼，extra 會併入回應
+func respondLLMError(c *gin.Context, name string, err error, extra gin.H) {
+	e, body := llmErrorBody(name, err)
+	for k, v := range extra {
+		body[k] = v
+	}
+	if e.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(body["retry_after"].(int)))
+	}
+	c.JSON(e.Status, body)
+}
+
+// ========== resilientProvider ==========
+
+type retryPolicy struct {
+	attempts      int
+	base          time.Duration
+	max           time.Duration
+	budget        time.Duration
+	fallbackModel string
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// retryPolicyFor 讀取任務的重試設定
+func retryPolicyFor(task string) retryPolicy {
+	return retryPolicy{
+		attempts:      max(1, envInt("LLM_RETRY_ATTEMPTS", 3)),
+		base:          time.Duration(envInt("LLM_RETRY_BASE_MS", 500)) * time.Millisecond,
+		max:           time.Duration(envInt("LLM_RETRY_MAX_MS", 8000)) * time.Millisecond,
+		budget:        time.Duration(envInt("LLM_DEADLINE_SECONDS", 180)) * time.Second,
+		fallbackModel: envOr("LLM_FALLBACK_MODEL_"+strings.ToUpper(task), os.Getenv("LLM_FALLBACK_MODEL")),
+	}
+}
+
+// backoff 第 n 次失敗後的等待時間：base * 2^(n-1)，上限 max，取 [d/2, d] 的隨機值
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.base << (n - 1)
+	if d > p.max || d <= 0 {
+		d = p.max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+type resilientProvider struct {
+	LLMProvider
+	policy retryPolicy
+	sleep  func(ctx context.Context, d time.Duration) error // 測試時可替換
+}
+
+func newResilientProvider(p LLMProvider, policy retryPolicy) resilientProvider {
+	return resilientProvider{LLMProvider: p, policy: policy, sleep: sleepCtx}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// do 依 policy 執行 call；canRetry 回傳 false 時 (例如串流已輸出部分內容) 不再重試
+func (p resilientProvider) do(ctx context.Context, req LLMRequest, canRetry func() bool, call func(context.Context, LLMRequest) (*LLMResponse, error)) (*LLMResponse, error) {
+	if p.policy.budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.policy.budget)
+		defer cancel()
+	}
+
+	models := []string{req.Model}
+	if fb := p.policy.fallbackModel; fb != "" && fb != req.Model {
+		models = append(models, fb)
+	}
+
+	var last *LLMError
+	for i, model := range models {
+		r := req
+		r.Model = model
+		for attempt := 1; attempt <= p.policy.attempts; attempt++ {
+			res, err := call(ctx, r)
+			if err == nil {
+				return res, nil
+			}
+			last = classifyLLMError(err)
+			if ctx.Err() != nil {
+				return nil, classifyLLMError(ctx.Err())
+			}
+			if !last.Retryable || !canRetry() {
+				return nil, last
+			}
+			if attempt == p.policy.attempts {
+				break
+			}
+
+			delay := max(p.policy.backoff(attempt), last.RetryAfter)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				// 剩下的時間不夠等下一次，直接回報
+				return nil, last
+			}
+			if err := p.sleep(ctx, delay); err != nil {
+				return nil, classifyLLMError(err)
+			}
+		}
+		if i+1 < len(models) {
+			fmt.Printf("⚠️ %s 重試 %d 次仍失敗 (%s)，改用備援模型 %s\n", p.Name(), p.policy.attempts, last.Code, models[i+1])
+		}
+	}
+	return nil, last
+}
+
+func always() bool { return true }
+
+func (p resilientProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.do(ctx, req, always, p.LLMProvider.Generate)
+}
+
+func (p resilientProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.do(ctx, req, always, p.LLMProvider.Chat)
+}
+
+func (p resilientProvider) Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error) {
+	return p.do(ctx, req, always, func(ctx context.Context, r LLMRequest) (*LLMResponse, error) {
+		return p.LLMProvider.Structured(ctx, r, schema)
+	})
+}
+
+// Stream 已經送出部分內容後就不重試，避免前端收到重複的文字
+func (p resilientProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	emitted := false
+	return p.do(ctx, req, func() bool { return !emitted }, func(ctx context.Context, r LLMRequest) (*LLMResponse, error) {
+		return p.LLMProvider.Stream(ctx, r, func(s string) {
+			emitted = true
+			onChunk(s)
+		})
+	})
+}
+
+// ChatWithTools 已經執行過工具後就不重試，避免重複修改行程
+func (p resilientProvider) ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error) {
+	called := false
+	return p.do(ctx, req, func() bool { return !called }, func(ctx context.Context, r LLMRequest) (*LLMResponse, error) {
+		return p.LLMProvider.ChatWithTools(ctx, r, tools, func(ctx context.Context, tc LLMToolCall) (map[string]any, error) {
+			called = true
+			return call(ctx, tc)
+		})
+	})
+}
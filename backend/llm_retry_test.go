@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// noSleep 記錄每次退避時間但不真的等待
+func noSleep(delays *[]time.Duration) func(context.Context, time.Duration) error {
+	return func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+}
+
+func testPolicy() retryPolicy {
+	return retryPolicy{attempts: 3, base: 100 * time.Millisecond, max: time.Second, budget: time.Minute}
+}
+
+func TestResilientProviderRetriesWithRetryAfter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, `{"error":"rate limited"}`, 429)
+			return
+		}
+		fmt.Fprint(w, `{"model":"llama3","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	var delays []time.Duration
+	p := newResilientProvider(newOpenAIProvider(srv.URL, "", "llama3"), testPolicy())
+	p.sleep = noSleep(&delays)
+
+	res, err := p.Chat(context.Background(), LLMRequest{Prompt: "hi"})
+	if err != nil || res.Text != "ok" {
+		t.Fatalf("res = %+v, err = %v", res, err)
+	}
+	// 上游的 Retry-After 比退避時間長，以上游為準
+	if calls != 2 || len(delays) != 1 || delays[0] != 2*time.Second {
+		t.Errorf("calls = %d, delays = %v", calls, delays)
+	}
+}
+
+// flakyProvider 前 failures 次呼叫回傳 err
+type flakyProvider struct {
+	*fakeProvider
+	failures int
+	err      error
+	models   []string
+}
+
+func (p *flakyProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	p.models = append(p.models, req.Model)
+	if len(p.models) <= p.failures {
+		return nil, p.err
+	}
+	return p.fakeProvider.Chat(ctx, req)
+}
+
+func (p *flakyProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	p.models = append(p.models, req.Model)
+	onChunk("部分")
+	return nil, p.err
+}
+
+func TestResilientProviderFallbackModel(t *testing.T) {
+	inner := &flakyProvider{fakeProvider: newFakeProvider(), failures: 3, err: &openAIHTTPError{Status: 503}}
+	policy := testPolicy()
+	policy.fallbackModel = "small"
+
+	var delays []time.Duration
+	p := newResilientProvider(inner, policy)
+	p.sleep = noSleep(&delays)
+
+	if _, err := p.Chat(context.Background(), LLMRequest{Model: "big", Prompt: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"big", "big", "big", "small"}
+	if fmt.Sprint(inner.models) != fmt.Sprint(want) {
+		t.Errorf("models = %v, want %v", inner.models, want)
+	}
+	for i, d := range delays[:2] {
+		if hi := policy.base << i; d < hi/2 || d > hi {
+			t.Errorf("delay[%d] = %v, want within [%v, %v]", i, d, hi/2, hi)
+		}
+	}
+}
+
+func TestResilientProviderStopsOnPermanentErrors(t *testing.T) {
+	var delays []time.Duration
+
+	// 無效 key 不重試
+	inner := &flakyProvider{fakeProvider: newFakeProvider(), failures: 5, err: &openAIHTTPError{Status: 401}}
+	p := newResilientProvider(inner, testPolicy())
+	p.sleep = noSleep(&delays)
+	_, err := p.Chat(context.Background(), LLMRequest{Prompt: "hi"})
+	var le *LLMError
+	if !errors.As(err, &le) || le.Code != llmErrInvalidKey || len(inner.models) != 1 {
+		t.Errorf("err = %v, calls = %d", err, len(inner.models))
+	}
+
+	// 串流已輸出內容就不重試
+	inner = &flakyProvider{fakeProvider: newFakeProvider(), err: &openAIHTTPError{Status: 503}}
+	p = newResilientProvider(inner, testPolicy())
+	p.sleep = noSleep(&delays)
+	if _, err := p.Stream(context.Background(), LLMRequest{Prompt: "hi"}, func(string) {}); err == nil || len(inner.models) != 1 {
+		t.Errorf("err = %v, calls = %d", err, len(inner.models))
+	}
+	if len(delays) != 0 {
+		t.Errorf("delays = %v", delays)
+	}
+}
+
+func TestClassifyLLMError(t *testing.T) {
+	cases := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{&openAIHTTPError{Status: 429}, llmErrQuota, 429},
+		{&openAIHTTPError{Status: 502}, llmErrUnavailable, 503},
+		{&openAIHTTPError{Status: 403}, llmErrInvalidKey, 500},
+		{&openAIHTTPError{Status: 400, Body: "bad request"}, llmErrUpstream, 502},
+		{errors.New("googleapi: Error 400: API key not valid. Please pass a valid API key."), llmErrInvalidKey, 500},
+		{&genai.BlockedError{}, llmErrSafety, 422},
+		{fmt.Errorf("wrap: %w", context.DeadlineExceeded), llmErrTimeout, 504},
+		{&QuotaError{Scope: "user", Used: 10, Limit: 5}, llmErrDailyQuota, 429},
+	}
+	for _, tc := range cases {
+		e := classifyLLMError(tc.err)
+		if e.Code != tc.code || e.Status != tc.status {
+			t.Errorf("classify(%v) = %s/%d, want %s/%d", tc.err, e.Code, e.Status, tc.code, tc.status)
+		}
+	}
+}
+
+func TestLLMErrorBody(t *testing.T) {
+	_, body := llmErrorBody("gemini", fmt.Errorf("check: %w", &QuotaError{Scope: "trip", Used: 12, Limit: 10}))
+	if body["code"] != llmErrDailyQuota || body["scope"] != "trip" || body["used"] != 12 || body["limit"] != 10 ||
+		strings.Contains(body["error"].(string), "gemini") {
+		t.Errorf("quota body = %v", body)
+	}
+
+	_, body = llmErrorBody("gemini", &openAIHTTPError{Status: 429})
+	if body["error"] != "gemini API 錯誤: "+llmErrorMessages[llmErrQuota] || body["scope"] != nil {
+		t.Errorf("upstream body = %v", body)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != 3*time.Second {
+		t.Errorf("seconds = %v", d)
+	}
+	future := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(future); d <= 0 || d > 10*time.Second {
+		t.Errorf("date = %v", d)
+	}
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("invalid = %v", d)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFakeProviderDeterministic(t *testing.T) {
+	p := newFakeProvider().On("東京", "TYO")
+	ctx := context.Background()
+
+	res, err := p.Generate(ctx, LLMRequest{Prompt: "東京的機場代碼"})
+	if err != nil || res.Text != "TYO" {
+		t.Fatalf("Generate = %+v, %v", res, err)
+	}
+
+	res, err = p.Chat(ctx, LLMRequest{Prompt: "哈囉"})
+	if err != nil || res.Text != "[fake] 哈囉" {
+		t.Fatalf("Chat = %+v, %v", res, err)
+	}
+
+	var chunks []string
+	res, err = p.Stream(ctx, LLMRequest{Prompt: strings.Repeat("字", 40)}, func(s string) { chunks = append(chunks, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chunks, "") != res.Text || len(chunks) != 3 {
+		t.Errorf("stream chunks = %q", chunks)
+	}
+
+	res, err = p.Structured(ctx, LLMRequest{Prompt: "plan"}, planResponseSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Text != `{"days":[]}` {
+		t.Errorf("Structured = %s", res.Text)
+	}
+
+	if n := len(p.Calls()); n != 4 {
+		t.Errorf("calls = %d, want 4", n)
+	}
+}
+
+func TestOpenAIProviderChat(t *testing.T) {
+	var got openAIRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			t.Errorf("unexpected request %s auth=%q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"model":"llama3","choices":[{"message":{"role":"assistant","content":"你好"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+	}))
+	defer srv.Close()
+
+	p := newOpenAIProvider(srv.URL+"/v1/", "sk-test", "llama3")
+	res, err := p.Chat(context.Background(), LLMRequest{
+		System:  "你是一個專業導遊。",
+		History: []ChatPart{{Role: "user", Text: "嗨"}, {Role: "model", Text: "嗨！"}},
+		Prompt:  "推薦景點",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Text != "你好" || res.FinishReason != "stop" || res.Usage.PromptTokens != 12 || res.Usage.OutputTokens != 3 {
+		t.Errorf("response = %+v", res)
+	}
+	roles := []string{}
+	for _, m := range got.Messages {
+		roles = append(roles, m.Role)
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,user" {
+		t.Errorf("roles = %v", roles)
+	}
+}
+
+func TestOpenAIProviderStreamAndStructured(t *testing.T) {
+	var format map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["stream"] == true {
+			for _, s := range []string{"東", "京"} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", s)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		format, _ = body["response_format"].(map[string]any)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"days\":[]}"}}]}`)
+	}))
+	defer srv.Close()
+
+	p := newOpenAIProvider(srv.URL, "", "local")
+
+	var chunks []string
+	res, err := p.Stream(context.Background(), LLMRequest{Prompt: "hi"}, func(s string) { chunks = append(chunks, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Text != "東京" || len(chunks) != 2 || res.FinishReason != "stop" {
+		t.Errorf("stream = %+v chunks=%q", res, chunks)
+	}
+
+	res, err = p.Structured(context.Background(), LLMRequest{Prompt: "plan"}, planResponseSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Text != `{"days":[]}` || format["type"] != "json_schema" {
+		t.Errorf("structured = %+v format=%v", res, format)
+	}
+}
+
+func TestLLMForAppliesTaskModel(t *testing.T) {
+	fake := newFakeProvider()
+	llmTasks["test"] = llmTask{provider: fake, model: "tiny"}
+	defer delete(llmTasks, "test")
+
+	p, err := llmFor("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := p.Generate(context.Background(), LLMRequest{Prompt: "x"})
+	if res.Model != "tiny" {
+		t.Errorf("model = %q, want tiny", res.Model)
+	}
+	if _, err := llmFor("missing"); err == nil {
+		t.Error("expected error for unconfigured task")
+	}
+}
+
+func TestOpenAIProviderChatWithTools(t *testing.T) {
+	round := 0
+	var last openAIRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&last)
+		round++
+		if round == 1 {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"remove_item","arguments":"{\"item_id\":\"d1-1\"}"}}]}}],"usage":{"prompt_tokens":10,"completion_tokens":2}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"已刪除"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":3}}`)
+	}))
+	defer srv.Close()
+
+	var called LLMToolCall
+	p := newOpenAIProvider(srv.URL, "", "local")
+	res, err := p.ChatWithTools(context.Background(), LLMRequest{Prompt: "刪掉淺草寺"}, tripTools(), func(ctx context.Context, tc LLMToolCall) (map[string]any, error) {
+		called = tc
+		return map[string]any{"ok": true}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if called.Name != "remove_item" || called.Args["item_id"] != "d1-1" {
+		t.Errorf("tool call = %+v", called)
+	}
+	if res.Text != "已刪除" || res.Usage.PromptTokens != 30 || res.Usage.OutputTokens != 5 {
+		t.Errorf("response = %+v", res)
+	}
+	if len(last.Tools) != len(tripTools()) {
+		t.Errorf("tools = %d", len(last.Tools))
+	}
+	msgs := last.Messages
+	if n := len(msgs); n != 3 || msgs[2].Role != "tool" || msgs[2].ToolCallID != "call_1" || msgs[2].Content != `{"ok":true}` {
+		t.Errorf("messages = %+v", msgs)
+	}
+}
+
+func TestOpenAIProviderSendsImageParts(t *testing.T) {
+	var got struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	p := newOpenAIProvider(srv.URL, "", "gpt-4o-mini")
+	if _, err := p.Chat(context.Background(), LLMRequest{
+		System: "sys",
+		Prompt: "這是哪裡？",
+		Images: []LLMImage{{MIMEType: "image/png", Data: []byte("abc")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Messages) != 2 || string(got.Messages[0].Content) != `"sys"` {
+		t.Fatalf("messages = %+v", got.Messages)
+	}
+	var parts []openAIContentPart
+	if err := json.Unmarshal(got.Messages[1].Content, &parts); err != nil {
+		t.Fatalf("user content should be an array: %s", got.Messages[1].Content)
+	}
+	if len(parts) != 2 || parts[0].Text != "這是哪裡？" || parts[1].ImageURL == nil || parts[1].ImageURL.URL != "data:image/png;base64,YWJj" {
+		t.Errorf("parts = %+v", parts)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ========== Token 用量與配額 ==========
+//
+// llmFor 回傳的 provider 都包在 usageProvider 裡：每次呼叫前檢查今日配額，
+// 呼叫後把 token 用量、模型、延遲、呼叫來源與行程寫進 llm_usage collection。
+// 呼叫來源 / 使用者 / 行程由 usageScopeMiddleware 放進 request context。
+//
+//	AI_QUOTA_USER_DAILY_TOKENS=200000   每位使用者每日 token 上限 (0 或未設定為不限)
+//	AI_QUOTA_TRIP_DAILY_TOKENS=100000   每個行程每日 token 上限
+
+// UsageRecord 一次 LLM 呼叫的用量紀錄
+type UsageRecord struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Day          string             `json:"day" bson:"day"` // 2006-01-02，方便依天彙整
+	Time         time.Time          `json:"time" bson:"time"`
+	Caller       string             `json:"caller" bson:"caller"` // API 路由，例如 /api/gemini/chat
+	Task         string             `json:"task" bson:"task"`
+	Method       string             `json:"method" bson:"method"` // generate / chat / stream / structured / tools
+	Provider     string             `json:"provider" bson:"provider"`
+	Model        string             `json:"model" bson:"model"`
+	UserID       string             `json:"user_id" bson:"user_id"`
+	TripID       int                `json:"trip_id,omitempty" bson:"trip_id,omitempty"`
+	PromptTokens int                `json:"prompt_tokens" bson:"prompt_tokens"`
+	OutputTokens int                `json:"output_tokens" bson:"output_tokens"`
+	TotalTokens  int                `json:"total_tokens" bson:"total_tokens"`
+	LatencyMs    int64              `json:"latency_ms" bson:"latency_ms"`
+	Error        string             `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// usageScope 一個 API 請求的用量歸屬
+type usageScope struct {
+	Caller string
+	UserID string
+	TripID int
+}
+
+type usageScopeKey struct{}
+
+// usageScopeMiddleware 將呼叫來源、使用者與路由上的行程 id 放進 request context
+func usageScopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := &usageScope{Caller: c.FullPath(), UserID: requestUserID(c)}
+		if id, err := strconv.Atoi(c.Param("id")); err == nil {
+			scope.TripID = id
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), usageScopeKey{}, scope))
+		c.Next()
+	}
+}
+
+// usageScopeFrom 取得 context 中的用量歸屬，沒有時回傳空的 scope
+func usageScopeFrom(ctx context.Context) *usageScope {
+	if s, ok := ctx.Value(usageScopeKey{}).(*usageScope); ok {
+		return s
+	}
+	return &usageScope{}
+}
+
+// setUsageTrip 路由上沒有行程 id 時 (例如用 session 對話)，由 handler 補上
+func setUsageTrip(ctx context.Context, tripID int) {
+	if s, ok := ctx.Value(usageScopeKey{}).(*usageScope); ok {
+		s.TripID = tripID
+	}
+}
+
+// QuotaError 今日用量已達上限
+type QuotaError struct {
+	Scope string // user / trip
+	Used  int
+	Limit int
+}
+
+func (e *QuotaError) Error() string {
+	who := "使用者"
+	if e.Scope == "trip" {
+		who = "行程"
+	}
+	return fmt.Sprintf("今日 AI 用量已達%s上限 (%d / %d tokens)，請明天再試", who, e.Used, e.Limit)
+}
+
+// 用量紀錄與查詢，預設使用 Mongo；測試時可替換
+var (
+	recordUsage = func(rec UsageRecord) {
+		if usageCollection == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := usageCollection.InsertOne(ctx, rec); err != nil {
+			log.Printf("用量紀錄寫入失敗: %v", err)
+		}
+	}
+	usedTokensToday = func(ctx context.Context, field string, value any) (int, error) {
+		if usageCollection == nil {
+			return 0, nil
+		}
+		return sumUsageTokens(ctx, bson.M{"day": usageDay(time.Now()), field: value})
+	}
+)
+
+func usageDay(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// checkQuota 呼叫 LLM 前檢查使用者與行程今日的用量
+func checkQuota(ctx context.Context, scope *usageScope) error {
+	checks := []struct {
+		name  string
+		env   string
+		field string
+		value any
+		ok    bool
+	}{
+		{"user", "AI_QUOTA_USER_DAILY_TOKENS", "user_id", scope.UserID, scope.UserID != ""},
+		{"trip", "AI_QUOTA_TRIP_DAILY_TOKENS", "trip_id", scope.TripID, scope.TripID != 0},
+	}
+	for _, q := range checks {
+		limit, _ := strconv.Atoi(os.Getenv(q.env))
+		if limit <= 0 || !q.ok {
+			continue
+		}
+		used, err := usedTokensToday(ctx, q.field, q.value)
+		if err != nil {
+			// 查不到用量時不擋使用者，只記錄下來
+			log.Printf("用量查詢失敗: %v", err)
+			continue
+		}
+		if used >= limit {
+			return &QuotaError{Scope: q.name, Used: used, Limit: limit}
+		}
+	}
+	return nil
+}
+
+// usageProvider 在每次呼叫前檢查配額、呼叫後記錄用量
+type usageProvider struct {
+	LLMProvider
+	task string
+}
+
+func (p usageProvider) track(ctx context.Context, method string, call func() (*LLMResponse, error)) (*LLMResponse, error) {
+	scope := usageScopeFrom(ctx)
+	if err := checkQuota(ctx, scope); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, err := call()
+
+	rec := UsageRecord{
+		Day:       usageDay(start),
+		Time:      start,
+		Caller:    scope.Caller,
+		Task:      p.task,
+		Method:    method,
+		Provider:  p.Name(),
+		UserID:    scope.UserID,
+		TripID:    scope.TripID,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if res != nil {
+		rec.Model = res.Model
+		rec.PromptTokens = res.Usage.PromptTokens
+		rec.OutputTokens = res.Usage.OutputTokens
+		rec.TotalTokens = res.Usage.PromptTokens + res.Usage.OutputTokens
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	recordUsage(rec)
+
+	return res, err
+}
+
+func (p usageProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.track(ctx, "generate", func() (*LLMResponse, error) { return p.LLMProvider.Generate(ctx, req) })
+}
+
+func (p usageProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.track(ctx, "chat", func() (*LLMResponse, error) { return p.LLMProvider.Chat(ctx, req) })
+}
+
+func (p usageProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string)) (*LLMResponse, error) {
+	return p.track(ctx, "stream", func() (*LLMResponse, error) { return p.LLMProvider.Stream(ctx, req, onChunk) })
+}
+
+func (p usageProvider) Structured(ctx context.Context, req LLMRequest, schema *JSONSchema) (*LLMResponse, error) {
+	return p.track(ctx, "structured", func() (*LLMResponse, error) { return p.LLMProvider.Structured(ctx, req, schema) })
+}
+
+func (p usageProvider) ChatWithTools(ctx context.Context, req LLMRequest, tools []LLMTool, call LLMToolFunc) (*LLMResponse, error) {
+	return p.track(ctx, "tools", func() (*LLMResponse, error) { return p.LLMProvider.ChatWithTools(ctx, req, tools, call) })
+}
+
+// ========== 用量報表 ==========
+
+// sumUsageTokens 加總符合條件的 total_tokens
+func sumUsageTokens(ctx context.Context, match bson.M) (int, error) {
+	cursor, err := usageCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": nil, "tokens": bson.M{"$sum": "$total_tokens"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Tokens int `bson:"tokens"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return rows[0].Tokens, nil
+}
+
+// usageReport 依天 / 使用者 / 行程彙整用量：GET /api/usage?group_by=day&from=2025-01-01&to=2025-01-31
+// 另可用 user_id、trip_id 篩選
+func usageReport(c *gin.Context) {
+	groupFields := map[string]string{"day": "$day", "user": "$user_id", "trip": "$trip_id"}
+	groupBy := c.DefaultQuery("group_by", "day")
+	field, ok := groupFields[groupBy]
+	if !ok {
+		c.JSON(400, gin.H{"error": "group_by 只能是 day、user 或 trip"})
+		return
+	}
+
+	match := bson.M{}
+	day := bson.M{}
+	if from := c.Query("from"); from != "" {
+		day["$gte"] = from
+	}
+	if to := c.Query("to"); to != "" {
+		day["$lte"] = to
+	}
+	if len(day) > 0 {
+		match["day"] = day
+	}
+	if u := c.Query("user_id"); u != "" {
+		match["user_id"] = u
+	}
+	if t := c.Query("trip_id"); t != "" {
+		id, err := strconv.Atoi(t)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid trip_id"})
+			return
+		}
+		match["trip_id"] = id
+	}
+
+	ctx := c.Request.Context()
+	cursor, err := usageCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":           field,
+			"calls":         bson.M{"$sum": 1},
+			"errors":        bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$error", nil}}, 1, 0}}},
+			"prompt_tokens": bson.M{"$sum": "$prompt_tokens"},
+			"output_tokens": bson.M{"$sum": "$output_tokens"},
+			"total_tokens":  bson.M{"$sum": "$total_tokens"},
+			"avg_latency":   bson.M{"$avg": "$latency_ms"},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	type row struct {
+		Key          any     `json:"key" bson:"_id"`
+		Calls        int     `json:"calls" bson:"calls"`
+		Errors       int     `json:"errors" bson:"errors"`
+		PromptTokens int     `json:"prompt_tokens" bson:"prompt_tokens"`
+		OutputTokens int     `json:"output_tokens" bson:"output_tokens"`
+		TotalTokens  int     `json:"total_tokens" bson:"total_tokens"`
+		AvgLatencyMs float64 `json:"avg_latency_ms" bson:"avg_latency"`
+	}
+	rows := []row{}
+	if err := cursor.All(ctx, &rows); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"group_by": groupBy, "rows": rows})
+}
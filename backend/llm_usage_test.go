@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubUsage 以記憶體取代 Mongo 的用量紀錄
+func stubUsage(t *testing.T, used map[string]int) *[]UsageRecord {
+	var records []UsageRecord
+	origRecord, origUsed := recordUsage, usedTokensToday
+	recordUsage = func(rec UsageRecord) { records = append(records, rec) }
+	usedTokensToday = func(ctx context.Context, field string, value any) (int, error) {
+		return used[field], nil
+	}
+	t.Cleanup(func() { recordUsage, usedTokensToday = origRecord, origUsed })
+	return &records
+}
+
+func TestUsageProviderRecordsScope(t *testing.T) {
+	records := stubUsage(t, nil)
+	llmTasks["test"] = llmTask{provider: newFakeProvider(), model: "tiny"}
+	defer delete(llmTasks, "test")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(usageScopeMiddleware())
+	r.POST("/api/trips/:id/generate", func(c *gin.Context) {
+		p, _ := llmFor("test")
+		p.Generate(c.Request.Context(), LLMRequest{Prompt: "東京"})
+		c.Status(204)
+	})
+
+	req := httptest.NewRequest("POST", "/api/trips/42/generate", nil)
+	req.Header.Set("X-User-ID", "amy")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(*records) != 1 {
+		t.Fatalf("records = %+v", *records)
+	}
+	rec := (*records)[0]
+	if rec.Caller != "/api/trips/:id/generate" || rec.UserID != "amy" || rec.TripID != 42 ||
+		rec.Task != "test" || rec.Method != "generate" || rec.Provider != "fake" || rec.Model != "tiny" {
+		t.Errorf("record = %+v", rec)
+	}
+	if rec.TotalTokens != rec.PromptTokens+rec.OutputTokens || rec.TotalTokens == 0 {
+		t.Errorf("tokens = %+v", rec)
+	}
+}
+
+func TestUsageQuotaExceeded(t *testing.T) {
+	records := stubUsage(t, map[string]int{"user_id": 10, "trip_id": 500})
+	t.Setenv("AI_QUOTA_USER_DAILY_TOKENS", "100")
+	t.Setenv("AI_QUOTA_TRIP_DAILY_TOKENS", "500")
+
+	p := usageProvider{LLMProvider: newFakeProvider(), task: "test"}
+
+	// 沒有行程時只檢查使用者配額
+	ctx := context.WithValue(context.Background(), usageScopeKey{}, &usageScope{UserID: "amy"})
+	if _, err := p.Chat(ctx, LLMRequest{Prompt: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	setUsageTrip(ctx, 7)
+	_, err := p.Chat(ctx, LLMRequest{Prompt: "hi"})
+	var qe *QuotaError
+	if !errors.As(err, &qe) || qe.Scope != "trip" || qe.Limit != 500 {
+		t.Fatalf("err = %v", err)
+	}
+	if len(*records) != 1 {
+		t.Errorf("blocked call should not be recorded: %+v", *records)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	respondLLMError(c, "fake", err, nil)
+	if w.Code != 429 || !strings.Contains(w.Body.String(), `"code":"daily_quota"`) {
+		t.Errorf("quota response = %d %s", w.Code, w.Body.String())
+	}
+}
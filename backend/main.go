@@ -16,6 +16,22 @@ func main() {
 	// 連線 MongoDB
 	initMongo()
 
+	// 匯入舊的 data/ 草稿檔 (只會做一次)
+	initLegacyDrafts()
+
+	// Unsplash 查詢快取 (可存到檔案或 Mongo)
+	initUnsplashCache()
+
+	// 建立 LLM providers (Gemini / OpenAI 相容 / fake)
+	initLLM()
+
+	// 地理編碼 (Nominatim / fake)
+	initGeocoder()
+
+	// 圖片來源 (依 IMAGE_PROVIDERS 的順序)
+	initImageProviders()
+	initImageCache()
+
 	// 設定 Gin
 	r := gin.Default()
 
@@ -23,7 +39,7 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:8080", "*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-User-ID", "Accept-Language"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -37,20 +53,66 @@ func main() {
 
 	// API 路由
 	api := r.Group("/api")
+	api.Use(usageScopeMiddleware()) // LLM 用量歸屬 (呼叫來源 / 使用者 / 行程)
 	{
 		// 行程相關
 		api.GET("/trips", getTrips)
 		api.GET("/trips/:id", getTrip)
 		api.POST("/trips", createTrip)
+		api.POST("/trips/from-text", createTripFromText) // 由一句話抽出行程設定
 		api.PUT("/trips/:id", updateTrip)
 		api.DELETE("/trips/:id", deleteTrip)
+		api.POST("/trips/:id/generate", generateTripPlan)     // 結構化輸出產生行程
+		api.GET("/trips/:id/airports", tripAirports)          // 行程目的地附近的機場
+		api.POST("/trips/:id/flights/links", tripFlightLinks) // 比價網站的航班搜尋連結
+		api.POST("/trips/:id/geocode", geocodeTrip)           // 補上 item 的座標
+
+		// 封面與景點照片
+		api.GET("/trips/:id/photos/candidates", tripPhotoCandidates)
+		api.POST("/trips/:id/photos/auto-fill", autoFillTripPhotos)
+		api.PUT("/trips/:id/cover", setTripCover)
+		api.DELETE("/trips/:id/cover", clearTripCover)
+		api.PUT("/trips/:id/items/:item_id/photo", setItemPhoto)
+		api.DELETE("/trips/:id/items/:item_id/photo", clearItemPhoto)
+
+		// AI 修改提案 (先審核再套用)
+		api.GET("/trips/:id/proposals", listPlanProposals)
+		api.POST("/trips/:id/proposals", proposePlanChanges)
+		api.GET("/proposals/:pid", getPlanProposal)
+		api.POST("/proposals/:pid/accept", acceptPlanProposal)
+		api.POST("/proposals/:pid/reject", rejectPlanProposal)
+		api.POST("/trips/:id/days/:day_index/regenerate", regenerateDay)
+		api.POST("/trips/:id/days/:day_index/items/from-photo", itemFromPhoto)
+
+		// AI 草稿 (ai_drafts collection，取代 data/response.json)
+		api.GET("/trips/:id/drafts", listAIDrafts)
+		api.POST("/trips/:id/drafts", saveAIDraft)
+		api.GET("/drafts/:did", getAIDraft)
+		api.POST("/drafts/:did/pin", pinAIDraft)
+		api.DELETE("/drafts/:did/pin", unpinAIDraft)
+		api.DELETE("/drafts/:did", deleteAIDraft)
+
+		// 伺服器端對話 session
+		api.GET("/trips/:id/chat/sessions", listChatSessions)
+		api.POST("/trips/:id/chat/sessions", createChatSession)
+		api.DELETE("/trips/:id/chat/sessions", deleteTripChatSessions)
+		api.GET("/chat/sessions/:sid", getChatSession)
+		api.DELETE("/chat/sessions/:sid", deleteChatSession)
 
 		// Gemini 相關
 		api.POST("/gemini", callGemini) // 一般問答
-		api.POST("/gemini/save", saveGeminiToFile)
-		api.GET("/gemini/response", getGeminiResponse)
 
-		api.POST("/gemini/chat", chatWithGemini) // 對話模式
+		api.POST("/gemini/chat", chatWithGemini)              // 對話模式
+		api.POST("/gemini/chat/stream", chatWithGeminiStream) // 對話模式 (SSE 串流)
+
+		// LLM token 用量報表
+		api.GET("/usage", usageReport)
+
+		// prompt 模板與版本
+		api.GET("/prompts", listPrompts)
+
+		// 將 Gemini 的 Markdown 行程轉成 plan
+		api.POST("/itinerary/parse", parseItinerary)
 
 		// 健康檢查
 		api.GET("/health", func(c *gin.Context) {
@@ -60,10 +122,16 @@ func main() {
 			})
 		})
 
-		// Unsplash image proxy/search
-		api.GET("/unsplash", unsplashHandler)
+		// 圖片搜尋 (Unsplash / Pexels / Wikimedia Commons)
+		api.GET("/images/search", imageSearchHandler)
+		api.GET("/images/:id", imageProxyHandler)
+		api.GET("/unsplash", imageSearchHandler) // 舊路徑
+		// 快取統計
+		api.GET("/admin/cache", cacheStatsHandler)
 		// IATA code 查詢
 		api.POST("/iata", getIATACode)
+		api.GET("/airports", searchAirports)
+		api.GET("/airports/nearest", nearestAirports)
 	}
 
 	// 啟動伺服器
@@ -10,18 +10,20 @@ import (
 type Trip struct {
 	MongoID primitive.ObjectID `bson:"_id,omitempty" json:"-"`
 
-	ID          int         `json:"id" bson:"id"`
-	Name        string      `json:"name" bson:"name"`
-	Region      string      `json:"region" bson:"region"`
-	StartDate   string      `json:"start_date" bson:"start_date"`
-	Days        int         `json:"days" bson:"days"`
-	BudgetTWD   int         `json:"budget_twd" bson:"budget_twd"`
-	People      int         `json:"people" bson:"people"`
-	DailyHours  int         `json:"daily_hours" bson:"daily_hours"`
-	Preferences Preferences `json:"preferences" bson:"preferences"`
-	Plan        []Day       `json:"plan" bson:"plan"`
-	CreatedAt   time.Time   `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at" bson:"updated_at"`
+	ID          int          `json:"id" bson:"id"`
+	Name        string       `json:"name" bson:"name"`
+	Region      string       `json:"region" bson:"region"`
+	StartDate   string       `json:"start_date" bson:"start_date"`
+	Days        int          `json:"days" bson:"days"`
+	BudgetTWD   int          `json:"budget_twd" bson:"budget_twd"`
+	People      int          `json:"people" bson:"people"`
+	DailyHours  int          `json:"daily_hours" bson:"daily_hours"`
+	Preferences Preferences  `json:"preferences" bson:"preferences"`
+	Plan        []Day        `json:"plan" bson:"plan"`
+	CoverImage  *ImageResult `json:"cover_image,omitempty" bson:"cover_image,omitempty"` // 選定的封面圖片
+	Version     int          `json:"version" bson:"version"`                             // 每次修改行程 +1，用於衝突檢查
+	CreatedAt   time.Time    `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at" bson:"updated_at"`
 }
 
 type Preferences struct {
@@ -34,25 +36,31 @@ type Preferences struct {
 type Day struct {
 	DayIndex int    `json:"day_index"`
 	Date     string `json:"date"`
+	Note     string `json:"note,omitempty"` // 當天主題或備註
 	Items    []Item `json:"items"`
 }
 
 type Item struct {
-	ID          string  `json:"id"`
-	Time        string  `json:"time"`
-	DurationMin int     `json:"duration_min"`
-	Title       string  `json:"title"`
-	Address     string  `json:"address"`
-	Lat         float64 `json:"lat,omitempty"`
-	Lng         float64 `json:"lng,omitempty"`
-	Link        string  `json:"link"`
-	Note        string  `json:"note"`
+	ID          string       `json:"id"`
+	Time        string       `json:"time"`
+	DurationMin int          `json:"duration_min"`
+	Title       string       `json:"title"`
+	Address     string       `json:"address"`
+	Lat         float64      `json:"lat,omitempty"`
+	Lng         float64      `json:"lng,omitempty"`
+	Link        string       `json:"link"`
+	Note        string       `json:"note"`
+	Photo       *ImageResult `json:"photo,omitempty"` // 選定的照片，顯示時不再重新搜尋
 }
 
 // ChatRequest 前端傳來的請求格式
 type ChatRequest struct {
-	Message string     `json:"message"` // 使用者這次說的話
-	History []ChatPart `json:"history"` // 過去的對話歷史 (可選)
+	Message   string     `json:"message"`    // 使用者這次說的話
+	History   []ChatPart `json:"history"`    // 過去的對話歷史 (可選，沒有 session_id 時使用)
+	SessionID string     `json:"session_id"` // 伺服器端的對話 session (可選)
+	Template  string     `json:"template"`   // 以 session 的行程渲染 prompt 模板作為訊息，例如 trip_planning (可選)
+	Locale    string     `json:"locale"`     // zh-TW / en / ja，預設依 Accept-Language
+	TripID    int        `json:"trip_id"`    // 沒有 session_id 時，指定要釘選在上下文的行程 (可選)
 }
 
 // ChatPart 對話歷史的單一則訊息
@@ -60,3 +68,69 @@ type ChatPart struct {
 	Role string `json:"role"` // "user" (使用者) 或 "model" (AI)
 	Text string `json:"text"` // 訊息內容
 }
+
+// ChatSession 伺服器端保存的對話，依行程與使用者區分
+type ChatSession struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TripID       int                `json:"trip_id" bson:"trip_id"`
+	UserID       string             `json:"user_id" bson:"user_id"`
+	Title        string             `json:"title" bson:"title"`
+	SystemPrompt string             `json:"system_prompt,omitempty" bson:"system_prompt,omitempty"` // 前端的 SYSTEM_prompt 規劃指令
+	Messages     []ChatMessage      `json:"messages,omitempty" bson:"messages"`
+	Summary      string             `json:"summary,omitempty" bson:"summary,omitempty"`           // system_prompt 與前 SummaryUpTo 則訊息的摘要
+	SummaryUpTo  int                `json:"summary_upto,omitempty" bson:"summary_upto,omitempty"` // 摘要涵蓋的訊息數
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ChatMessage session 中的單一則訊息
+type ChatMessage struct {
+	Role      string      `json:"role" bson:"role"` // "user" 或 "model"
+	Text      string      `json:"text" bson:"text"`
+	Prompts   []PromptRef `json:"prompts,omitempty" bson:"prompts,omitempty"` // 產生此回覆的模板版本 (model 訊息)
+	CreatedAt time.Time   `json:"created_at" bson:"created_at"`
+}
+
+// PlanProposal AI 提出、等待使用者確認的行程修改
+type PlanProposal struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TripID      int                `json:"trip_id" bson:"trip_id"`
+	UserID      string             `json:"user_id" bson:"user_id"`
+	Message     string             `json:"message" bson:"message"`           // 使用者的要求
+	Summary     string             `json:"summary" bson:"summary"`           // 模型對整體修改的說明
+	BaseVersion int                `json:"base_version" bson:"base_version"` // 產生提案時的 Trip.Version
+	Status      string             `json:"status" bson:"status"`             // pending / accepted / partial / rejected
+	Changes     []PlanChange       `json:"changes" bson:"changes"`
+	Prompts     []PromptRef        `json:"prompts" bson:"prompts"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ResolvedAt  *time.Time         `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+// AIDraft 保存下來的 AI 回覆 (ai_drafts collection)，依行程、session 與 prompt 版本查詢
+type AIDraft struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TripID     int                `json:"trip_id" bson:"trip_id"` // 0 表示由舊檔案匯入、無法對應到行程
+	SessionID  string             `json:"session_id,omitempty" bson:"session_id,omitempty"`
+	UserID     string             `json:"user_id" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	Format     string             `json:"format" bson:"format"` // text / markdown / json
+	Text       string             `json:"text" bson:"text"`
+	Prompts    []PromptRef        `json:"prompts" bson:"prompts"`                             // 產生此回覆的模板版本
+	Source     string             `json:"source" bson:"source"`                               // chat / generate / import
+	SourceFile string             `json:"source_file,omitempty" bson:"source_file,omitempty"` // 匯入時的原始檔名
+	Pinned     bool               `json:"pinned" bson:"pinned"`                               // 釘選的草稿不受保留期限影響
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// PlanChange 提案中的單一修改，before / after 為修改前後的 item
+type PlanChange struct {
+	ID           string `json:"id" bson:"id"`
+	Op           string `json:"op" bson:"op"` // add / remove / move / modify
+	ItemID       string `json:"item_id,omitempty" bson:"item_id,omitempty"`
+	FromDayIndex int    `json:"from_day_index,omitempty" bson:"from_day_index,omitempty"`
+	DayIndex     int    `json:"day_index" bson:"day_index"`
+	Before       *Item  `json:"before,omitempty" bson:"before,omitempty"`
+	After        *Item  `json:"after,omitempty" bson:"after,omitempty"`
+	Reason       string `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status       string `json:"status" bson:"status"` // pending / accepted / rejected
+}
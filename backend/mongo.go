@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -12,6 +15,11 @@ import (
 // ========== MongoDB ==========
 var mongoClient *mongo.Client
 var tripsCollection *mongo.Collection
+var chatSessionsCollection *mongo.Collection
+var proposalsCollection *mongo.Collection
+var usageCollection *mongo.Collection
+var aiDraftsCollection *mongo.Collection
+var draftImportsCollection *mongo.Collection
 
 func initMongo() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -25,6 +33,162 @@ func initMongo() {
 	// 可以決定 db / collection 名稱
 	mongoClient = client
 	tripsCollection = client.Database("go_travel").Collection("trips")
+	chatSessionsCollection = client.Database("go_travel").Collection("chat_sessions")
+	proposalsCollection = client.Database("go_travel").Collection("plan_proposals")
+	usageCollection = client.Database("go_travel").Collection("llm_usage")
+	aiDraftsCollection = client.Database("go_travel").Collection("ai_drafts")
+	draftImportsCollection = client.Database("go_travel").Collection("ai_draft_imports")
+
+	if err := ensureAIDraftIndexes(ctx); err != nil {
+		log.Printf("ai_drafts 索引建立失敗: %v", err)
+	}
 
 	log.Println("MongoDB connected")
 }
+
+// ========== Trips 存取 ==========
+
+// findTripByID 依 id 讀取單一行程
+func findTripByID(ctx context.Context, id int) (Trip, error) {
+	var trip Trip
+	err := tripsCollection.FindOne(ctx, bson.M{"id": id}).Decode(&trip)
+	return trip, err
+}
+
+// errVersionConflict 行程在讀取之後已被其他請求修改
+var errVersionConflict = errors.New("trip version conflict")
+
+// saveTripPlan 只覆寫行程的 plan 欄位，並遞增 version
+func saveTripPlan(ctx context.Context, id int, plan []Day) error {
+	result, err := tripsCollection.UpdateOne(
+		ctx,
+		bson.M{"id": id},
+		bson.M{
+			"$set": bson.M{"plan": plan, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// saveTripPlanAt 與 saveTripPlan 相同，但只在 version 仍為 expected 時寫入，
+// 否則回傳 errVersionConflict
+func saveTripPlanAt(ctx context.Context, id int, plan []Day, expected int) error {
+	result, err := tripsCollection.UpdateOne(
+		ctx,
+		bson.M{"id": id, "version": versionFilter(expected)},
+		bson.M{
+			"$set": bson.M{"plan": plan, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := findTripByID(ctx, id); err != nil {
+			return err
+		}
+		return errVersionConflict
+	}
+	return nil
+}
+
+// saveTripPhotosAt 寫入 plan 與封面 (cover 為 nil 時不更動封面)，只在 version 仍為 expected 時寫入
+func saveTripPhotosAt(ctx context.Context, id int, plan []Day, cover *ImageResult, expected int) error {
+	set := bson.M{"plan": plan, "updated_at": time.Now()}
+	if cover != nil {
+		set["cover_image"] = cover
+	}
+	result, err := tripsCollection.UpdateOne(
+		ctx,
+		bson.M{"id": id, "version": versionFilter(expected)},
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := findTripByID(ctx, id); err != nil {
+			return err
+		}
+		return errVersionConflict
+	}
+	return nil
+}
+
+// saveTripCoverImage 設定或清除 (img 為 nil) 行程封面，並遞增 version
+func saveTripCoverImage(ctx context.Context, id int, img *ImageResult) error {
+	set := bson.M{"updated_at": time.Now()}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if img != nil {
+		set["cover_image"] = img
+	} else {
+		update["$unset"] = bson.M{"cover_image": ""}
+	}
+	result, err := tripsCollection.UpdateOne(ctx, bson.M{"id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// versionFilter 舊資料沒有 version 欄位，視為 0
+func versionFilter(v int) any {
+	if v == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return v
+}
+
+// ========== Chat sessions 存取 ==========
+
+// findChatSession 讀取屬於該使用者的 session
+func findChatSession(ctx context.Context, id primitive.ObjectID, userID string) (ChatSession, error) {
+	var s ChatSession
+	err := chatSessionsCollection.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&s)
+	return s, err
+}
+
+// appendChatMessages 以單一 $push 寫入這一輪的訊息，避免只存到一半
+func appendChatMessages(ctx context.Context, id primitive.ObjectID, msgs []ChatMessage, set bson.M) error {
+	if set == nil {
+		set = bson.M{}
+	}
+	set["updated_at"] = time.Now()
+
+	result, err := chatSessionsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$push": bson.M{"messages": bson.M{"$each": msgs}},
+			"$set":  set,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// saveChatSummary 更新 session 的摘要與涵蓋的訊息數
+func saveChatSummary(ctx context.Context, s *ChatSession, summary string, upto int) error {
+	s.Summary, s.SummaryUpTo = summary, upto
+	_, err := chatSessionsCollection.UpdateOne(ctx,
+		bson.M{"_id": s.ID},
+		bson.M{"$set": bson.M{"summary": summary, "summary_upto": upto}},
+	)
+	return err
+}
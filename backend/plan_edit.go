@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ========== Plan 編輯 ==========
+//
+// 直接修改 []Day 的純函式，讀寫資料庫由呼叫端負責。
+// item 以 ID 定位；新增的 item 依 finalizeDays 的格式 d{day}-{n} 編號。
+
+// PlanMutation 一次修改的摘要，回傳給前端顯示
+type PlanMutation struct {
+	Op       string `json:"op"`
+	DayIndex int    `json:"day_index,omitempty"`
+	ItemID   string `json:"item_id,omitempty"`
+	Summary  string `json:"summary"`
+}
+
+// ItemPatch 部分更新 item，nil 的欄位維持原值
+type ItemPatch struct {
+	Time        *string  `json:"time,omitempty"`
+	DurationMin *int     `json:"duration_min,omitempty"`
+	Title       *string  `json:"title,omitempty"`
+	Address     *string  `json:"address,omitempty"`
+	Lat         *float64 `json:"lat,omitempty"`
+	Lng         *float64 `json:"lng,omitempty"`
+	Link        *string  `json:"link,omitempty"`
+	Note        *string  `json:"note,omitempty"`
+}
+
+// apply 回傳套用 patch 後的 item
+func (p ItemPatch) apply(it Item) Item {
+	if p.Time != nil {
+		it.Time = *p.Time
+	}
+	if p.DurationMin != nil {
+		it.DurationMin = *p.DurationMin
+	}
+	if p.Title != nil {
+		it.Title = *p.Title
+	}
+	if p.Address != nil {
+		it.Address = *p.Address
+	}
+	if p.Lat != nil {
+		it.Lat = *p.Lat
+	}
+	if p.Lng != nil {
+		it.Lng = *p.Lng
+	}
+	if p.Link != nil {
+		it.Link = *p.Link
+	}
+	if p.Note != nil {
+		it.Note = *p.Note
+	}
+	return it
+}
+
+// planDay 取得指定 day_index 的那一天
+func planDay(plan []Day, dayIndex int) (*Day, error) {
+	for i := range plan {
+		if plan[i].DayIndex == dayIndex {
+			return &plan[i], nil
+		}
+	}
+	return nil, fmt.Errorf("第 %d 天不存在", dayIndex)
+}
+
+// clonePlan 複製 plan，修改副本不會影響原本的 items
+func clonePlan(plan []Day) []Day {
+	out := append([]Day(nil), plan...)
+	for i := range out {
+		out[i].Items = append([]Item(nil), plan[i].Items...)
+	}
+	return out
+}
+
+// tripWithoutPhotos 放進 prompt 前拿掉封面與照片的 metadata，避免浪費 token
+func tripWithoutPhotos(trip Trip) Trip {
+	trip.CoverImage = nil
+	trip.Plan = planWithoutPhotos(trip.Plan)
+	return trip
+}
+
+func planWithoutPhotos(plan []Day) []Day {
+	out := clonePlan(plan)
+	for d := range out {
+		for i := range out[d].Items {
+			out[d].Items[i].Photo = nil
+		}
+	}
+	return out
+}
+
+// findPlanItem 回傳 item 所在的 plan 與 items 索引
+func findPlanItem(plan []Day, itemID string) (int, int, bool) {
+	for d := range plan {
+		for i := range plan[d].Items {
+			if plan[d].Items[i].ID == itemID {
+				return d, i, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// nextItemID 產生該天尚未使用的 item ID
+func nextItemID(plan []Day, dayIndex int) string {
+	for n := 1; ; n++ {
+		id := fmt.Sprintf("d%d-%d", dayIndex, n)
+		if _, _, ok := findPlanItem(plan, id); !ok {
+			return id
+		}
+	}
+}
+
+// sortDayItems 依時間排序，沒有時間的排在最後
+func sortDayItems(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Time, items[j].Time
+		if a == "" || b == "" {
+			return a != "" && b == ""
+		}
+		return a < b
+	})
+}
+
+// planAddItem 在指定的那一天加入 item，回傳含 ID 的 item
+func planAddItem(plan []Day, dayIndex int, it Item) (Item, error) {
+	day, err := planDay(plan, dayIndex)
+	if err != nil {
+		return Item{}, err
+	}
+	if strings.TrimSpace(it.Title) == "" {
+		return Item{}, fmt.Errorf("title 不可為空")
+	}
+	if it.Time != "" && !reClock.MatchString(it.Time) {
+		return Item{}, fmt.Errorf("time %q 不是 HH:MM", it.Time)
+	}
+	if it.ID == "" {
+		it.ID = nextItemID(plan, dayIndex)
+	}
+	day.Items = append(day.Items, it)
+	sortDayItems(day.Items)
+	return it, nil
+}
+
+// planRemoveItem 移除 item，回傳被移除的 item 與原本的 day_index
+func planRemoveItem(plan []Day, itemID string) (Item, int, error) {
+	d, i, ok := findPlanItem(plan, itemID)
+	if !ok {
+		return Item{}, 0, fmt.Errorf("找不到 item %s", itemID)
+	}
+	it := plan[d].Items[i]
+	plan[d].Items = append(plan[d].Items[:i], plan[d].Items[i+1:]...)
+	return it, plan[d].DayIndex, nil
+}
+
+// planMoveItem 將 item 移到另一天 (或同一天的其他時間)；newTime 為空字串時保留原時間
+func planMoveItem(plan []Day, itemID string, toDay int, newTime string) (Item, int, error) {
+	if _, err := planDay(plan, toDay); err != nil {
+		return Item{}, 0, err
+	}
+	if newTime != "" && !reClock.MatchString(newTime) {
+		return Item{}, 0, fmt.Errorf("time %q 不是 HH:MM", newTime)
+	}
+
+	it, fromDay, err := planRemoveItem(plan, itemID)
+	if err != nil {
+		return Item{}, 0, err
+	}
+	if newTime != "" {
+		it.Time = newTime
+	}
+	day, _ := planDay(plan, toDay)
+	day.Items = append(day.Items, it)
+	sortDayItems(day.Items)
+	return it, fromDay, nil
+}
+
+// planUpdateItem 以 patch 更新 item，回傳修改前後的內容
+func planUpdateItem(plan []Day, itemID string, patch ItemPatch) (Item, Item, error) {
+	d, i, ok := findPlanItem(plan, itemID)
+	if !ok {
+		return Item{}, Item{}, fmt.Errorf("找不到 item %s", itemID)
+	}
+	before := plan[d].Items[i]
+	after := patch.apply(before)
+	if strings.TrimSpace(after.Title) == "" {
+		return Item{}, Item{}, fmt.Errorf("title 不可為空")
+	}
+	if after.Time != "" && !reClock.MatchString(after.Time) {
+		return Item{}, Item{}, fmt.Errorf("time %q 不是 HH:MM", after.Time)
+	}
+	plan[d].Items[i] = after
+	sortDayItems(plan[d].Items)
+	return before, after, nil
+}
+
+// planSetDayNote 設定當天的主題或備註，回傳原本的內容
+func planSetDayNote(plan []Day, dayIndex int, note string) (string, error) {
+	day, err := planDay(plan, dayIndex)
+	if err != nil {
+		return "", err
+	}
+	before := day.Note
+	day.Note = note
+	return before, nil
+}
+
+// mergeItem 以 patch 中非零值的欄位覆寫 base (模型輸出的 modify 只會填要改的欄位)
+func mergeItem(base, patch Item) Item {
+	if patch.Time != "" {
+		base.Time = patch.Time
+	}
+	if patch.DurationMin != 0 {
+		base.DurationMin = patch.DurationMin
+	}
+	if patch.Title != "" {
+		base.Title = patch.Title
+	}
+	if patch.Address != "" {
+		base.Address = patch.Address
+	}
+	if patch.Lat != 0 {
+		base.Lat = patch.Lat
+	}
+	if patch.Lng != 0 {
+		base.Lng = patch.Lng
+	}
+	if patch.Link != "" {
+		base.Link = patch.Link
+	}
+	if patch.Note != "" {
+		base.Note = patch.Note
+	}
+	return base
+}
+
+// applyPlanChange 將提案中的一筆修改套用到 plan
+func applyPlanChange(plan []Day, ch PlanChange) error {
+	switch ch.Op {
+	case "add":
+		if ch.After == nil {
+			return fmt.Errorf("%s: add 缺少 after", ch.ID)
+		}
+		it := *ch.After
+		it.ID = ""
+		_, err := planAddItem(plan, ch.DayIndex, it)
+		return err
+	case "remove":
+		_, _, err := planRemoveItem(plan, ch.ItemID)
+		return err
+	case "move":
+		newTime := ""
+		if ch.After != nil {
+			newTime = ch.After.Time
+		}
+		_, _, err := planMoveItem(plan, ch.ItemID, ch.DayIndex, newTime)
+		return err
+	case "modify":
+		if ch.After == nil {
+			return fmt.Errorf("%s: modify 缺少 after", ch.ID)
+		}
+		d, i, ok := findPlanItem(plan, ch.ItemID)
+		if !ok {
+			return fmt.Errorf("找不到 item %s", ch.ItemID)
+		}
+		it := *ch.After
+		it.ID = ch.ItemID
+		if it.Time != "" && !reClock.MatchString(it.Time) {
+			return fmt.Errorf("time %q 不是 HH:MM", it.Time)
+		}
+		plan[d].Items[i] = it
+		sortDayItems(plan[d].Items)
+		return nil
+	default:
+		return fmt.Errorf("%s: 未知的 op %q", ch.ID, ch.Op)
+	}
+}
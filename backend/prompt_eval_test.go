@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ========== prompt 評估 ==========
+//
+// testdata/eval/scenarios.json 是一組情境 (IATA 地點、行程 JSON、對話)，
+// 每個情境以指定的 prompt 版本呼叫模型，再用程式化的檢查打分數 (通過的檢查 / 全部檢查)。
+//
+// 模型回覆存在 testdata/eval/fixtures/<prompt>/<version>/<scenario>.json，
+// 平常的 go test 只重播 fixture，不需要網路；模板改過而 fixture 沒重錄時會失敗。
+//
+//	go test -run TestPromptEval -eval.record                       以 .env 設定的 provider 重錄目前版本
+//	go test -run TestPromptEval -eval.record -eval.versions=v1,v2  重錄兩個版本
+//	go test -run TestPromptEval -eval.versions=v1,v2 -v            重播並印出兩個版本的比較報告
+//	go test -run TestPromptEval -eval.prompt=iata                  只跑某個 prompt 的情境
+
+var (
+	evalRecord   = flag.Bool("eval.record", false, "呼叫真實的 LLM provider 並重錄 testdata/eval 的 fixture")
+	evalVersions = flag.String("eval.versions", "", "要評估的 prompt 版本，以逗號分隔 (兩個時印出比較報告)；空字串為目前版本")
+	evalPrompt   = flag.String("eval.prompt", "", "只評估使用此 prompt 的情境")
+)
+
+const evalDir = "testdata/eval"
+
+// evalScenario 一個評估情境
+type evalScenario struct {
+	Name     string  `json:"name"`
+	Kind     string  `json:"kind"` // iata / plan / chat
+	Locale   string  `json:"locale"`
+	MinScore float64 `json:"min_score"` // 重播時分數不可低於此值
+
+	// iata
+	Location   string   `json:"location,omitempty"`
+	ExpectIATA []string `json:"expect_iata,omitempty"` // 可接受的代碼
+
+	// plan
+	Trip *Trip `json:"trip,omitempty"`
+
+	// chat
+	History        []ChatPart `json:"history,omitempty"`
+	Message        string     `json:"message,omitempty"`
+	ExpectContains []string   `json:"expect_contains,omitempty"` // 回覆必須提到的字 (不分大小寫)
+	MaxChars       int        `json:"max_chars,omitempty"`
+}
+
+// evalPromptNames 各類情境評估的 prompt
+var evalPromptNames = map[string]string{
+	"iata": "iata",
+	"plan": "trip_planning",
+	"chat": "tour_guide_system",
+}
+
+func (s evalScenario) promptName() string { return evalPromptNames[s.Kind] }
+
+// evalCheck 一項檢查的結果
+type evalCheck struct {
+	Name   string
+	Pass   bool
+	Detail string
+}
+
+// evalResult 一個情境在某個版本的結果
+type evalResult struct {
+	Scenario string
+	Version  string
+	Checks   []evalCheck
+	Err      error
+}
+
+// Score 通過的檢查比例；呼叫失敗為 0
+func (r evalResult) Score() float64 {
+	if r.Err != nil || len(r.Checks) == 0 {
+		return 0
+	}
+	passed := 0
+	for _, c := range r.Checks {
+		if c.Pass {
+			passed++
+		}
+	}
+	return float64(passed) / float64(len(r.Checks))
+}
+
+// Failed 沒通過的檢查
+func (r evalResult) Failed() []string {
+	if r.Err != nil {
+		return []string{"error: " + r.Err.Error()}
+	}
+	var out []string
+	for _, c := range r.Checks {
+		if !c.Pass {
+			out = append(out, c.Name+": "+c.Detail)
+		}
+	}
+	return out
+}
+
+func loadEvalScenarios(path string) ([]evalScenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var scenarios []evalScenario
+	if err := json.Unmarshal(data, &scenarios); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, s := range scenarios {
+		if s.promptName() == "" {
+			return nil, fmt.Errorf("情境 %s 的 kind %q 不支援", s.Name, s.Kind)
+		}
+	}
+	return scenarios, nil
+}
+
+// ========== record / replay ==========
+
+// evalFixture 一次模型呼叫的錄製結果
+type evalFixture struct {
+	Scenario    string          `json:"scenario"`
+	Prompt      PromptRef       `json:"prompt"`
+	Provider    string          `json:"provider"`
+	Model       string          `json:"model,omitempty"`
+	RequestHash string          `json:"request_hash"`
+	Request     evalFixtureCall `json:"request"`
+	Response    string          `json:"response"`
+	RecordedAt  time.Time       `json:"recorded_at"`
+}
+
+// evalFixtureCall 送出的內容，寫進 fixture 方便 review diff
+type evalFixtureCall struct {
+	System  string     `json:"system,omitempty"`
+	History []ChatPart `json:"history,omitempty"`
+	Prompt  string     `json:"prompt"`
+}
+
+func evalFixturePath(dir string, ref PromptRef, scenario string) string {
+	return filepath.Join(dir, "fixtures", ref.Name, ref.Version, scenario+".json")
+}
+
+// evalRequestHash 只看送給模型的內容；模板改過時 hash 會變，舊的 fixture 就不能重播
+func evalRequestHash(call evalFixtureCall) string {
+	data, _ := json.Marshal(call)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+var errEvalFixtureStale = errors.New("fixture 與目前的 prompt 不符，請以 -eval.record 重錄")
+
+// fixtureProvider record 時呼叫內嵌的真實 provider 並寫入 fixture；replay 時 (LLMProvider 為 nil) 讀取 fixture
+type fixtureProvider struct {
+	LLMProvider
+	path     string
+	scenario string
+	ref      PromptRef
+}
+
+func (p *fixtureProvider) Name() string { return "fixture" }
+
+func (p *fixtureProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.call(ctx, req, func() (*LLMResponse, error) { return p.LLMProvider.Generate(ctx, req) })
+}
+
+func (p *fixtureProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return p.call(ctx, req, func() (*LLMResponse, error) { return p.LLMProvider.Chat(ctx, req) })
+}
+
+func (p *fixtureProvider) call(ctx context.Context, req LLMRequest, live func() (*LLMResponse, error)) (*LLMResponse, error) {
+	call := evalFixtureCall{System: req.System, History: req.History, Prompt: req.Prompt}
+	hash := evalRequestHash(call)
+
+	if p.LLMProvider == nil {
+		data, err := os.ReadFile(p.path)
+		if err != nil {
+			return nil, fmt.Errorf("讀取 fixture: %w (請以 -eval.record 錄製)", err)
+		}
+		var f evalFixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.path, err)
+		}
+		if f.RequestHash != hash {
+			return nil, fmt.Errorf("%s: %w", p.path, errEvalFixtureStale)
+		}
+		return &LLMResponse{Text: f.Response, Model: f.Model}, nil
+	}
+
+	res, err := live()
+	if err != nil {
+		return nil, err
+	}
+	f := evalFixture{
+		Scenario:    p.scenario,
+		Prompt:      p.ref,
+		Provider:    p.LLMProvider.Name(),
+		Model:       res.Model,
+		RequestHash: hash,
+		Request:     call,
+		Response:    res.Text,
+		RecordedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return nil, err
+	}
+	return res, os.WriteFile(p.path, append(data, '\n'), 0644)
+}
+
+// ========== 執行與檢查 ==========
+
+// runEvalScenario 以指定版本渲染 prompt、呼叫模型並檢查輸出
+func runEvalScenario(ctx context.Context, dir string, live LLMProvider, s evalScenario, version string) evalResult {
+	res := evalResult{Scenario: s.Name, Version: version}
+
+	var vars any
+	switch s.Kind {
+	case "plan":
+		if s.Trip == nil {
+			res.Err = errors.New("plan 情境缺少 trip")
+			return res
+		}
+		vars = TripPromptVars{Trip: *s.Trip}
+	case "iata":
+		vars = IATAPromptVars{Location: s.Location}
+	}
+	text, ref, err := prompts.RenderVersion(s.promptName(), version, s.Locale, vars)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	llm := &fixtureProvider{LLMProvider: live, path: evalFixturePath(dir, ref, s.Name), scenario: s.Name, ref: ref}
+
+	switch s.Kind {
+	case "iata":
+		out, err := llm.Generate(ctx, LLMRequest{Prompt: text, Temperature: float32Ptr(0.0)})
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.Checks = checkIATA(out.Text, s.ExpectIATA)
+
+	case "plan":
+		system, _ := tourGuideSystem(s.Locale)
+		out, err := llm.Chat(ctx, LLMRequest{System: system, Prompt: systemPromptPrefix + " " + text})
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.Checks = checkPlan(ParseItinerary(out.Text, s.Trip.StartDate), *s.Trip)
+
+	case "chat":
+		out, err := llm.Chat(ctx, LLMRequest{System: text, History: s.History, Prompt: s.Message})
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.Checks = checkChat(out.Text, s)
+	}
+	return res
+}
+
+var reIATAStrict = regexp.MustCompile(`^[A-Z]{3}$`)
+
+func checkIATA(text string, expect []string) []evalCheck {
+	code := parseIATACode(text)
+	checks := []evalCheck{
+		{"format", reIATAStrict.MatchString(strings.TrimSpace(text)), fmt.Sprintf("回覆 %q 不是單獨的 3 個大寫字母", text)},
+		{"known", code != "UNK", "模型回傳 UNK"},
+	}
+	if len(expect) > 0 {
+		found := false
+		for _, e := range expect {
+			found = found || code == e
+		}
+		checks = append(checks, evalCheck{"expected", found, fmt.Sprintf("%s 不在 %s 之中", code, strings.Join(expect, "/"))})
+	}
+	return checks
+}
+
+func checkPlan(parsed ParseResult, trip Trip) []evalCheck {
+	days := 0
+	present := map[int]bool{}
+	empty := []string{}
+	for _, d := range parsed.Days {
+		if d.DayIndex < 1 {
+			continue // 模型常加上出發前的 Day0
+		}
+		days++
+		present[d.DayIndex] = true
+		if len(d.Items) == 0 {
+			empty = append(empty, fmt.Sprint(d.DayIndex))
+		}
+	}
+	var missing []string
+	for i := 1; i <= trip.Days; i++ {
+		if !present[i] {
+			missing = append(missing, fmt.Sprint(i))
+		}
+	}
+	emptyDetail := "沒有 item 的天數：" + strings.Join(empty, "、")
+	if days == 0 {
+		emptyDetail = "沒有解析出任何一天"
+	}
+	return []evalCheck{
+		{"days_parsed", days == trip.Days, fmt.Sprintf("解析出 %d 天，應為 %d 天", days, trip.Days)},
+		{"all_days_present", len(missing) == 0, "缺少第 " + strings.Join(missing, "、") + " 天"},
+		{"items_every_day", days > 0 && len(empty) == 0, emptyDetail},
+		{"nothing_unparsed", len(parsed.Unparsed) == 0, fmt.Sprintf("%d 個無法解析的片段", len(parsed.Unparsed))},
+	}
+}
+
+func checkChat(text string, s evalScenario) []evalCheck {
+	checks := []evalCheck{{"non_empty", strings.TrimSpace(text) != "", "回覆是空的"}}
+	lower := strings.ToLower(text)
+	for _, want := range s.ExpectContains {
+		checks = append(checks, evalCheck{"contains:" + want, strings.Contains(lower, strings.ToLower(want)), "沒有提到 " + want})
+	}
+	if s.MaxChars > 0 {
+		n := len([]rune(text))
+		checks = append(checks, evalCheck{"max_chars", n <= s.MaxChars, fmt.Sprintf("%d 字，上限 %d", n, s.MaxChars)})
+	}
+	return checks
+}
+
+// ========== 報告 ==========
+
+// writeEvalReport 印出每個情境在兩個版本的分數與退步的檢查
+func writeEvalReport(w io.Writer, a, b string, results map[string][2]evalResult) {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "scenario\t%s\t%s\tdelta\t%s failed\n", a, b, b)
+	var sumA, sumB float64
+	for _, name := range names {
+		r := results[name]
+		sa, sb := r[0].Score(), r[1].Score()
+		sumA += sa
+		sumB += sb
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%+.2f\t%s\n", name, sa, sb, sb-sa, strings.Join(r[1].Failed(), "; "))
+	}
+	if n := float64(len(names)); n > 0 {
+		fmt.Fprintf(tw, "average\t%.2f\t%.2f\t%+.2f\t\n", sumA/n, sumB/n, (sumB-sumA)/n)
+	}
+	tw.Flush()
+}
+
+// ========== tests ==========
+
+func TestPromptEval(t *testing.T) {
+	scenarios, err := loadEvalScenarios(filepath.Join(evalDir, "scenarios.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var live func(task string) LLMProvider
+	if *evalRecord {
+		godotenv.Load()
+		initLLM()
+		live = func(task string) LLMProvider {
+			p, err := llmFor(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		}
+	}
+
+	var versions []string
+	if *evalVersions != "" {
+		versions = strings.Split(*evalVersions, ",")
+	}
+	if len(versions) > 2 {
+		t.Fatal("-eval.versions 最多兩個版本")
+	}
+
+	ctx := context.Background()
+	compare := map[string]map[string][2]evalResult{} // prompt -> scenario -> 兩個版本的結果
+
+	for _, s := range scenarios {
+		name := s.promptName()
+		if *evalPrompt != "" && name != *evalPrompt {
+			continue
+		}
+		vs := versions
+		if len(vs) == 0 {
+			vs = []string{prompts.activeVersion(name)}
+		}
+
+		var provider LLMProvider
+		if live != nil {
+			task := taskChat
+			if s.Kind == "iata" {
+				task = taskIATA
+			}
+			provider = live(task)
+		}
+
+		var pair [2]evalResult
+		for i, v := range vs {
+			r := runEvalScenario(ctx, evalDir, provider, s, v)
+			pair[i] = r
+			t.Logf("%s %s@%s score %.2f", s.Name, name, v, r.Score())
+			if r.Err != nil {
+				t.Errorf("%s %s@%s: %v", s.Name, name, v, r.Err)
+				continue
+			}
+			// 只有目前版本要求達到 min_score，比較其他版本時只看報告
+			if len(versions) == 0 && r.Score() < s.MinScore {
+				t.Errorf("%s %s@%s score %.2f < min_score %.2f: %s", s.Name, name, v, r.Score(), s.MinScore, strings.Join(r.Failed(), "; "))
+			}
+		}
+		if len(vs) == 2 {
+			if compare[name] == nil {
+				compare[name] = map[string][2]evalResult{}
+			}
+			compare[name][s.Name] = pair
+		}
+	}
+
+	for name, results := range compare {
+		var b strings.Builder
+		writeEvalReport(&b, versions[0], versions[1], results)
+		fmt.Printf("\n== %s: %s vs %s ==\n%s", name, versions[0], versions[1], b.String())
+	}
+}
+
+func TestEvalChecks(t *testing.T) {
+	if got := (evalResult{Checks: checkIATA("KIX", []string{"KIX", "OSA"})}).Score(); got != 1 {
+		t.Errorf("KIX score = %.2f", got)
+	}
+	// 多話的回覆仍可解析，但 format 不通過
+	r := evalResult{Checks: checkIATA("The code is NRT.", []string{"TYO"})}
+	if got := strings.Join(r.Failed(), "\n"); !strings.Contains(got, "format") || !strings.Contains(got, "expected") || strings.Contains(got, "known") {
+		t.Errorf("failed = %s", got)
+	}
+
+	trip := Trip{StartDate: "2025-04-22", Days: 3}
+	parsed := ParseResult{Days: []Day{
+		{DayIndex: 0, Items: []Item{{Title: "抵達"}}},
+		{DayIndex: 1, Items: []Item{{Title: "淺草寺"}}},
+		{DayIndex: 3},
+	}}
+	r = evalResult{Checks: checkPlan(parsed, trip)}
+	if got := strings.Join(r.Failed(), "\n"); !strings.Contains(got, "解析出 2 天") || !strings.Contains(got, "缺少第 2 天") || !strings.Contains(got, "沒有 item 的天數：3") {
+		t.Errorf("failed = %s", got)
+	}
+	if r.Score() != 0.25 {
+		t.Errorf("score = %.2f", r.Score())
+	}
+
+	r = evalResult{Checks: checkChat("建議去 Kyoto National Museum。", evalScenario{ExpectContains: []string{"museum"}, MaxChars: 10})}
+	if got := strings.Join(r.Failed(), "\n"); !strings.HasPrefix(got, "max_chars") {
+		t.Errorf("failed = %s", got)
+	}
+}
+
+func TestEvalRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := evalScenario{Name: "kansai", Kind: "iata", Locale: "zh-TW", Location: "關西", ExpectIATA: []string{"KIX"}}
+
+	// record：寫入 fixture
+	live := newFakeProvider().On("關西", "KIX")
+	if r := runEvalScenario(context.Background(), dir, live, s, "v1"); r.Err != nil || r.Score() != 1 {
+		t.Fatalf("record: %+v", r)
+	}
+	if len(live.Calls()) != 1 {
+		t.Fatalf("calls = %d", len(live.Calls()))
+	}
+
+	// replay：不呼叫 provider
+	if r := runEvalScenario(context.Background(), dir, nil, s, "v1"); r.Err != nil || r.Score() != 1 {
+		t.Fatalf("replay: %+v", r)
+	}
+
+	// prompt 內容改變 (這裡以不同的地點模擬) 時 fixture 失效
+	s.Location = "大阪"
+	if r := runEvalScenario(context.Background(), dir, nil, s, "v1"); !errors.Is(r.Err, errEvalFixtureStale) {
+		t.Errorf("stale fixture err = %v", r.Err)
+	}
+}
+
+func TestWriteEvalReport(t *testing.T) {
+	pass := evalCheck{Name: "format", Pass: true}
+	fail := evalCheck{Name: "expected", Detail: "NRT 不在 TYO 之中"}
+	results := map[string][2]evalResult{
+		"tokyo":  {{Checks: []evalCheck{pass, pass}}, {Checks: []evalCheck{pass, fail}}},
+		"kansai": {{Checks: []evalCheck{pass, fail}}, {Checks: []evalCheck{pass, pass}}},
+	}
+	var b strings.Builder
+	writeEvalReport(&b, "v1", "v2", results)
+	out := b.String()
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "kansai") || !strings.Contains(lines[2], "-0.50") || !strings.Contains(lines[2], "NRT 不在 TYO 之中") {
+		t.Errorf("report:\n%s", out)
+	}
+	if !strings.Contains(lines[3], "average") || !strings.Contains(lines[3], "+0.00") {
+		t.Errorf("average line = %q", lines[3])
+	}
+}
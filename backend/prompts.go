@@ -0,0 +1,342 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Prompt 模板 ==========
+//
+// 所有 prompt 放在 prompts/<name>/<version>.<locale>.tmpl，以 text/template 渲染：
+//
+//	prompts/iata/v1.zh-TW.tmpl
+//	prompts/iata/v1.en.tmpl
+//
+// 每個模板名稱對應一個變數型別 (promptVarTypes)，載入時會用零值試渲染，
+// 欄位打錯在啟動 (與 go test) 時就會發現。預設使用最新版本，
+// 可用 PROMPT_VERSION_<NAME>=v1 固定版本；語系缺少時退回 zh-TW。
+
+//go:embed prompts
+var promptFiles embed.FS
+
+// 預設語系與支援的語系
+const defaultLocale = "zh-TW"
+
+var promptLocales = []string{"zh-TW", "en", "ja"}
+
+// IATAPromptVars iata 模板的變數
+type IATAPromptVars struct {
+	Location string
+}
+
+// TripPromptVars 以行程為變數的模板 (trip_planning、trip_context)
+type TripPromptVars struct {
+	Trip Trip
+}
+
+// ChatSummaryPromptVars chat_summary 模板的變數
+type ChatSummaryPromptVars struct {
+	Transcript string
+}
+
+// DayRegeneratePromptVars day_regenerate 模板的變數
+type DayRegeneratePromptVars struct {
+	Trip        Trip
+	Day         Day
+	Fixed       []Item // 保留不動的 item
+	Targets     []Item // 要被取代的 item
+	Scoped      bool   // 是否只重排 From-To 時段
+	From        string
+	To          string
+	Constraints RegenerateConstraints
+	Message     string
+}
+
+// PhotoPlacePromptVars photo_place 模板的變數
+type PhotoPlacePromptVars struct {
+	Region string // 行程的地區，幫助模型縮小範圍
+	Hint   string // 使用者的補充說明
+}
+
+// TripFromTextPromptVars trip_from_text 模板的變數
+type TripFromTextPromptVars struct {
+	Text  string // 使用者的描述
+	Today string // YYYY-MM-DD，用來補上沒寫年份的日期
+}
+
+// PlanGeneratePromptVars plan_generate 模板的變數
+type PlanGeneratePromptVars struct {
+	Trip     Trip
+	Problems []string // 上一次輸出的違規項目，重試時才有
+}
+
+// PlanProposalPromptVars plan_proposal 模板的變數
+type PlanProposalPromptVars struct {
+	Trip    Trip
+	Plan    []Day // 目前的 plan，每個 item 都有 id
+	Message string
+}
+
+// promptVarTypes 模板名稱 -> 變數型別 (nil 表示沒有變數)
+var promptVarTypes = map[string]reflect.Type{
+	"tour_guide_system": nil,
+	"iata":              reflect.TypeOf(IATAPromptVars{}),
+	"trip_planning":     reflect.TypeOf(TripPromptVars{}),
+	"trip_context":      reflect.TypeOf(TripPromptVars{}),
+	"chat_summary":      reflect.TypeOf(ChatSummaryPromptVars{}),
+	"day_regenerate":    reflect.TypeOf(DayRegeneratePromptVars{}),
+	"photo_place":       reflect.TypeOf(PhotoPlacePromptVars{}),
+	"trip_from_text":    reflect.TypeOf(TripFromTextPromptVars{}),
+	"plan_generate":     reflect.TypeOf(PlanGeneratePromptVars{}),
+	"plan_proposal":     reflect.TypeOf(PlanProposalPromptVars{}),
+}
+
+// PromptRef 記錄 AI 輸出是由哪個模板版本產生的
+type PromptRef struct {
+	Name    string `json:"name" bson:"name"`
+	Version string `json:"version" bson:"version"`
+	Locale  string `json:"locale" bson:"locale"`
+}
+
+func (r PromptRef) String() string {
+	return fmt.Sprintf("%s@%s/%s", r.Name, r.Version, r.Locale)
+}
+
+// PromptInfo GET /api/prompts 的單一模板資訊
+type PromptInfo struct {
+	Name     string              `json:"name"`
+	Active   string              `json:"active"` // 目前使用的版本
+	Versions map[string][]string `json:"versions"`
+}
+
+type promptRegistry struct {
+	// name -> version -> locale -> template
+	templates map[string]map[string]map[string]*template.Template
+}
+
+// prompts 全域模板，模板隨程式一起編譯，載入失敗代表程式本身有錯
+var prompts = mustLoadPrompts(promptFiles)
+
+func mustLoadPrompts(fsys fs.FS) *promptRegistry {
+	r, err := loadPromptRegistry(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+var promptFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"jsonIndent": func(v any) (string, error) {
+		b, err := json.MarshalIndent(v, "", "  ")
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// loadPromptRegistry 讀取 prompts/ 下所有模板並檢查變數
+func loadPromptRegistry(fsys fs.FS) (*promptRegistry, error) {
+	r := &promptRegistry{templates: map[string]map[string]map[string]*template.Template{}}
+
+	err := fs.WalkDir(fsys, "prompts", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tmpl") {
+			return err
+		}
+
+		name := path.Base(path.Dir(p))
+		version, locale, ok := strings.Cut(strings.TrimSuffix(path.Base(p), ".tmpl"), ".")
+		if !ok || versionNumber(version) < 0 {
+			return fmt.Errorf("prompt %s: 檔名應為 <version>.<locale>.tmpl", p)
+		}
+		varType, known := promptVarTypes[name]
+		if !known {
+			return fmt.Errorf("prompt %s: 未註冊的模板 %q", p, name)
+		}
+
+		src, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(p).Funcs(promptFuncs).Option("missingkey=error").Parse(string(src))
+		if err != nil {
+			return fmt.Errorf("prompt %s: %w", p, err)
+		}
+
+		// 以零值試渲染，確認模板用到的欄位都存在
+		var zero any
+		if varType != nil {
+			zero = reflect.New(varType).Elem().Interface()
+		}
+		if err := t.Execute(&strings.Builder{}, zero); err != nil {
+			return fmt.Errorf("prompt %s: %w", p, err)
+		}
+
+		if r.templates[name] == nil {
+			r.templates[name] = map[string]map[string]*template.Template{}
+		}
+		if r.templates[name][version] == nil {
+			r.templates[name][version] = map[string]*template.Template{}
+		}
+		r.templates[name][version][locale] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range promptVarTypes {
+		for version, locales := range r.templates[name] {
+			if locales[defaultLocale] == nil {
+				return nil, fmt.Errorf("prompt %s@%s 缺少預設語系 %s", name, version, defaultLocale)
+			}
+		}
+		if len(r.templates[name]) == 0 {
+			return nil, fmt.Errorf("prompt %s 沒有任何模板", name)
+		}
+	}
+	return r, nil
+}
+
+// versionNumber 解析 v1 / v2 ...，格式不符回傳 -1
+func versionNumber(v string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+	if !strings.HasPrefix(v, "v") || err != nil {
+		return -1
+	}
+	return n
+}
+
+// Versions 依版本號排序的所有版本
+func (r *promptRegistry) Versions(name string) []string {
+	var out []string
+	for v := range r.templates[name] {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return versionNumber(out[i]) < versionNumber(out[j]) })
+	return out
+}
+
+// activeVersion 目前使用的版本：PROMPT_VERSION_<NAME> 或最新版
+func (r *promptRegistry) activeVersion(name string) string {
+	if v := os.Getenv("PROMPT_VERSION_" + strings.ToUpper(name)); v != "" {
+		if _, ok := r.templates[name][v]; ok {
+			return v
+		}
+	}
+	versions := r.Versions(name)
+	if len(versions) == 0 {
+		return ""
+	}
+	return versions[len(versions)-1]
+}
+
+// Render 以目前使用的版本渲染模板
+func (r *promptRegistry) Render(name, locale string, vars any) (string, PromptRef, error) {
+	return r.RenderVersion(name, r.activeVersion(name), locale, vars)
+}
+
+// RenderVersion 以指定版本渲染模板；vars 的型別必須與 promptVarTypes 相同
+func (r *promptRegistry) RenderVersion(name, version, locale string, vars any) (string, PromptRef, error) {
+	ref := PromptRef{Name: name, Version: version}
+
+	versions, ok := r.templates[name]
+	if !ok {
+		return "", ref, fmt.Errorf("prompt %q 不存在", name)
+	}
+	locales, ok := versions[version]
+	if !ok {
+		return "", ref, fmt.Errorf("prompt %s 沒有版本 %q", name, version)
+	}
+	if want := promptVarTypes[name]; reflect.TypeOf(vars) != want {
+		return "", ref, fmt.Errorf("prompt %s 的變數型別應為 %v，實際為 %T", name, want, vars)
+	}
+
+	ref.Locale = normalizeLocale(locale)
+	t := locales[ref.Locale]
+	if t == nil {
+		ref.Locale = defaultLocale
+		t = locales[defaultLocale]
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", ref, err
+	}
+	return strings.TrimSpace(b.String()), ref, nil
+}
+
+// List 列出所有模板與語系
+func (r *promptRegistry) List() []PromptInfo {
+	var out []PromptInfo
+	for name, versions := range r.templates {
+		info := PromptInfo{Name: name, Active: r.activeVersion(name), Versions: map[string][]string{}}
+		for v, locales := range versions {
+			for l := range locales {
+				info.Versions[v] = append(info.Versions[v], l)
+			}
+			sort.Strings(info.Versions[v])
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// normalizeLocale 將 zh / zh-Hant / en-US / ja-JP 等對應到支援的語系，無法對應時回傳預設語系
+func normalizeLocale(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, l := range promptLocales {
+		if s == strings.ToLower(l) {
+			return l
+		}
+	}
+	switch {
+	case strings.HasPrefix(s, "zh"):
+		return "zh-TW"
+	case strings.HasPrefix(s, "en"):
+		return "en"
+	case strings.HasPrefix(s, "ja"):
+		return "ja"
+	}
+	return defaultLocale
+}
+
+// requestLocale 依 ?locale= 或 Accept-Language 決定語系
+func requestLocale(c *gin.Context) string {
+	if l := c.Query("locale"); l != "" {
+		return normalizeLocale(l)
+	}
+	// 只取第一個語系，例如 "ja-JP,ja;q=0.9,en;q=0.8"
+	first, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+	first, _, _ = strings.Cut(first, ";")
+	return normalizeLocale(first)
+}
+
+// tourGuideSystem 導遊角色的 system instruction
+func tourGuideSystem(locale string) (string, PromptRef) {
+	text, ref, err := prompts.Render("tour_guide_system", locale, nil)
+	if err != nil {
+		// 模板在啟動時已檢查過，這裡理論上不會發生
+		return tourGuideSystemPrompt, ref
+	}
+	return text, ref
+}
+
+// listPrompts 列出所有 prompt 模板與版本：GET /api/prompts
+func listPrompts(c *gin.Context) {
+	c.JSON(200, prompts.List())
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPromptRegistryRender(t *testing.T) {
+	text, ref, err := prompts.Render("iata", "ja-JP", IATAPromptVars{Location: "大阪"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != (PromptRef{Name: "iata", Version: "v1", Locale: "ja"}) || !strings.Contains(text, `"大阪"`) {
+		t.Errorf("ref = %v text = %q", ref, text)
+	}
+
+	// 沒有的語系退回 zh-TW
+	text, ref, err = prompts.Render("tour_guide_system", "fr", nil)
+	if err != nil || ref.Locale != "zh-TW" || text != tourGuideSystemPrompt {
+		t.Errorf("fallback = %q %v %v", text, ref, err)
+	}
+
+	trip := Trip{Region: "京都", Days: 3}
+	text, _, err = prompts.Render("trip_planning", "zh-TW", TripPromptVars{Trip: trip})
+	if err != nil || !strings.Contains(text, `"region":"京都"`) || !strings.Contains(text, "3天行程") {
+		t.Errorf("trip_planning = %q %v", text, err)
+	}
+
+	if _, _, err := prompts.Render("iata", "en", TripPromptVars{}); err == nil {
+		t.Error("expected error for wrong variable type")
+	}
+}
+
+func TestPlanPrompts(t *testing.T) {
+	trip := Trip{Region: "京都", Days: 3, People: 2, Preferences: Preferences{Pace: "悠閒", Types: []string{"寺廟", "美食"}}}
+	text, ref, err := prompts.Render("plan_generate", "zh-TW", PlanGeneratePromptVars{Trip: trip})
+	if err != nil || ref.Name != "plan_generate" || !strings.Contains(text, "地區：京都") || !strings.Contains(text, "偏好類型：寺廟、美食") ||
+		!strings.Contains(text, "days 必須剛好 3 筆") || strings.Contains(text, "交通方式：") || strings.Contains(text, "上一次") {
+		t.Errorf("plan_generate = %q %v", text, err)
+	}
+	// 重試時附上違規項目
+	text, _, _ = prompts.Render("plan_generate", "en", PlanGeneratePromptVars{Trip: trip, Problems: []string{"days 應有 3 筆，實際為 2 筆"}})
+	if !strings.HasSuffix(text, "- days 應有 3 筆，實際為 2 筆") {
+		t.Errorf("retry = %q", text)
+	}
+
+	plan := []Day{{DayIndex: 1, Items: []Item{{ID: "d1-1", Title: "清水寺"}}}}
+	text, ref, err = prompts.Render("plan_proposal", "ja", PlanProposalPromptVars{Trip: trip, Plan: plan, Message: "雨の日"})
+	if err != nil || ref.Locale != "ja" || !strings.Contains(text, `"id": "d1-1"`) || !strings.Contains(text, "雨の日") {
+		t.Errorf("plan_proposal = %q %v", text, err)
+	}
+}
+
+func TestPromptRegistryVersions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"prompts/tour_guide_system/v1.zh-TW.tmpl":  {Data: []byte("v1")},
+		"prompts/tour_guide_system/v2.zh-TW.tmpl":  {Data: []byte("v2")},
+		"prompts/tour_guide_system/v10.zh-TW.tmpl": {Data: []byte("v10")},
+		"prompts/iata/v1.zh-TW.tmpl":               {Data: []byte("{{.Location}}")},
+		"prompts/trip_planning/v1.zh-TW.tmpl":      {Data: []byte("{{.Trip.Region}}")},
+		"prompts/trip_context/v1.zh-TW.tmpl":       {Data: []byte("{{json .Trip}}")},
+		"prompts/chat_summary/v1.zh-TW.tmpl":       {Data: []byte("{{.Transcript}}")},
+		"prompts/day_regenerate/v1.zh-TW.tmpl":     {Data: []byte("{{.Day.DayIndex}}")},
+		"prompts/photo_place/v1.zh-TW.tmpl":        {Data: []byte("{{.Region}}")},
+		"prompts/trip_from_text/v1.zh-TW.tmpl":     {Data: []byte("{{.Text}}")},
+		"prompts/plan_generate/v1.zh-TW.tmpl":      {Data: []byte("{{.Trip.Days}}")},
+		"prompts/plan_proposal/v1.zh-TW.tmpl":      {Data: []byte("{{.Message}}")},
+	}
+	r, err := loadPromptRegistry(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.Versions("tour_guide_system"), ","); got != "v1,v2,v10" {
+		t.Errorf("versions = %s", got)
+	}
+
+	text, ref, _ := r.Render("tour_guide_system", "", nil)
+	if text != "v10" || ref.Version != "v10" {
+		t.Errorf("latest = %q %v", text, ref)
+	}
+
+	t.Setenv("PROMPT_VERSION_TOUR_GUIDE_SYSTEM", "v2")
+	if text, _, _ := r.Render("tour_guide_system", "", nil); text != "v2" {
+		t.Errorf("pinned = %q", text)
+	}
+}
+
+func TestPromptRegistryRejectsUnknownField(t *testing.T) {
+	fsys := fstest.MapFS{
+		"prompts/tour_guide_system/v1.zh-TW.tmpl": {Data: []byte("x")},
+		"prompts/iata/v1.zh-TW.tmpl":              {Data: []byte("{{.City}}")},
+		"prompts/trip_planning/v1.zh-TW.tmpl":     {Data: []byte("x")},
+	}
+	if _, err := loadPromptRegistry(fsys); err == nil || !strings.Contains(err.Error(), "iata") {
+		t.Errorf("err = %v, want error for unknown field", err)
+	}
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	cases := map[string]string{"": "zh-TW", "zh": "zh-TW", "zh-Hant-TW": "zh-TW", "EN-us": "en", "ja": "ja", "ko": "zh-TW"}
+	for in, want := range cases {
+		if got := normalizeLocale(in); got != want {
+			t.Errorf("normalizeLocale(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
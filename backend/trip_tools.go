@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ========== 聊天工具 (function calling) ==========
+//
+// 對話綁定 session 時，模型可以透過以下工具直接修改行程。
+// 工具只能操作 session 所屬的行程，參數裡不接受其他 trip_id。
+
+// tripToolbox 綁定單一行程的工具集合，並記錄這一輪套用的修改
+type tripToolbox struct {
+	tripID    int
+	mutations []PlanMutation
+
+	// 預設為 findTripByID / saveTripPlanAt，測試時可替換
+	load func(ctx context.Context, id int) (Trip, error)
+	save func(ctx context.Context, id int, plan []Day, expected int) error
+}
+
+func newTripToolbox(tripID int) *tripToolbox {
+	return &tripToolbox{tripID: tripID, load: findTripByID, save: saveTripPlanAt}
+}
+
+// tripTools 提供給模型的工具定義
+func tripTools() []LLMTool {
+	itemID := &JSONSchema{Type: "string", Description: "item 的 id，例如 d2-3 (可先呼叫 get_trip 取得)"}
+	dayIndex := &JSONSchema{Type: "integer", Description: "第幾天，從 1 開始"}
+	clock := &JSONSchema{Type: "string", Description: "開始時間，24 小時制 HH:MM"}
+
+	return []LLMTool{
+		{
+			Name:        "get_trip",
+			Description: "取得目前行程的設定與完整 plan (每天的 items 與 id)",
+		},
+		{
+			Name:        "add_item",
+			Description: "在指定的那一天新增一個景點或餐廳",
+			Parameters: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"day_index":    dayIndex,
+					"time":         clock,
+					"duration_min": {Type: "integer", Description: "停留分鐘數"},
+					"title":        {Type: "string", Description: "景點或餐廳名稱"},
+					"address":      {Type: "string", Description: "地址"},
+					"note":         {Type: "string", Description: "介紹、交通方式與注意事項"},
+				},
+				Required: []string{"day_index", "time", "title"},
+			},
+		},
+		{
+			Name:        "move_item",
+			Description: "將 item 移到另一天，或調整到同一天的其他時間",
+			Parameters: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"item_id":      itemID,
+					"to_day_index": dayIndex,
+					"time":         {Type: "string", Description: "新的開始時間 HH:MM，省略則維持原時間"},
+				},
+				Required: []string{"item_id", "to_day_index"},
+			},
+		},
+		{
+			Name:        "update_item",
+			Description: "修改 item 的內容，只需要傳入要改的欄位",
+			Parameters: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"item_id":      itemID,
+					"time":         clock,
+					"duration_min": {Type: "integer", Description: "停留分鐘數"},
+					"title":        {Type: "string", Description: "景點或餐廳名稱"},
+					"address":      {Type: "string", Description: "地址"},
+					"note":         {Type: "string", Description: "介紹、交通方式與注意事項"},
+				},
+				Required: []string{"item_id"},
+			},
+		},
+		{
+			Name:        "remove_item",
+			Description: "從行程中刪除 item",
+			Parameters: &JSONSchema{
+				Type:       "object",
+				Properties: map[string]*JSONSchema{"item_id": itemID},
+				Required:   []string{"item_id"},
+			},
+		},
+		{
+			Name:        "set_day_note",
+			Description: "設定某一天的主題或備註",
+			Parameters: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"day_index": dayIndex,
+					"note":      {Type: "string", Description: "當天主題或備註"},
+				},
+				Required: []string{"day_index", "note"},
+			},
+		},
+	}
+}
+
+// call 執行模型要求的工具，實作 LLMToolFunc
+func (tb *tripToolbox) call(ctx context.Context, tc LLMToolCall) (map[string]any, error) {
+	// 工具只能操作綁定的行程
+	if v, ok := tc.Args["trip_id"]; ok && fmt.Sprint(v) != fmt.Sprint(tb.tripID) {
+		return nil, fmt.Errorf("只能修改目前對話所屬的行程 (id %d)", tb.tripID)
+	}
+
+	trip, err := tb.load(ctx, tb.tripID)
+	if err != nil {
+		return nil, fmt.Errorf("讀取行程失敗: %w", err)
+	}
+	plan := trip.Plan
+	if len(plan) == 0 {
+		plan = expandDays(trip.StartDate, trip.Days)
+	}
+
+	var m PlanMutation
+	var result any
+
+	switch tc.Name {
+	case "get_trip":
+		trip.Plan = plan
+		return toToolMap(map[string]any{"trip": tripWithoutPhotos(trip)})
+
+	case "add_item":
+		var args struct {
+			DayIndex int `json:"day_index"`
+			Item
+		}
+		if err := decodeToolArgs(tc.Args, &args); err != nil {
+			return nil, err
+		}
+		args.Item.ID = ""
+		it, err := planAddItem(plan, args.DayIndex, args.Item)
+		if err != nil {
+			return nil, err
+		}
+		m = PlanMutation{Op: tc.Name, DayIndex: args.DayIndex, ItemID: it.ID,
+			Summary: fmt.Sprintf("第 %d 天 %s 新增「%s」", args.DayIndex, it.Time, it.Title)}
+		result = it
+
+	case "move_item":
+		var args struct {
+			ItemID     string `json:"item_id"`
+			ToDayIndex int    `json:"to_day_index"`
+			Time       string `json:"time"`
+		}
+		if err := decodeToolArgs(tc.Args, &args); err != nil {
+			return nil, err
+		}
+		it, from, err := planMoveItem(plan, args.ItemID, args.ToDayIndex, args.Time)
+		if err != nil {
+			return nil, err
+		}
+		m = PlanMutation{Op: tc.Name, DayIndex: args.ToDayIndex, ItemID: it.ID,
+			Summary: fmt.Sprintf("「%s」從第 %d 天移到第 %d 天 %s", it.Title, from, args.ToDayIndex, it.Time)}
+		result = it
+
+	case "update_item":
+		var args struct {
+			ItemID string `json:"item_id"`
+			ItemPatch
+		}
+		if err := decodeToolArgs(tc.Args, &args); err != nil {
+			return nil, err
+		}
+		_, after, err := planUpdateItem(plan, args.ItemID, args.ItemPatch)
+		if err != nil {
+			return nil, err
+		}
+		d, _, _ := findPlanItem(plan, args.ItemID)
+		m = PlanMutation{Op: tc.Name, DayIndex: plan[d].DayIndex, ItemID: after.ID,
+			Summary: fmt.Sprintf("修改第 %d 天的「%s」", plan[d].DayIndex, after.Title)}
+		result = after
+
+	case "remove_item":
+		var args struct {
+			ItemID string `json:"item_id"`
+		}
+		if err := decodeToolArgs(tc.Args, &args); err != nil {
+			return nil, err
+		}
+		it, day, err := planRemoveItem(plan, args.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		m = PlanMutation{Op: tc.Name, DayIndex: day, ItemID: it.ID,
+			Summary: fmt.Sprintf("刪除第 %d 天的「%s」", day, it.Title)}
+		result = it
+
+	case "set_day_note":
+		var args struct {
+			DayIndex int    `json:"day_index"`
+			Note     string `json:"note"`
+		}
+		if err := decodeToolArgs(tc.Args, &args); err != nil {
+			return nil, err
+		}
+		if _, err := planSetDayNote(plan, args.DayIndex, args.Note); err != nil {
+			return nil, err
+		}
+		m = PlanMutation{Op: tc.Name, DayIndex: args.DayIndex,
+			Summary: fmt.Sprintf("第 %d 天備註改為「%s」", args.DayIndex, args.Note)}
+		result = map[string]any{"day_index": args.DayIndex, "note": args.Note}
+
+	default:
+		return nil, fmt.Errorf("未知的工具 %s", tc.Name)
+	}
+
+	// 只在行程沒有被其他請求改過時寫入，衝突時交由模型重新讀取，不覆寫別人的修改
+	if err := tb.save(ctx, tb.tripID, plan, trip.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			return nil, errors.New("行程剛被其他人修改，這次修改沒有套用；請先呼叫 get_trip 取得最新內容再試")
+		}
+		return nil, fmt.Errorf("儲存行程失敗: %w", err)
+	}
+	tb.mutations = append(tb.mutations, m)
+
+	return toToolMap(map[string]any{"ok": true, "result": result})
+}
+
+// decodeToolArgs 將模型傳來的參數轉成結構
+func decodeToolArgs(args map[string]any, v any) error {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("參數格式錯誤: %w", err)
+	}
+	return nil
+}
+
+// toToolMap 將結果轉成只含基本型別的 map (Gemini 的 FunctionResponse 需要)
+func toToolMap(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// memoryTrip 以記憶體中的 Trip 取代 Mongo
+func memoryTrip(tb *tripToolbox, trip *Trip) {
+	tb.load = func(ctx context.Context, id int) (Trip, error) {
+		t := *trip
+		t.Plan = append([]Day(nil), trip.Plan...)
+		for i := range t.Plan {
+			t.Plan[i].Items = append([]Item(nil), trip.Plan[i].Items...)
+		}
+		return t, nil
+	}
+	tb.save = func(ctx context.Context, id int, plan []Day, expected int) error {
+		if trip.Version != expected {
+			return errVersionConflict
+		}
+		trip.Plan = plan
+		trip.Version++
+		return nil
+	}
+}
+
+func TestTripToolboxWithFakeProvider(t *testing.T) {
+	trip := &Trip{
+		ID: 1, StartDate: "2025-04-01", Days: 2,
+		Plan: []Day{
+			{DayIndex: 1, Items: []Item{{ID: "d1-1", Time: "09:00", Title: "淺草寺"}, {ID: "d1-2", Time: "14:00", Title: "晴空塔"}}},
+			{DayIndex: 2, Items: []Item{{ID: "d2-1", Time: "10:00", Title: "明治神宮"}}},
+		},
+	}
+	tb := newTripToolbox(1)
+	memoryTrip(tb, trip)
+
+	llm := newFakeProvider().
+		OnTool("拉麵", "add_item", map[string]any{"day_index": 2.0, "time": "19:00", "title": "一蘭拉麵"}).
+		OnTool("拉麵", "move_item", map[string]any{"item_id": "d1-2", "to_day_index": 2.0, "time": "15:00"}).
+		OnTool("拉麵", "set_day_note", map[string]any{"day_index": 2.0, "note": "輕鬆的一天"}).
+		OnTool("拉麵", "remove_item", map[string]any{"item_id": "d9-9"}).
+		On("拉麵", "已幫你加入拉麵")
+
+	res, err := llm.ChatWithTools(context.Background(), LLMRequest{Prompt: "第二天晚餐想吃拉麵"}, tripTools(), tb.call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Text != "已幫你加入拉麵" {
+		t.Errorf("reply = %q", res.Text)
+	}
+
+	// 不存在的 item 不算修改
+	if len(tb.mutations) != 3 {
+		t.Fatalf("mutations = %+v", tb.mutations)
+	}
+	day2 := trip.Plan[1]
+	var titles []string
+	for _, it := range day2.Items {
+		titles = append(titles, it.Time+" "+it.Title)
+	}
+	if got := strings.Join(titles, ","); got != "10:00 明治神宮,15:00 晴空塔,19:00 一蘭拉麵" {
+		t.Errorf("day 2 = %s", got)
+	}
+	if day2.Note != "輕鬆的一天" || len(trip.Plan[0].Items) != 1 {
+		t.Errorf("plan = %+v", trip.Plan)
+	}
+}
+
+func TestTripToolboxRejectsOtherTrip(t *testing.T) {
+	trip := &Trip{ID: 1, StartDate: "2025-04-01", Days: 1}
+	tb := newTripToolbox(1)
+	memoryTrip(tb, trip)
+
+	if _, err := tb.call(context.Background(), LLMToolCall{Name: "set_day_note", Args: map[string]any{"trip_id": 2.0, "day_index": 1.0, "note": "x"}}); err == nil {
+		t.Error("expected error for another trip_id")
+	}
+
+	// 空的 plan 會依天數展開後再修改
+	if _, err := tb.call(context.Background(), LLMToolCall{Name: "add_item", Args: map[string]any{"trip_id": 1.0, "day_index": 1.0, "time": "09:00", "title": "築地"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(trip.Plan) != 1 || trip.Plan[0].Items[0].ID != "d1-1" || trip.Plan[0].Date != "2025-04-01" {
+		t.Errorf("plan = %+v", trip.Plan)
+	}
+}
+
+func TestTripToolboxVersionConflict(t *testing.T) {
+	trip := &Trip{ID: 1, StartDate: "2025-04-01", Days: 1, Version: 3,
+		Plan: []Day{{DayIndex: 1, Items: []Item{{ID: "d1-1", Time: "09:00", Title: "淺草寺"}}}}}
+	tb := newTripToolbox(1)
+	memoryTrip(tb, trip)
+
+	// 讀取之後行程被手動編輯，工具的修改不能覆寫
+	load := tb.load
+	tb.load = func(ctx context.Context, id int) (Trip, error) {
+		t, err := load(ctx, id)
+		trip.Plan = []Day{{DayIndex: 1, Items: []Item{{ID: "d1-1", Time: "09:00", Title: "淺草寺"}, {ID: "d1-2", Time: "12:00", Title: "手動新增"}}}}
+		trip.Version++
+		return t, err
+	}
+	res := toolResult(context.Background(), tb.call, LLMToolCall{Name: "remove_item", Args: map[string]any{"item_id": "d1-1"}})
+	if msg, _ := res["error"].(string); !strings.Contains(msg, "get_trip") {
+		t.Errorf("result = %+v", res)
+	}
+	if len(tb.mutations) != 0 || trip.Version != 4 || len(trip.Plan[0].Items) != 2 {
+		t.Errorf("plan overwritten: version %d, %+v, mutations %+v", trip.Version, trip.Plan, tb.mutations)
+	}
+
+	// 重新讀取後可以正常套用
+	tb.load = load
+	if _, err := tb.call(context.Background(), LLMToolCall{Name: "remove_item", Args: map[string]any{"item_id": "d1-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if trip.Version != 5 || len(trip.Plan[0].Items) != 1 || trip.Plan[0].Items[0].ID != "d1-2" {
+		t.Errorf("version %d, plan %+v", trip.Version, trip.Plan)
+	}
+}
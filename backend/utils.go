@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 // ========== 輔助函數 ==========
 func expandDays(startDate string, days int) []Day {
@@ -18,3 +22,11 @@ func expandDays(startDate string, days int) []Day {
 
 	return result
 }
+
+// requestUserID 取得呼叫者的使用者 ID (X-User-ID header)，沒有登入機制時一律為 anonymous
+func requestUserID(c *gin.Context) string {
+	if id := c.GetHeader("X-User-ID"); id != "" {
+		return id
+	}
+	return "anonymous"
+}